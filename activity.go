@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// activityWeek returns the ISO week of a time, formatted as YYYY-Www.
+func activityWeek(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%04d-W%02d", year, week)
+}
+
+// activityStreak returns the number of consecutive active weeks ending at the week of now, or at
+// the previous week when the current one has no activity yet.
+func activityStreak(weeks map[string]int, now time.Time) int {
+	t := now
+	if weeks[activityWeek(t)] == 0 {
+		t = t.AddDate(0, 0, -7)
+	}
+	streak := 0
+	for weeks[activityWeek(t)] > 0 {
+		streak++
+		t = t.AddDate(0, 0, -7)
+	}
+	return streak
+}
+
+// recordActivity counts the effective message towards the activity of its sender in the chat.
+func (tg *Telegram) recordActivity(ctx *ext.Context) {
+	if ctx.EffectiveMessage.From == nil {
+		return
+	}
+	chatID, userID := ChatID(ctx.EffectiveMessage.Chat.Id), UserID(ctx.EffectiveMessage.From.Id)
+	err := tg.db.RecordUserActivity(chatID, userID, time.Now())
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Int64("user_id", int64(userID)).Msg("Failed to record user activity")
+	}
+}
+
+// handleMrlProfileRequest processes the /mrl_profile command, which shows the activity of the
+// sender, or of the sender of the replied message, in the chat.
+func (tg *Telegram) handleMrlProfileRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_PROFILE request")
+
+	user := ctx.EffectiveMessage.From
+	if reply := ctx.EffectiveMessage.ReplyToMessage; reply != nil && reply.From != nil {
+		user = reply.From
+	}
+	name := user.FirstName
+	if user.Username != "" {
+		name = "@" + user.Username
+	}
+
+	activity, found, err := tg.db.GetUserActivity(ChatID(ctx.EffectiveMessage.Chat.Id), UserID(user.Id))
+	if err != nil {
+		return WrapError("failed to get user activity", err)
+	}
+	if !found {
+		return tg.sendTelegramMessage(ctx, fmt.Sprintf("Nenhuma atividade registrada para %s neste chat.", name))
+	}
+
+	now := time.Now()
+	text := fmt.Sprintf("Atividade de %s neste chat:\nPrimeira mensagem: %s\nÚltima mensagem: %s\nMensagens: %d\nMensagens esta semana: %d\nSequência: %d semana(s)",
+		name, activity.FirstSeen.Format("2006-01-02"), activity.LastSeen.Format("2006-01-02 15:04"), activity.Messages,
+		activity.Weeks[activityWeek(now)], activityStreak(activity.Weeks, now))
+	return tg.sendTelegramMessage(ctx, text)
+}
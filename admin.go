@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// adminLinkPrefix prefixes the /start payload of admin deep links.
+const adminLinkPrefix = "admin_"
+
+// adminChatSetting is the settings key storing the chat bound for admin messages.
+const adminChatSetting = "admin_chat_id"
+
+// AdminLink holds the one-time nonce of the deep link binding the admin's private chat.
+type AdminLink struct {
+	mu    sync.Mutex
+	nonce string // Nonce expected in the /start payload
+}
+
+// newAdminNonce generates a random nonce for admin deep links.
+func newAdminNonce() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", WrapError("failed to generate nonce", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Rotate replaces the nonce and returns the new one.
+func (link *AdminLink) Rotate() (string, error) {
+	nonce, err := newAdminNonce()
+	if err != nil {
+		return "", err
+	}
+	link.mu.Lock()
+	link.nonce = nonce
+	link.mu.Unlock()
+	return nonce, nil
+}
+
+// Matches reports whether a /start payload carries the current nonce.
+func (link *AdminLink) Matches(payload string) bool {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	nonce, ok := strings.CutPrefix(payload, adminLinkPrefix)
+	return ok && link.nonce != "" && subtle.ConstantTimeCompare([]byte(nonce), []byte(link.nonce)) == 1
+}
+
+// logAdminLink rotates the admin nonce and logs the deep link to bind the admin chat, so only
+// the operator with access to the logs can use it.
+func (tg *Telegram) logAdminLink() {
+	nonce, err := tg.adminLink.Rotate()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate admin link")
+		return
+	}
+	log.Info().Str("link", fmt.Sprintf("https://t.me/%s?start=%s%s", tg.bot.User.Username, adminLinkPrefix, nonce)).Msg("Open this link as the admin to bind your private chat for alerts")
+}
+
+// bindAdminChat handles an admin deep link, binding the private chat it was opened in.
+func (tg *Telegram) bindAdminChat(ctx *ext.Context, payload string) error {
+	msg := ctx.EffectiveMessage
+	if msg.Chat.Type != "private" || UserID(msg.From.Id) != tg.config.TelegramAdminUID || !tg.adminLink.Matches(payload) {
+		log.Warn().Int64("user_id", msg.From.Id).Str("username", msg.From.Username).Msg("Rejected admin link")
+		return tg.sendTelegramMessage(ctx, "Invalid or expired link.")
+	}
+
+	err := tg.db.SetSetting(adminChatSetting, strconv.FormatInt(msg.Chat.Id, 10))
+	if err != nil {
+		return WrapError("failed to store admin chat", err)
+	}
+	log.Info().Int64("chat_id", msg.Chat.Id).Msg("Bound admin chat")
+	tg.logAdminLink()
+
+	return tg.sendTelegramMessage(ctx, "This chat will now receive admin alerts.")
+}
+
+// adminChatID returns the chat bound for admin messages, defaulting to the admin's user ID.
+func (tg *Telegram) adminChatID() ChatID {
+	value, ok, err := tg.db.GetSetting(adminChatSetting)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get admin chat")
+	}
+	if !ok {
+		return ChatID(tg.config.TelegramAdminUID)
+	}
+	chatID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Str("value", value).Msg("Invalid admin chat setting")
+		return ChatID(tg.config.TelegramAdminUID)
+	}
+	return ChatID(chatID)
+}
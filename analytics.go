@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Analytics event names.
+const (
+	AnalyticsMessageSaved    = "message_saved"    // A forwarded message was stored
+	AnalyticsMentionAnswered = "mention_answered" // A /mrl request was answered
+	AnalyticsTokensUsed      = "tokens_used"      // Estimated tokens of a model call
+	AnalyticsJobRun          = "job_run"          // A background job finished
+	AnalyticsModelChanged    = "model_changed"    // A different model answered in a chat
+)
+
+// analyticsHeader is the header row of analytics files.
+var analyticsHeader = []string{"time", "event", "chat_id", "user_id", "data"}
+
+// Analytics appends structured events to daily CSV files for offline analysis. It does nothing
+// when no directory is configured.
+type Analytics struct {
+	Dir     string   // Directory the files are written to, disabled if empty
+	Metrics *Metrics // Metrics counting the events, none if nil
+	mu      sync.Mutex
+	day     string
+	file    *os.File
+	writer  *csv.Writer
+	closed  bool
+}
+
+// NewAnalytics creates an analytics sink from the configuration.
+func NewAnalytics(config *Config) *Analytics {
+	return &Analytics{Dir: config.AnalyticsDir}
+}
+
+// Record counts an event in the metrics and appends it with its data, encoded as JSON, to the
+// file of the current day.
+func (analytics *Analytics) Record(event string, chatID ChatID, userID UserID, data map[string]interface{}) {
+	if analytics == nil {
+		return
+	}
+	analytics.Metrics.observeEvent(event, data)
+	if analytics.Dir == "" {
+		return
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Error().Err(err).Str("event", event).Msg("Failed to marshal analytics event")
+		return
+	}
+
+	analytics.mu.Lock()
+	defer analytics.mu.Unlock()
+	if analytics.closed {
+		log.Warn().Str("event", event).Msg("Dropping analytics event during shutdown")
+		return
+	}
+
+	now := time.Now().UTC()
+	err = analytics.rotate(now.Format("2006-01-02"))
+	if err != nil {
+		log.Error().Err(err).Str("event", event).Msg("Failed to open analytics file")
+		return
+	}
+	err = analytics.writer.Write([]string{
+		now.Format(time.RFC3339Nano), event, strconv.FormatInt(int64(chatID), 10), strconv.FormatInt(int64(userID), 10), string(payload),
+	})
+	if err == nil {
+		analytics.writer.Flush()
+		err = analytics.writer.Error()
+	}
+	if err != nil {
+		log.Error().Err(err).Str("event", event).Msg("Failed to write analytics event")
+	}
+}
+
+// Close closes the current file. Events recorded afterwards are dropped.
+func (analytics *Analytics) Close() error {
+	analytics.mu.Lock()
+	defer analytics.mu.Unlock()
+
+	analytics.closed = true
+	if analytics.file == nil {
+		return nil
+	}
+	err := analytics.file.Close()
+	analytics.file = nil
+	if err != nil {
+		return WrapError("failed to close analytics file", err)
+	}
+	return nil
+}
+
+// rotate makes the writer point to the file of the given day, writing the header to new files.
+func (analytics *Analytics) rotate(day string) error {
+	if analytics.file != nil && analytics.day == day {
+		return nil
+	}
+	if analytics.file != nil {
+		analytics.file.Close()
+		analytics.file = nil
+	}
+
+	err := os.MkdirAll(analytics.Dir, 0o755)
+	if err != nil {
+		return WrapError("failed to create analytics directory", err)
+	}
+	file, err := os.OpenFile(filepath.Join(analytics.Dir, "events-"+day+".csv"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return WrapError("failed to open analytics file", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return WrapError("failed to stat analytics file", err)
+	}
+
+	analytics.file = file
+	analytics.day = day
+	analytics.writer = csv.NewWriter(file)
+	if info.Size() == 0 {
+		err = analytics.writer.Write(analyticsHeader)
+		if err != nil {
+			return WrapError("failed to write analytics header", err)
+		}
+	}
+	return nil
+}
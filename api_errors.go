@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Kinds of Telegram API errors.
+const (
+	APIErrorRateLimited = "rate_limited" // 429 Too Many Requests
+	APIErrorBadRequest  = "bad_request"  // 400 Bad Request
+	APIErrorForbidden   = "forbidden"    // 403 Forbidden
+	APIErrorOther       = "other"        // Any other error code
+)
+
+// API error counter limits.
+const (
+	apiErrorFlushInterval = 5 * time.Minute // How often the counters are persisted
+	apiErrorSamplesKept   = 5               // Number of recent samples kept per kind
+	apiErrorSamplesShown  = 3               // Number of recent samples shown in /mrl_stats
+)
+
+// apiErrorKind returns the kind of a Telegram API error code.
+func apiErrorKind(code int) string {
+	switch code {
+	case http.StatusTooManyRequests:
+		return APIErrorRateLimited
+	case http.StatusBadRequest:
+		return APIErrorBadRequest
+	case http.StatusForbidden:
+		return APIErrorForbidden
+	default:
+		return APIErrorOther
+	}
+}
+
+// APIErrorCounter counts Telegram API errors by kind until they are persisted.
+type APIErrorCounter struct {
+	mu      sync.Mutex
+	counts  map[string]int64 // Errors by kind since the last flush
+	samples []APIErrorSample // Errors since the last flush, at most apiErrorSamplesKept per kind
+}
+
+// NewAPIErrorCounter creates a new API error counter.
+func NewAPIErrorCounter() *APIErrorCounter {
+	return &APIErrorCounter{counts: make(map[string]int64)}
+}
+
+// record counts an error, keeping it as a sample.
+func (counter *APIErrorCounter) record(sample APIErrorSample) {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	counter.counts[sample.Kind]++
+	counter.samples = append(counter.samples, sample)
+	kept := 0
+	for i := len(counter.samples) - 1; i >= 0; i-- {
+		if counter.samples[i].Kind == sample.Kind {
+			kept++
+		}
+		if kept > apiErrorSamplesKept {
+			counter.samples = append(counter.samples[:i], counter.samples[i+1:]...)
+			break
+		}
+	}
+}
+
+// take returns the errors counted since the last call and resets the counter.
+func (counter *APIErrorCounter) take() (map[string]int64, []APIErrorSample) {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	counts, samples := counter.counts, counter.samples
+	counter.counts, counter.samples = make(map[string]int64), nil
+	return counts, samples
+}
+
+// restore adds back errors that could not be persisted.
+func (counter *APIErrorCounter) restore(counts map[string]int64, samples []APIErrorSample) {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	for kind, count := range counts {
+		counter.counts[kind] += count
+	}
+	counter.samples = append(samples, counter.samples...)
+}
+
+// flushAPIErrors persists the API errors counted since the last flush.
+func (tg *Telegram) flushAPIErrors() error {
+	counts, samples := tg.apiErrors.take()
+	if len(counts) == 0 {
+		return nil
+	}
+	err := tg.db.AddAPIErrors(counts, samples, apiErrorSamplesKept)
+	if err != nil {
+		tg.apiErrors.restore(counts, samples)
+		return WrapError("failed to persist API errors", err)
+	}
+	return nil
+}
+
+// runAPIErrorFlush periodically persists the API error counters, and once more when stopped.
+func (tg *Telegram) runAPIErrorFlush() {
+	ticker := time.NewTicker(apiErrorFlushInterval)
+	defer ticker.Stop()
+	flush := func() {
+		err := tg.flushAPIErrors()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to flush API error counters")
+		}
+	}
+	for {
+		select {
+		case <-tg.stop:
+			flush()
+			return
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// apiErrorSummary returns the persisted API error counters and the most recent samples as text.
+func (tg *Telegram) apiErrorSummary() (string, error) {
+	err := tg.flushAPIErrors()
+	if err != nil {
+		return "", err
+	}
+	counts, err := tg.db.GetAPIErrorCounts()
+	if err != nil {
+		return "", WrapError("failed to get API error counts", err)
+	}
+	if len(counts) == 0 {
+		return "Telegram API errors: none", nil
+	}
+	var parts []string
+	for _, count := range counts {
+		parts = append(parts, fmt.Sprintf("%s %d (last %s)", count.Kind, count.Count, count.LastSeen.Format(time.RFC3339)))
+	}
+	text := "Telegram API errors: " + strings.Join(parts, ", ")
+
+	samples, err := tg.db.GetRecentAPIErrorSamples(apiErrorSamplesShown)
+	if err != nil {
+		return "", WrapError("failed to get API error samples", err)
+	}
+	for _, sample := range samples {
+		text += fmt.Sprintf("\n- %s %s in chat %d: %s", sample.CreatedAt.Format(time.RFC3339), sample.Kind, sample.ChatID, sample.Description)
+	}
+	return text, nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// chatApprovalSetting is the chat settings key marking chats whose replies need the admin's
+// approval before being posted.
+const chatApprovalSetting = "approval"
+
+// approvalCallbackPrefix prefixes the callback data of the approval buttons.
+const approvalCallbackPrefix = "approval:"
+
+// pendingApproval is a generated reply waiting for the admin's decision.
+type pendingApproval struct {
+	chatID         ChatID                                 // Chat the reply is meant for
+	content        string                                 // Generated reply
+	responseID     string                                 // ID of the provider-side response, when threading is enabled
+	adminMessageID int64                                  // ID of the approval request in the admin chat
+	editing        bool                                   // Whether the admin asked to edit the reply
+	deliver        func(content, responseID string) error // Posts the reply to the chat
+	timer          *time.Timer                            // Cancels the request when it times out
+}
+
+// ApprovalQueue holds the replies waiting for approval.
+type ApprovalQueue struct {
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]*pendingApproval
+}
+
+// NewApprovalQueue creates a new approval queue.
+func NewApprovalQueue() *ApprovalQueue {
+	return &ApprovalQueue{pending: make(map[int]*pendingApproval)}
+}
+
+// add queues a reply and returns its ID. The expire function is called with the reply when it is
+// still pending after the timeout.
+func (queue *ApprovalQueue) add(approval *pendingApproval, timeout time.Duration, expire func(*pendingApproval)) int {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.nextID++
+	id := queue.nextID
+	queue.pending[id] = approval
+	approval.timer = time.AfterFunc(timeout, func() {
+		if expired := queue.take(id); expired != nil {
+			expire(expired)
+		}
+	})
+	return id
+}
+
+// take removes a pending reply from the queue, returning nil when it is no longer pending.
+func (queue *ApprovalQueue) take(id int) *pendingApproval {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	approval, ok := queue.pending[id]
+	if !ok {
+		return nil
+	}
+	delete(queue.pending, id)
+	approval.timer.Stop()
+	return approval
+}
+
+// takeAll removes all pending replies from the queue.
+func (queue *ApprovalQueue) takeAll() []*pendingApproval {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	approvals := make([]*pendingApproval, 0, len(queue.pending))
+	for id, approval := range queue.pending {
+		delete(queue.pending, id)
+		approval.timer.Stop()
+		approvals = append(approvals, approval)
+	}
+	return approvals
+}
+
+// startEditing marks a pending reply as waiting for the admin's edited text.
+func (queue *ApprovalQueue) startEditing(id int) bool {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	approval, ok := queue.pending[id]
+	if ok {
+		approval.editing = true
+	}
+	return ok
+}
+
+// editing returns the ID of the reply being edited whose approval request has the given message
+// ID, or zero when there is none.
+func (queue *ApprovalQueue) editing(adminMessageID int64) int {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	for id, approval := range queue.pending {
+		if approval.editing && approval.adminMessageID == adminMessageID {
+			return id
+		}
+	}
+	return 0
+}
+
+// requiresApproval reports whether replies in a chat need the admin's approval. When the setting
+// cannot be read, approval is required.
+func (tg *Telegram) requiresApproval(chatID ChatID) bool {
+	_, enabled, err := tg.db.GetChatSetting(chatID, chatApprovalSetting)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to get approval setting")
+		return true
+	}
+	return enabled
+}
+
+// approvalKeyboard returns the buttons of an approval request.
+func approvalKeyboard(id int) gotgbot.InlineKeyboardMarkup {
+	data := func(action string) string {
+		return fmt.Sprintf("%s%s:%d", approvalCallbackPrefix, action, id)
+	}
+	return gotgbot.InlineKeyboardMarkup{InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{
+		{Text: "Approve", CallbackData: data("approve")},
+		{Text: "Edit", CallbackData: data("edit")},
+		{Text: "Reject", CallbackData: data("reject")},
+	}}}
+}
+
+// requestApproval sends a generated reply to the admin chat for approval. The reply is posted
+// with deliver once approved, and dropped when rejected or not decided within the timeout.
+func (tg *Telegram) requestApproval(ctx *ext.Context, content, responseID string, deliver func(content, responseID string) error) error {
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	approval := &pendingApproval{chatID: chatID, content: content, responseID: responseID, deliver: deliver}
+	timeout := time.Duration(tg.config.TelegramApprovalTimeout * float64(time.Second))
+	id := tg.approvals.add(approval, timeout, func(expired *pendingApproval) {
+		log.Info().Int64("chat_id", int64(expired.chatID)).Msg("Approval request expired")
+		tg.closeApproval(expired, "Expired without a decision.")
+	})
+
+	text := fmt.Sprintf("Reply in %s (%d) to @%s needs approval:\n\n%s\n\nDraft:\n%s",
+		ctx.EffectiveMessage.Chat.Title, chatID, ctx.EffectiveMessage.From.Username, ctx.EffectiveMessage.Text, content)
+	sent, err := tg.newMessage(tg.adminChatID(), text).WithMarkup(approvalKeyboard(id)).Send()
+	if err == nil && sent == nil {
+		err = WrapError("admin chat is blocked")
+	}
+	if err != nil {
+		tg.approvals.take(id)
+		return WrapError("failed to send approval request", err)
+	}
+	tg.approvals.mu.Lock()
+	approval.adminMessageID = sent.MessageId
+	tg.approvals.mu.Unlock()
+	log.Info().Int64("chat_id", int64(chatID)).Int("approval_id", id).Msg("Reply waiting for approval")
+	return nil
+}
+
+// closeApproval replaces an approval request in the admin chat with its outcome, removing the
+// buttons.
+func (tg *Telegram) closeApproval(approval *pendingApproval, outcome string) {
+	if approval.adminMessageID == 0 {
+		return
+	}
+	_, _, err := tg.bot.EditMessageText(fmt.Sprintf("Reply in %d:\n\n%s\n\n%s", approval.chatID, approval.content, outcome), &gotgbot.EditMessageTextOpts{
+		ChatId:    int64(tg.adminChatID()),
+		MessageId: approval.adminMessageID,
+	})
+	if err != nil {
+		log.Warn().Err(err).Int64("message_id", approval.adminMessageID).Msg("Failed to update approval request")
+	}
+}
+
+// handleApprovalCallback processes the buttons of approval requests.
+func (tg *Telegram) handleApprovalCallback(b *gotgbot.Bot, ctx *ext.Context) error {
+	query := ctx.CallbackQuery
+	if UserID(query.From.Id) != tg.config.TelegramAdminUID {
+		_, err := query.Answer(b, &gotgbot.AnswerCallbackQueryOpts{Text: "You are not authorized to do this."})
+		if err != nil {
+			return WrapError("failed to answer callback query", err)
+		}
+		return nil
+	}
+
+	action, rawID, _ := strings.Cut(strings.TrimPrefix(query.Data, approvalCallbackPrefix), ":")
+	id, err := strconv.Atoi(rawID)
+	if err != nil {
+		return WrapError(fmt.Sprintf("invalid approval callback data %q", query.Data), err)
+	}
+	log.Info().Int64("user_id", query.From.Id).Str("action", action).Int("approval_id", id).Msg("Received approval decision")
+
+	answer := ""
+	switch action {
+	case "approve":
+		approval := tg.approvals.take(id)
+		if approval == nil {
+			answer = "This reply is no longer pending."
+			break
+		}
+		err = approval.deliver(approval.content, approval.responseID)
+		if err != nil {
+			tg.closeApproval(approval, "Failed to send.")
+			return WrapError("failed to deliver approved reply", err)
+		}
+		tg.closeApproval(approval, "Approved.")
+	case "reject":
+		approval := tg.approvals.take(id)
+		if approval == nil {
+			answer = "This reply is no longer pending."
+			break
+		}
+		tg.closeApproval(approval, "Rejected.")
+	case "edit":
+		if !tg.approvals.startEditing(id) {
+			answer = "This reply is no longer pending."
+			break
+		}
+		answer = "Reply to the request with the new text."
+	default:
+		return WrapError(fmt.Sprintf("unknown approval action %q", action))
+	}
+
+	_, err = query.Answer(b, &gotgbot.AnswerCallbackQueryOpts{Text: answer})
+	if err != nil {
+		return WrapError("failed to answer callback query", err)
+	}
+	return nil
+}
+
+// isApprovalEdit reports whether a message is the admin's edited text for a pending reply.
+func (tg *Telegram) isApprovalEdit(msg *gotgbot.Message) bool {
+	return msg.ReplyToMessage != nil && msg.From != nil && UserID(msg.From.Id) == tg.config.TelegramAdminUID &&
+		tg.approvals.editing(msg.ReplyToMessage.MessageId) != 0
+}
+
+// handleApprovalEdit posts the admin's edited text in place of a pending reply.
+func (tg *Telegram) handleApprovalEdit(b *gotgbot.Bot, ctx *ext.Context) error {
+	approval := tg.approvals.take(tg.approvals.editing(ctx.EffectiveMessage.ReplyToMessage.MessageId))
+	if approval == nil {
+		return tg.sendTelegramMessage(ctx, "This reply is no longer pending.")
+	}
+	log.Info().Int64("chat_id", int64(approval.chatID)).Msg("Received edited reply")
+
+	approval.content = strings.TrimSpace(ctx.EffectiveMessage.Text)
+	// The edited text differs from the stored provider-side response, so the thread is not continued
+	err := approval.deliver(approval.content, "")
+	if err != nil {
+		tg.closeApproval(approval, "Failed to send.")
+		return WrapError("failed to deliver edited reply", err)
+	}
+	tg.closeApproval(approval, "Sent with edits.")
+	return nil
+}
+
+// handleMrlApprovalRequest processes the /mrl_approval command, which shows or toggles the
+// approval of replies in the chat.
+func (tg *Telegram) handleMrlApprovalRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_APPROVAL request")
+
+	usage := "/mrl_approval <on|off>"
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 1)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	switch args.Arg(0) {
+	case "":
+		state := "off"
+		if tg.requiresApproval(chatID) {
+			state = "on"
+		}
+		return tg.sendTelegramMessage(ctx, "Approval: "+state+"\nUsage: "+usage)
+	case "on":
+		err = tg.db.SetChatSetting(chatID, chatApprovalSetting, "on")
+		if err != nil {
+			return WrapError("failed to enable approval", err)
+		}
+		return tg.sendTelegramMessage(ctx, "Approval enabled. Replies will be sent to the admin chat first.")
+	case "off":
+		err = tg.db.DeleteChatSetting(chatID, chatApprovalSetting)
+		if err != nil {
+			return WrapError("failed to disable approval", err)
+		}
+		return tg.sendTelegramMessage(ctx, "Approval disabled.")
+	default:
+		return tg.sendUsage(ctx, usage, nil)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+// argNamePattern matches the names of named command arguments.
+var argNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// ArgsError is returned when the arguments of a command are invalid. Its message is meant for the
+// user.
+type ArgsError struct {
+	Message string // Description of the problem
+}
+
+// Error implements the error interface for ArgsError.
+func (ae *ArgsError) Error() string {
+	return ae.Message
+}
+
+// CommandArgs holds the arguments given to a command.
+type CommandArgs struct {
+	Positional []string          // Arguments in the order they were given
+	Named      map[string]string // Arguments given as name=value
+}
+
+// commandText returns the text of a command message after the command, which may carry the bot
+// username as in /mrl@MurailoBot.
+func commandText(text string) string {
+	if !strings.HasPrefix(text, "/") {
+		return strings.TrimSpace(text)
+	}
+	end := strings.IndexFunc(text, unicode.IsSpace)
+	if end < 0 {
+		return ""
+	}
+	return strings.TrimSpace(text[end:])
+}
+
+// parseCommandArgs splits the arguments of a command message. Arguments are separated by spaces,
+// and quoted with double or single quotes to contain them; quotes inside a word, as in "don't",
+// are kept. Arguments of the form name="a value" are named.
+func parseCommandArgs(text string) (CommandArgs, error) {
+	args := CommandArgs{Named: make(map[string]string)}
+	var token strings.Builder
+	var quote rune
+	inToken, quoteAt := false, -1
+	flush := func() {
+		if !inToken {
+			return
+		}
+		// Only an equals sign before the first quote names the argument
+		value := token.String()
+		eq := strings.IndexByte(value, '=')
+		if eq > 0 && (quoteAt < 0 || eq < quoteAt) && argNamePattern.MatchString(value[:eq]) {
+			args.Named[value[:eq]] = value[eq+1:]
+		} else {
+			args.Positional = append(args.Positional, value)
+		}
+		token.Reset()
+		inToken, quoteAt = false, -1
+	}
+
+	for _, r := range commandText(text) {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			token.WriteRune(r)
+		case (r == '"' || r == '\'') && (token.Len() == 0 || strings.HasSuffix(token.String(), "=")):
+			quote, inToken = r, true
+			if quoteAt < 0 {
+				quoteAt = token.Len()
+			}
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			token.WriteRune(r)
+			inToken = true
+		}
+	}
+	if quote != 0 {
+		return args, &ArgsError{Message: fmt.Sprintf("missing closing %c quote", quote)}
+	}
+	flush()
+	return args, nil
+}
+
+// Arg returns the positional argument at the given index, or an empty string when it was not
+// given.
+func (args CommandArgs) Arg(i int) string {
+	if i < len(args.Positional) {
+		return args.Positional[i]
+	}
+	return ""
+}
+
+// Expect checks that the number of positional arguments is between min and max, and that only
+// the given named arguments were used.
+func (args CommandArgs) Expect(min, max int, names ...string) error {
+	switch {
+	case len(args.Positional) < min:
+		return &ArgsError{Message: fmt.Sprintf("expected at least %d arguments, got %d", min, len(args.Positional))}
+	case len(args.Positional) > max:
+		return &ArgsError{Message: fmt.Sprintf("expected at most %d arguments, got %d", max, len(args.Positional))}
+	}
+	for name := range args.Named {
+		known := false
+		for _, allowed := range names {
+			known = known || name == allowed
+		}
+		if !known {
+			return &ArgsError{Message: fmt.Sprintf("unknown argument %q", name)}
+		}
+	}
+	return nil
+}
+
+// expectArgs parses the arguments of a command message and checks them with Expect.
+func expectArgs(text string, min, max int, names ...string) (CommandArgs, error) {
+	args, err := parseCommandArgs(text)
+	if err != nil {
+		return args, err
+	}
+	return args, args.Expect(min, max, names...)
+}
+
+// sendUsage answers a command given invalid arguments with the problem and the command usage.
+func (tg *Telegram) sendUsage(ctx *ext.Context, usage string, err error) error {
+	text := "Usage: " + usage
+	var argsErr *ArgsError
+	if errors.As(err, &argsErr) {
+		text = "Invalid arguments: " + argsErr.Message + "\n" + text
+	}
+	return tg.sendTelegramMessage(ctx, text)
+}
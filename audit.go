@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// Audit log limits.
+const (
+	auditDefaultEntries = 20  // Number of entries /mrl_audit shows by default
+	auditMaxEntries     = 100 // Maximum number of entries /mrl_audit shows
+	auditMaxArgsLength  = 500 // Maximum number of characters of command arguments recorded
+	auditShownArgs      = 80  // Maximum number of characters of command arguments shown
+)
+
+// Results of audited command invocations.
+const (
+	AuditResultOK     = "ok"     // The command ran successfully
+	AuditResultDenied = "denied" // The sender was not authorized
+)
+
+// isAdminCommand reports whether a command is listed for the admin only.
+func isAdminCommand(cmd Command) bool {
+	scoped, ok := cmd.(ScopedCommand)
+	return ok && scoped.Scope() == ScopeAdmin
+}
+
+// auditCommand records an invocation of an admin command with its result, which is the error
+// message when the command failed.
+func (tg *Telegram) auditCommand(ctx *ext.Context, cmd Command, result string) {
+	args := commandText(ctx.EffectiveMessage.Text)
+	if runes := []rune(args); len(runes) > auditMaxArgsLength {
+		args = string(runes[:auditMaxArgsLength])
+	}
+	entry := AdminAudit{
+		UserID:    UserID(ctx.EffectiveMessage.From.Id),
+		UserName:  ctx.EffectiveMessage.From.Username,
+		ChatID:    ChatID(ctx.EffectiveMessage.Chat.Id),
+		Command:   cmd.Name(),
+		Args:      args,
+		Result:    result,
+		CreatedAt: time.Now(),
+	}
+	err := tg.db.AddAdminAudit(&entry)
+	if err != nil {
+		log.Error().Err(err).Str("command", cmd.Name()).Msg("Failed to record admin audit")
+	}
+}
+
+// handleMrlAuditRequest processes the /mrl_audit command, which shows the most recent admin
+// command invocations.
+func (tg *Telegram) handleMrlAuditRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_AUDIT request")
+
+	usage := fmt.Sprintf("/mrl_audit [1-%d]", auditMaxEntries)
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 1)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+	limit := auditDefaultEntries
+	if arg := args.Arg(0); arg != "" {
+		limit, err = strconv.Atoi(arg)
+		if err != nil || limit < 1 || limit > auditMaxEntries {
+			return tg.sendUsage(ctx, usage, nil)
+		}
+	}
+
+	entries, err := tg.db.GetRecentAdminAudit(limit)
+	if err != nil {
+		return WrapError("failed to get admin audit", err)
+	}
+	if len(entries) == 0 {
+		return tg.sendTelegramMessage(ctx, "No admin commands recorded.")
+	}
+
+	var sb strings.Builder
+	for _, entry := range entries {
+		line := fmt.Sprintf("%s user %d", entry.CreatedAt.Format(time.RFC3339), entry.UserID)
+		if entry.UserName != "" {
+			line += fmt.Sprintf(" (@%s)", entry.UserName)
+		}
+		line += fmt.Sprintf(" chat %d: /%s", entry.ChatID, entry.Command)
+		if args := []rune(entry.Args); len(args) > auditShownArgs {
+			line += " " + string(args[:auditShownArgs]) + "…"
+		} else if len(args) > 0 {
+			line += " " + entry.Args
+		}
+		line += " -> " + entry.Result + "\n"
+		if sb.Len()+len(line) > listMessageLength {
+			break
+		}
+		sb.WriteString(line)
+	}
+	return tg.sendTelegramMessage(ctx, strings.TrimSpace(sb.String()))
+}
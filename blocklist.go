@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// ignoreBlockedUser reports whether the sender of the effective message is blocked in the chat,
+// reacting to the message with the configured emoji when so.
+func (tg *Telegram) ignoreBlockedUser(ctx *ext.Context) bool {
+	chatID, userID := ChatID(ctx.EffectiveMessage.Chat.Id), UserID(ctx.EffectiveMessage.From.Id)
+	blocked, err := tg.db.IsUserBlocked(chatID, userID)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Int64("user_id", int64(userID)).Msg("Failed to check blocked user")
+		return false
+	}
+	if !blocked {
+		return false
+	}
+
+	componentLog(LogBlocklist).Info().Int64("chat_id", int64(chatID)).Int64("user_id", int64(userID)).Msg("Ignoring request of blocked user")
+	if tg.config.TelegramBlockedReaction != "" {
+		_, err = tg.bot.SetMessageReaction(int64(chatID), ctx.EffectiveMessage.MessageId, &gotgbot.SetMessageReactionOpts{
+			Reaction: []gotgbot.ReactionType{gotgbot.ReactionTypeEmoji{Emoji: tg.config.TelegramBlockedReaction}},
+		})
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to react to blocked user")
+		}
+	}
+	return true
+}
+
+// blockTarget returns the user a block command refers to: the user ID given as argument, or the
+// sender of the replied message.
+func blockTarget(msg *gotgbot.Message) (UserID, bool) {
+	args, err := expectArgs(msg.Text, 0, 1)
+	if err != nil {
+		return 0, false
+	}
+	if arg := args.Arg(0); arg != "" {
+		userID, err := strconv.ParseInt(arg, 10, 64)
+		return UserID(userID), err == nil && userID != 0
+	}
+	if msg.ReplyToMessage != nil && msg.ReplyToMessage.From != nil {
+		return UserID(msg.ReplyToMessage.From.Id), true
+	}
+	return 0, false
+}
+
+// handleMrlBlockRequest processes the /mrl_block command, which makes the bot ignore a user in
+// the chat.
+func (tg *Telegram) handleMrlBlockRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_BLOCK request")
+
+	userID, ok := blockTarget(ctx.EffectiveMessage)
+	if !ok {
+		return tg.sendTelegramMessage(ctx, "Usage: /mrl_block <user_id>, or reply to a message of the user")
+	}
+	if userID == tg.config.TelegramAdminUID {
+		return tg.sendTelegramMessage(ctx, "The admin cannot be blocked.")
+	}
+	err := tg.db.BlockUser(ChatID(ctx.EffectiveMessage.Chat.Id), userID)
+	if err != nil {
+		return WrapError("failed to block user", err)
+	}
+	tg.webhooks.Notify(EventModeration, map[string]interface{}{"chat_id": ctx.EffectiveMessage.Chat.Id, "user_id": userID, "action": "blocked", "by": ctx.EffectiveMessage.From.Id})
+	return tg.sendTelegramMessage(ctx, fmt.Sprintf("User %d blocked in this chat.", userID))
+}
+
+// handleMrlUnblockRequest processes the /mrl_unblock command, which makes the bot answer a
+// blocked user in the chat again.
+func (tg *Telegram) handleMrlUnblockRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_UNBLOCK request")
+
+	userID, ok := blockTarget(ctx.EffectiveMessage)
+	if !ok {
+		return tg.sendTelegramMessage(ctx, "Usage: /mrl_unblock <user_id>, or reply to a message of the user")
+	}
+	unblocked, err := tg.db.UnblockUser(ChatID(ctx.EffectiveMessage.Chat.Id), userID)
+	if err != nil {
+		return WrapError("failed to unblock user", err)
+	}
+	if !unblocked {
+		return tg.sendTelegramMessage(ctx, fmt.Sprintf("User %d is not blocked in this chat.", userID))
+	}
+	tg.webhooks.Notify(EventModeration, map[string]interface{}{"chat_id": ctx.EffectiveMessage.Chat.Id, "user_id": userID, "action": "unblocked", "by": ctx.EffectiveMessage.From.Id})
+	return tg.sendTelegramMessage(ctx, fmt.Sprintf("User %d unblocked in this chat.", userID))
+}
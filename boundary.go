@@ -0,0 +1,46 @@
+package main
+
+import (
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// chatContextBoundarySetting is the chat settings key holding the time a new conversation was
+// started in a chat.
+const chatContextBoundarySetting = "context_boundary"
+
+// contextBoundary returns the time the current conversation of a chat started, or the zero time
+// when no boundary was set.
+func (tg *Telegram) contextBoundary(chatID ChatID) time.Time {
+	value, ok, err := tg.db.GetChatSetting(chatID, chatContextBoundarySetting)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to get context boundary")
+		return time.Time{}
+	}
+	if !ok {
+		return time.Time{}
+	}
+	boundary, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Str("value", value).Msg("Invalid context boundary")
+		return time.Time{}
+	}
+	return boundary
+}
+
+// handleMrlNewRequest processes the /mrl_new command, which starts a new conversation in the chat.
+// Earlier history is kept but no longer included in the context of replies.
+func (tg *Telegram) handleMrlNewRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_NEW request")
+
+	err := tg.db.SetChatSetting(ChatID(ctx.EffectiveMessage.Chat.Id), chatContextBoundarySetting, time.Now().Format(time.RFC3339Nano))
+	if err != nil {
+		return WrapError("failed to set context boundary", err)
+	}
+	return tg.sendTelegramMessage(ctx, "Nova conversa iniciada. Mensagens anteriores não serão mais consideradas.")
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// chatConfigVersion is the version of the chat configuration format written by this binary.
+const chatConfigVersion = 1
+
+// chatConfigMaxSize is the maximum size in bytes of an imported chat configuration.
+const chatConfigMaxSize = 64 << 10
+
+// ChatConfig is the portable configuration of a chat: its settings and memory.
+type ChatConfig struct {
+	Settings map[string]string // Chat settings by key
+	Memory   map[string]string // Chat memory entries by key
+}
+
+// chatConfigSettings validates the values of the chat settings included in a chat configuration.
+// Settings derived from the chat content, like the style hint, are not portable.
+var chatConfigSettings = map[string]func(value string) error{
+	chatLearningSetting:     oneOf(LearningPolicyReplyOnly),
+	chatExportPolicySetting: oneOf(ExportPolicySameChat),
+	chatCritiqueSetting:     oneOf("on"),
+	chatApprovalSetting:     oneOf("on"),
+	chatDigestSetting:       oneOf("off"),
+	chatInstructionSetting:  validateInstruction,
+	chatLanguageSetting:     validateLanguage,
+	chatTriggersSetting: func(value string) error {
+		_, err := compileTriggers(value)
+		return err
+	},
+	chatTemperatureSetting: func(value string) error {
+		temperature, err := strconv.ParseFloat(value, 32)
+		if err != nil || temperature < 0 || temperature > 2 {
+			return WrapError("must be a number between 0 and 2")
+		}
+		return nil
+	},
+	chatParseModeSetting:      oneOf("HTML", "MarkdownV2"),
+	chatLinkPreviewSetting:    oneOf("on", "off"),
+	chatProtectContentSetting: oneOf("on", "off"),
+	chatSilentSetting:         oneOf("on", "off"),
+	chatReplyModeSetting:      oneOf("reply", "quote", "plain"),
+	chatVerbositySetting:      oneOf(VerbosityBrief, VerbosityDetailed),
+	chatContextSizeSetting: func(value string) error {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 0 || size > maxContextSize {
+			return WrapError(fmt.Sprintf("must be a number between 0 and %d", maxContextSize))
+		}
+		return nil
+	},
+}
+
+// oneOf returns a validator accepting only the given values.
+func oneOf(values ...string) func(string) error {
+	return func(value string) error {
+		for _, allowed := range values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return WrapError(fmt.Sprintf("must be one of %s", strings.Join(values, ", ")))
+	}
+}
+
+// Validate checks the settings and memory of a chat configuration, allowing at most maxMemory
+// memory entries.
+func (config ChatConfig) Validate(maxMemory int) error {
+	for key, value := range config.Settings {
+		validate, ok := chatConfigSettings[key]
+		if !ok {
+			return WrapError(fmt.Sprintf("unknown setting %q", key))
+		}
+		err := validate(value)
+		if err != nil {
+			return WrapError(fmt.Sprintf("invalid value of setting %q", key), err)
+		}
+	}
+	if len(config.Memory) > maxMemory {
+		return WrapError(fmt.Sprintf("%d memory entries, at most %d allowed", len(config.Memory), maxMemory))
+	}
+	for key, value := range config.Memory {
+		if key == "" || len([]rune(key)) > chatMemoryMaxKey || value == "" || len([]rune(value)) > chatMemoryMaxValue {
+			return WrapError(fmt.Sprintf("memory entry %q is empty or too long", key))
+		}
+	}
+	return nil
+}
+
+// exportChatConfig reads the configuration of a chat.
+func exportChatConfig(db *DB, chatID ChatID) (ChatConfig, error) {
+	config := ChatConfig{Settings: make(map[string]string), Memory: make(map[string]string)}
+	for key := range chatConfigSettings {
+		value, ok, err := db.GetChatSetting(chatID, key)
+		if err != nil {
+			return config, WrapError("failed to get chat setting", err)
+		}
+		if ok {
+			config.Settings[key] = value
+		}
+	}
+	entries, err := db.GetChatMemory(chatID)
+	if err != nil {
+		return config, WrapError("failed to get chat memory", err)
+	}
+	for _, entry := range entries {
+		config.Memory[entry.Key] = entry.Value
+	}
+	return config, nil
+}
+
+// importChatConfig replaces the configuration of a chat. Settings missing from the configuration
+// are reset to their defaults.
+func importChatConfig(db *DB, chatID ChatID, config ChatConfig, maxMemory int) error {
+	err := config.Validate(maxMemory)
+	if err != nil {
+		return WrapError("invalid chat configuration", err)
+	}
+	keys := make([]string, 0, len(chatConfigSettings))
+	for key := range chatConfigSettings {
+		keys = append(keys, key)
+	}
+	return db.ReplaceChatConfig(chatID, keys, config.Settings, config.Memory)
+}
+
+// writeYAMLMap writes a YAML mapping of quoted strings, sorted by key.
+func writeYAMLMap(w io.Writer, name string, values map[string]string) {
+	if len(values) == 0 {
+		fmt.Fprintf(w, "%s: {}\n", name)
+		return
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(w, "%s:\n", name)
+	for _, key := range keys {
+		fmt.Fprintf(w, "  %s: %s\n", strconv.Quote(key), strconv.Quote(values[key]))
+	}
+}
+
+// MarshalYAML encodes the chat configuration as YAML.
+func (config ChatConfig) MarshalYAML() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# MurailoBOT chat configuration\nversion: %d\n", chatConfigVersion)
+	writeYAMLMap(&buf, "settings", config.Settings)
+	writeYAMLMap(&buf, "memory", config.Memory)
+	return buf.Bytes()
+}
+
+// chatConfigDocument is the YAML layout of a chat configuration.
+type chatConfigDocument struct {
+	Version  int               `yaml:"version"`
+	Settings map[string]string `yaml:"settings"`
+	Memory   map[string]string `yaml:"memory"`
+}
+
+// parseChatConfig decodes a YAML chat configuration, as written by MarshalYAML or edited by hand.
+// Fields other than the version, settings, and memory are rejected.
+func parseChatConfig(data []byte) (ChatConfig, error) {
+	config := ChatConfig{Settings: make(map[string]string), Memory: make(map[string]string)}
+	var doc chatConfigDocument
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err := decoder.Decode(&doc)
+	if err != nil && err != io.EOF {
+		return config, WrapError("invalid YAML", err)
+	}
+	if doc.Version != 0 && doc.Version != chatConfigVersion {
+		return config, WrapError(fmt.Sprintf("unsupported version %d", doc.Version))
+	}
+	for key, value := range doc.Settings {
+		config.Settings[key] = value
+	}
+	for key, value := range doc.Memory {
+		config.Memory[key] = value
+	}
+	return config, nil
+}
+
+// runChatConfig runs the chat-config subcommand, which exports or imports the configuration of a
+// chat as YAML. Imports read the bot configuration from the environment for the chat memory limit.
+func runChatConfig(args []string) error {
+	usage := "usage: murailobot chat-config export -chat-id id [-db path] [-o file] | import -chat-id id [-db path] file"
+	if len(args) == 0 {
+		return WrapError(usage)
+	}
+
+	flags := flag.NewFlagSet("chat-config "+args[0], flag.ContinueOnError)
+	dbName := flags.String("db", defaultDBName(), "database holding the chat")
+	chatID := flags.Int64("chat-id", 0, "chat to export or import")
+	output := flags.String("o", "", "file to write, standard output if empty")
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return WrapError("failed to parse flags", err)
+	}
+	if *chatID == 0 {
+		return WrapError(usage)
+	}
+	db, err := NewDB(&Config{DBName: *dbName})
+	if err != nil {
+		return WrapError("failed to init database", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "export":
+		config, err := exportChatConfig(db, ChatID(*chatID))
+		if err != nil {
+			return err
+		}
+		if *output == "" {
+			_, err = os.Stdout.Write(config.MarshalYAML())
+			return err
+		}
+		err = os.WriteFile(*output, config.MarshalYAML(), 0o644)
+		if err != nil {
+			return WrapError("failed to write chat configuration", err)
+		}
+		return nil
+	case "import":
+		if flags.NArg() != 1 {
+			return WrapError(usage)
+		}
+		data, err := os.ReadFile(flags.Arg(0))
+		if err != nil {
+			return WrapError("failed to read chat configuration", err)
+		}
+		config, err := parseChatConfig(data)
+		if err != nil {
+			return WrapError("failed to parse chat configuration", err)
+		}
+		botConfig, err := NewConfig()
+		if err != nil {
+			return WrapError("failed to load configuration", err)
+		}
+		err = importChatConfig(db, ChatID(*chatID), config, botConfig.ChatMemoryMaxEntries)
+		if err != nil {
+			return err
+		}
+		log.Info().Int64("chat_id", *chatID).Int("settings", len(config.Settings)).Int("memory", len(config.Memory)).Msg("Imported chat configuration")
+		return nil
+	default:
+		return WrapError(usage)
+	}
+}
+
+// handleMrlChatExportRequest processes the /mrl_chat_export command, which sends the configuration
+// of the chat to the admin chat as a YAML file.
+func (tg *Telegram) handleMrlChatExportRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_CHAT_EXPORT request")
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	config, err := exportChatConfig(tg.db, chatID)
+	if err != nil {
+		return WrapError("failed to export chat configuration", err)
+	}
+	name := fmt.Sprintf("chat-%d-config.yaml", chatID)
+	_, err = tg.bot.SendDocument(int64(tg.adminChatID()), gotgbot.NamedFile{FileName: name, File: bytes.NewReader(config.MarshalYAML())}, &gotgbot.SendDocumentOpts{
+		Caption: fmt.Sprintf("Configuration of %s (%d)", ctx.EffectiveMessage.Chat.Title, chatID),
+	})
+	if err != nil {
+		return WrapError("failed to send chat configuration", err)
+	}
+	if tg.adminChatID() != chatID {
+		return tg.sendTelegramMessage(ctx, "Chat configuration sent to the admin chat.")
+	}
+	return nil
+}
+
+// handleMrlChatImportRequest processes the /mrl_chat_import command, sent as a reply to a YAML
+// file exported with /mrl_chat_export, which replaces the configuration of the chat.
+func (tg *Telegram) handleMrlChatImportRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_CHAT_IMPORT request")
+
+	reply := ctx.EffectiveMessage.ReplyToMessage
+	if reply == nil || reply.Document == nil {
+		return tg.sendTelegramMessage(ctx, "Reply to a configuration file exported with /mrl_chat_export.")
+	}
+	if reply.Document.FileSize > chatConfigMaxSize {
+		return tg.sendTelegramMessage(ctx, "The configuration file is too large.")
+	}
+
+	data, err := tg.downloadFile(reply.Document.FileId, chatConfigMaxSize)
+	if err != nil {
+		return WrapError("failed to download chat configuration", err)
+	}
+	config, err := parseChatConfig(data)
+	if err == nil {
+		err = importChatConfig(tg.db, ChatID(ctx.EffectiveMessage.Chat.Id), config, tg.config.ChatMemoryMaxEntries)
+	}
+	if err != nil {
+		log.Info().Err(err).Int64("chat_id", ctx.EffectiveMessage.Chat.Id).Msg("Rejected chat configuration")
+		return tg.sendTelegramMessage(ctx, "Invalid chat configuration, nothing was changed.")
+	}
+	return tg.sendTelegramMessage(ctx, fmt.Sprintf("Imported %d settings and %d memory entries.", len(config.Settings), len(config.Memory)))
+}
+
+// downloadFile downloads a file sent to the bot, up to the given size in bytes.
+func (tg *Telegram) downloadFile(fileID string, maxSize int64) ([]byte, error) {
+	file, err := tg.bot.GetFile(fileID, nil)
+	if err != nil {
+		return nil, WrapError("failed to get file", err)
+	}
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	resp, err := httpClient.Get(file.URL(tg.bot, nil))
+	if err != nil {
+		return nil, WrapError("failed to download file", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, WrapError(fmt.Sprintf("unexpected status %s", resp.Status))
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize))
+	if err != nil {
+		return nil, WrapError("failed to read file", err)
+	}
+	return data, nil
+}
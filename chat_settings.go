@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// Chat settings overriding the conversation defaults.
+const (
+	chatInstructionSetting = "instruction" // Replaces the configured instruction, giving the chat its own persona
+	chatLanguageSetting    = "language"    // IETF code of the reply language, instead of the one most users set
+)
+
+// chatInstructionMaxLength is the maximum number of characters of a chat instruction.
+const chatInstructionMaxLength = 4000
+
+// languageCodePattern matches IETF language codes such as "pt" or "pt-BR".
+var languageCodePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// validateInstruction accepts chat instructions that are neither empty nor too long.
+func validateInstruction(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return WrapError("must not be empty")
+	}
+	if len([]rune(value)) > chatInstructionMaxLength {
+		return WrapError(fmt.Sprintf("must be at most %d characters", chatInstructionMaxLength))
+	}
+	return nil
+}
+
+// validateLanguage accepts IETF language codes.
+func validateLanguage(value string) error {
+	if !languageCodePattern.MatchString(value) {
+		return WrapError("must be an IETF language code such as pt or pt-BR")
+	}
+	return nil
+}
+
+// handleMrlConfigRequest processes the /mrl_config command, which lists the settings of the chat,
+// shows one, sets it, or with "unset" restores its default.
+func (tg *Telegram) handleMrlConfigRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_CONFIG request")
+
+	usage := "/mrl_config [get <key>|set <key> <value>|unset <key>]"
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 3)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	if len(args.Positional) == 0 {
+		keys := make([]string, 0, len(chatConfigSettings))
+		for key := range chatConfigSettings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var lines []string
+		for _, key := range keys {
+			value, ok, err := tg.db.GetChatSetting(chatID, key)
+			if err != nil {
+				return WrapError("failed to get chat setting", err)
+			}
+			if !ok {
+				value = "default"
+			} else if runes := []rune(value); len(runes) > 60 {
+				value = string(runes[:60]) + "…"
+			}
+			lines = append(lines, fmt.Sprintf("%s: %s", key, value))
+		}
+		return tg.sendTelegramMessage(ctx, strings.Join(lines, "\n"))
+	}
+
+	action, key := args.Arg(0), args.Arg(1)
+	validate, ok := chatConfigSettings[key]
+	if key != "" && !ok {
+		return tg.sendTelegramMessage(ctx, fmt.Sprintf("Unknown setting %q.", key))
+	}
+	switch {
+	case action == "get" && len(args.Positional) == 2:
+		value, ok, err := tg.db.GetChatSetting(chatID, key)
+		if err != nil {
+			return WrapError("failed to get chat setting", err)
+		}
+		if !ok {
+			return tg.sendTelegramMessage(ctx, key+" is not set in this chat.")
+		}
+		return tg.sendTelegramMessage(ctx, key+": "+value)
+	case action == "set" && len(args.Positional) == 3:
+		value := args.Arg(2)
+		err = validate(value)
+		if err != nil {
+			return tg.sendTelegramMessage(ctx, fmt.Sprintf("Invalid value for %s: %v", key, err))
+		}
+		err = tg.db.SetChatSetting(chatID, key, value)
+		if err != nil {
+			return WrapError("failed to set chat setting", err)
+		}
+		log.Info().Int64("chat_id", int64(chatID)).Str("key", key).Msg("Chat setting changed")
+		return tg.sendTelegramMessage(ctx, key+" set.")
+	case action == "unset" && len(args.Positional) == 2:
+		err = tg.db.DeleteChatSetting(chatID, key)
+		if err != nil {
+			return WrapError("failed to delete chat setting", err)
+		}
+		log.Info().Int64("chat_id", int64(chatID)).Str("key", key).Msg("Chat setting restored to the default")
+		return tg.sendTelegramMessage(ctx, key+" restored to the default.")
+	}
+	return tg.sendUsage(ctx, usage, nil)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// cliUsage describes the subcommands of the binary.
+const cliUsage = `Usage: murailobot [command]
+
+Without a command the bot is started. Commands:
+  snapshot create|restore   Create or restore a snapshot of the bot state (restore with the bot stopped)
+  replay                    Rebuild the prompts of stored chat history and optionally rerun them
+  schema                    Report the database tables or export them as a diagram
+  chat-config export|import Export or import the settings and memory of a chat as YAML
+  migrate status|up|down    Report, apply, or revert the database schema migrations`
+
+// runCommand runs a command line subcommand.
+func runCommand(name string, args []string) error {
+	switch name {
+	case "snapshot":
+		return runSnapshot(args)
+	case "replay":
+		return runReplay(args)
+	case "schema":
+		return runSchema(args)
+	case "chat-config":
+		return runChatConfig(args)
+	case "migrate":
+		return runMigrate(args)
+	case "help", "-h", "--help":
+		fmt.Fprintln(os.Stderr, cliUsage)
+		return nil
+	default:
+		return WrapError(fmt.Sprintf("unknown command %q\n%s", name, cliUsage))
+	}
+}
+
+// defaultDBName returns the database name configured in the environment, without requiring
+// the rest of the configuration.
+func defaultDBName() string {
+	dbName := os.Getenv("MURAILOBOT_DB_NAME")
+	if dbName == "" {
+		return "storage.db"
+	}
+	return dbName
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+// Command is a bot command that can be plugged into the Telegram bot.
+type Command interface {
+	Name() string                                  // Command name without the leading slash
+	Description() string                           // Short description shown in the command menu
+	Authorize(tg *Telegram, ctx *ext.Context) bool // Reports whether the sender may run the command
+	Handle(tg *Telegram, ctx *ext.Context) error   // Executes the command
+}
+
+// CommandScope is a set of command menus a command is listed in.
+type CommandScope int
+
+const (
+	ScopePrivate CommandScope = 1 << iota // Menu of private chats
+	ScopeGroup                            // Menu of group chats
+	ScopeAdmin                            // Menu of the admin's private chat
+)
+
+// ScopedCommand is implemented by commands that choose the menus they are listed in.
+// Commands not implementing it are listed in private and group chats.
+type ScopedCommand interface {
+	Scope() CommandScope
+}
+
+// LocalizedCommand is implemented by commands with translated descriptions.
+type LocalizedCommand interface {
+	LocalizedDescription(languageCode string) (string, bool)
+	Languages() []string
+}
+
+// BasicCommand is a Command built from plain values and a handler function.
+type BasicCommand struct {
+	CommandName        string                                     // Command name without the leading slash
+	CommandDescription string                                     // Short description shown in the command menu
+	LocalizedDescs     map[string]string                          // Descriptions by IETF language code
+	MenuScope          CommandScope                               // Menus the command is listed in, private and group chats if zero
+	AdminOnly          bool                                       // Restricts the command to the Telegram admin
+	Handler            func(tg *Telegram, ctx *ext.Context) error // Function executing the command
+}
+
+// Name returns the command name.
+func (cmd *BasicCommand) Name() string {
+	return cmd.CommandName
+}
+
+// Description returns the command description.
+func (cmd *BasicCommand) Description() string {
+	return cmd.CommandDescription
+}
+
+// LocalizedDescription returns the description translated to the given language.
+func (cmd *BasicCommand) LocalizedDescription(languageCode string) (string, bool) {
+	description, ok := cmd.LocalizedDescs[languageCode]
+	return description, ok
+}
+
+// Languages returns the language codes the description is translated to.
+func (cmd *BasicCommand) Languages() []string {
+	languages := make([]string, 0, len(cmd.LocalizedDescs))
+	for languageCode := range cmd.LocalizedDescs {
+		languages = append(languages, languageCode)
+	}
+	return languages
+}
+
+// Scope returns the menus the command is listed in. Admin only commands are listed in the
+// admin's private chat only.
+func (cmd *BasicCommand) Scope() CommandScope {
+	if cmd.AdminOnly {
+		return ScopeAdmin
+	}
+	if cmd.MenuScope == 0 {
+		return ScopePrivate | ScopeGroup
+	}
+	return cmd.MenuScope
+}
+
+// Authorize allows everyone unless the command is admin only.
+func (cmd *BasicCommand) Authorize(tg *Telegram, ctx *ext.Context) bool {
+	if !cmd.AdminOnly {
+		return true
+	}
+	return ctx.EffectiveUser != nil && UserID(ctx.EffectiveUser.Id) == tg.config.TelegramAdminUID
+}
+
+// Handle runs the command handler.
+func (cmd *BasicCommand) Handle(tg *Telegram, ctx *ext.Context) error {
+	return cmd.Handler(tg, ctx)
+}
+
+// pluginCommands holds the commands registered by extensions through RegisterCommand.
+var pluginCommands []Command
+
+// RegisterCommand adds a command to the bot. It is meant to be called from init functions
+// so extensions can add commands without touching the built-in command list.
+func RegisterCommand(cmd Command) {
+	pluginCommands = append(pluginCommands, cmd)
+}
+
+// CommandRegistry keeps the commands known to the bot in registration order.
+type CommandRegistry struct {
+	commands []Command          // Registered commands
+	byName   map[string]Command // Registered commands indexed by name
+}
+
+// NewCommandRegistry creates a registry holding the given commands.
+func NewCommandRegistry(commands ...Command) (*CommandRegistry, error) {
+	registry := &CommandRegistry{byName: make(map[string]Command)}
+	for _, cmd := range commands {
+		err := registry.Register(cmd)
+		if err != nil {
+			return nil, WrapError("failed to register command", err)
+		}
+	}
+	return registry, nil
+}
+
+// Register adds a command to the registry, rejecting invalid or duplicate names.
+func (registry *CommandRegistry) Register(cmd Command) error {
+	name := cmd.Name()
+	if name == "" || strings.ContainsAny(name, " /") {
+		return WrapError(fmt.Sprintf("invalid command name %q", name))
+	}
+	if _, ok := registry.byName[name]; ok {
+		return WrapError(fmt.Sprintf("command %q is already registered", name))
+	}
+	registry.commands = append(registry.commands, cmd)
+	registry.byName[name] = cmd
+	return nil
+}
+
+// Get returns the command registered under the given name.
+func (registry *CommandRegistry) Get(name string) (Command, bool) {
+	cmd, ok := registry.byName[name]
+	return cmd, ok
+}
+
+// Commands returns the registered commands in registration order.
+func (registry *CommandRegistry) Commands() []Command {
+	return registry.commands
+}
+
+// commandScope returns the menus a command is listed in.
+func commandScope(cmd Command) CommandScope {
+	if scoped, ok := cmd.(ScopedCommand); ok {
+		return scoped.Scope()
+	}
+	return ScopePrivate | ScopeGroup
+}
+
+// commandDescription returns the description of a command in the given language, falling back
+// to the default description. An empty language code selects the default description.
+func commandDescription(cmd Command, languageCode string) string {
+	if localized, ok := cmd.(LocalizedCommand); ok && languageCode != "" {
+		if description, ok := localized.LocalizedDescription(languageCode); ok {
+			return description
+		}
+	}
+	return cmd.Description()
+}
+
+// Languages returns the language codes any registered command description is translated to.
+func (registry *CommandRegistry) Languages() []string {
+	var languages []string
+	known := make(map[string]bool)
+	for _, cmd := range registry.commands {
+		localized, ok := cmd.(LocalizedCommand)
+		if !ok {
+			continue
+		}
+		for _, languageCode := range localized.Languages() {
+			if !known[languageCode] {
+				known[languageCode] = true
+				languages = append(languages, languageCode)
+			}
+		}
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// BotCommands returns the commands listed in a menu, in the format used by Telegram. The admin
+// menu also lists the commands of private chats.
+func (registry *CommandRegistry) BotCommands(scope CommandScope, languageCode string) []gotgbot.BotCommand {
+	if scope == ScopeAdmin {
+		scope |= ScopePrivate
+	}
+	var botCommands []gotgbot.BotCommand
+	for _, cmd := range registry.commands {
+		if commandScope(cmd)&scope == 0 {
+			continue
+		}
+		botCommands = append(botCommands, gotgbot.BotCommand{Command: cmd.Name(), Description: commandDescription(cmd, languageCode)})
+	}
+	return botCommands
+}
+
+// HelpText returns a listing of the commands listed in the given menus and their descriptions
+// in the given language.
+func (registry *CommandRegistry) HelpText(scope CommandScope, languageCode string) string {
+	var sb strings.Builder
+	for _, cmd := range registry.BotCommands(scope, languageCode) {
+		fmt.Fprintf(&sb, "/%s - %s\n", cmd.Command, cmd.Description)
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// builtinCommands returns the commands shipped with the bot.
+func builtinCommands() []Command {
+	return []Command{
+		&BasicCommand{
+			CommandName:        "start",
+			CommandDescription: "Iniciar conversa o bot",
+			LocalizedDescs:     map[string]string{"en": "Start talking to the bot"},
+			MenuScope:          ScopePrivate,
+			Handler:            (*Telegram).handleStartRequest,
+		},
+		&BasicCommand{
+			CommandName:        "help",
+			CommandDescription: "Mostrar o que o bot faz neste chat",
+			LocalizedDescs:     map[string]string{"en": "Show what the bot does in this chat"},
+			Handler:            (*Telegram).handleHelpRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_profile",
+			CommandDescription: "Mostrar sua atividade neste chat",
+			LocalizedDescs:     map[string]string{"en": "Show your activity in this chat"},
+			Handler:            (*Telegram).handleMrlProfileRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_mydata",
+			CommandDescription: "Receber no privado todos os seus dados guardados pelo bot",
+			LocalizedDescs:     map[string]string{"en": "Get everything the bot stores about you in a private message"},
+			Handler:            (*Telegram).handleMrlMyDataRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_quote",
+			CommandDescription: "Salvar a mensagem respondida como citação, ou ver uma citação aleatória",
+			LocalizedDescs:     map[string]string{"en": "Save the replied message as a quote, or show a random quote"},
+			Handler:            (*Telegram).handleMrlQuoteRequest,
+		},
+		&BasicCommand{
+			CommandName:        "piu",
+			CommandDescription: "Enviar forward de uma mensagem antiga",
+			LocalizedDescs:     map[string]string{"en": "Forward an old message"},
+			Handler:            (*Telegram).handlePiuRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl",
+			CommandDescription: "Gerar uma resposta usando OpenAI",
+			LocalizedDescs:     map[string]string{"en": "Generate a reply using OpenAI"},
+			Handler:            (*Telegram).handleMrlRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_reset",
+			CommandDescription: "Limpar histórico de mensagens",
+			LocalizedDescs:     map[string]string{"en": "Clear message history"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlResetRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_stats",
+			CommandDescription: "Mostrar estatísticas do bot",
+			LocalizedDescs:     map[string]string{"en": "Show bot statistics"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlStatsRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_refusals",
+			CommandDescription: "Listar recusas recentes do modelo",
+			LocalizedDescs:     map[string]string{"en": "List recent model refusals"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlRefusalsRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_temperature",
+			CommandDescription: "Definir a temperatura das respostas neste chat",
+			LocalizedDescs:     map[string]string{"en": "Set the reply temperature in this chat"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlTemperatureRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_model",
+			CommandDescription: "Ver ou escolher o modelo que responde neste chat",
+			LocalizedDescs:     map[string]string{"en": "Show or choose the model answering in this chat"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlModelRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_verbosity",
+			CommandDescription: "Ver ou escolher o tamanho das respostas neste chat",
+			LocalizedDescs:     map[string]string{"en": "Show or choose the length of replies in this chat"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlVerbosityRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_flag",
+			CommandDescription: "Ver ou alterar as feature flags deste chat",
+			LocalizedDescs:     map[string]string{"en": "Show or toggle the feature flags of this chat"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlFlagRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_audit",
+			CommandDescription: "Ver os últimos comandos de admin executados",
+			LocalizedDescs:     map[string]string{"en": "Show the most recent admin commands"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlAuditRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_focus",
+			CommandDescription: "Responder todas as mensagens deste chat por um tempo",
+			LocalizedDescs:     map[string]string{"en": "Answer every message in this chat for a while"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlFocusRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_reminders",
+			CommandDescription: "Ver ou cancelar os lembretes agendados pelo bot",
+			LocalizedDescs:     map[string]string{"en": "Show or cancel the follow-ups scheduled by the bot"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlRemindersRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_rule",
+			CommandDescription: "Gerenciar respostas automáticas deste chat",
+			LocalizedDescs:     map[string]string{"en": "Manage the canned responses of this chat"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlRuleRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_defaults",
+			CommandDescription: "Definir o formato padrão das mensagens do bot neste chat",
+			LocalizedDescs:     map[string]string{"en": "Set the defaults of the bot's messages in this chat"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlDefaultsRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_export_policy",
+			CommandDescription: "Definir para onde mensagens deste chat podem ser encaminhadas",
+			LocalizedDescs:     map[string]string{"en": "Set where messages from this chat may be forwarded"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlExportPolicyRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_new",
+			CommandDescription: "Começar uma nova conversa sem apagar o histórico",
+			LocalizedDescs:     map[string]string{"en": "Start a new conversation without deleting history"},
+			Handler:            (*Telegram).handleMrlNewRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_block",
+			CommandDescription: "Ignorar um usuário neste chat",
+			LocalizedDescs:     map[string]string{"en": "Ignore a user in this chat"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlBlockRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_unblock",
+			CommandDescription: "Voltar a responder um usuário neste chat",
+			LocalizedDescs:     map[string]string{"en": "Answer a user in this chat again"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlUnblockRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_context_size",
+			CommandDescription: "Definir quantas mensagens anteriores entram no contexto",
+			LocalizedDescs:     map[string]string{"en": "Set how many earlier messages go into the context"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlContextSizeRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_export_html",
+			CommandDescription: "Exportar o histórico deste chat em HTML para o admin",
+			LocalizedDescs:     map[string]string{"en": "Export the history of this chat as HTML to the admin"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlExportHTMLRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_triggers",
+			CommandDescription: "Definir palavras que fazem o bot responder",
+			LocalizedDescs:     map[string]string{"en": "Set words the bot answers to"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlTriggersRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_retract",
+			CommandDescription: "Apagar a resposta do bot à qual você respondeu",
+			LocalizedDescs:     map[string]string{"en": "Delete the bot reply you are replying to"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlRetractRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_chat_export",
+			CommandDescription: "Exportar as configurações deste chat como YAML",
+			LocalizedDescs:     map[string]string{"en": "Export the configuration of this chat as YAML"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlChatExportRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_chat_import",
+			CommandDescription: "Importar configurações de um arquivo YAML para este chat",
+			LocalizedDescs:     map[string]string{"en": "Import a YAML configuration file into this chat"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlChatImportRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_chatmemory",
+			CommandDescription: "Ver ou editar o que o bot lembra sobre este chat",
+			LocalizedDescs:     map[string]string{"en": "Show or edit what the bot remembers about this chat"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlChatMemoryRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_approval",
+			CommandDescription: "Exigir aprovação do admin antes de enviar respostas neste chat",
+			LocalizedDescs:     map[string]string{"en": "Require the admin's approval before sending replies in this chat"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlApprovalRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_critique",
+			CommandDescription: "Ativar ou desativar a revisão das respostas neste chat",
+			LocalizedDescs:     map[string]string{"en": "Enable or disable reviewing replies in this chat"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlCritiqueRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_config",
+			CommandDescription: "Ver ou alterar as configurações deste chat",
+			LocalizedDescs:     map[string]string{"en": "Show or change the settings of this chat"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlConfigRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_digest",
+			CommandDescription: "Incluir ou excluir este chat do resumo diário do administrador",
+			LocalizedDescs:     map[string]string{"en": "Include or exclude this chat from the admin's daily digest"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlDigestRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_drift",
+			CommandDescription: "Verificar se as respostas do bot mudaram em relação às de referência",
+			LocalizedDescs:     map[string]string{"en": "Check whether the bot's answers drifted from their baselines"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlDriftRequest,
+		},
+		&BasicCommand{
+			CommandName:        "mrl_learning",
+			CommandDescription: "Definir se as conversas deste chat são memorizadas",
+			LocalizedDescs:     map[string]string{"en": "Set whether conversations in this chat are remembered"},
+			AdminOnly:          true,
+			Handler:            (*Telegram).handleMrlLearningRequest,
+		},
+	}
+}
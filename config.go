@@ -1,20 +1,87 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/kelseyhightower/envconfig"
 )
 
 // Config holds the configuration variables for the application
 type Config struct {
-	TelegramToken       string  `envconfig:"telegram_token" required:"true"`     // Token for accessing the Telegram API
-	TelegramAdminUID    int64   `envconfig:"telegram_admin_uid" required:"true"` // Telegram Admin User ID
-	TelegramUserTimeout float64 `envconfig:"telegram_user_timeout" default:"5"`  // Timeout duration for Telegram users
-	OpenAIToken         string  `envconfig:"openai_token" required:"true"`       // Token for accessing the OpenAI API
-	OpenAIInstruction   string  `envconfig:"openai_instruction" required:"true"` // Instruction string for OpenAI
-	OpenAIModel         string  `envconfig:"openai_model" default:"gpt-4o"`      // Model name for OpenAI
-	OpenAITemperature   float32 `envconfig:"openai_temperature" default:"0.5"`   // Temperature setting for OpenAI
-	OpenAITopP          float32 `envconfig:"openai_top_p" default:"0.5"`         // TopP setting for OpenAI
-	DBName              string  `envconfig:"db_name" default:"storage.db"`       // Database name
+	TelegramToken              string          `envconfig:"telegram_token" required:"true"`                                                                                                                           // Token for accessing the Telegram API
+	TelegramAdminUID           UserID          `envconfig:"telegram_admin_uid" required:"true"`                                                                                                                       // Telegram Admin User ID
+	TelegramUserTimeout        float64         `envconfig:"telegram_user_timeout" default:"5"`                                                                                                                        // Timeout duration for Telegram users
+	TelegramReplySLO           float64         `envconfig:"telegram_reply_slo" default:"30"`                                                                                                                          // Target p95 reply latency in seconds
+	TelegramReplySLOWindow     float64         `envconfig:"telegram_reply_slo_window" default:"60"`                                                                                                                   // Window in minutes for reply latency tracking
+	TelegramReplyChainDepth    int             `envconfig:"telegram_reply_chain_depth" default:"5"`                                                                                                                   // Maximum number of reply ancestors included in the prompt
+	TelegramFloodLimit         int             `envconfig:"telegram_flood_limit" default:"5"`                                                                                                                         // Maximum number of requests per user in the flood window, unlimited when zero
+	TelegramFloodWindow        float64         `envconfig:"telegram_flood_window" default:"60"`                                                                                                                       // Window in seconds for flood detection
+	TelegramFloodCooldown      float64         `envconfig:"telegram_flood_cooldown" default:"300"`                                                                                                                    // Seconds a flooding user is ignored
+	TelegramFloodAlertAfter    int             `envconfig:"telegram_flood_alert_after" default:"3"`                                                                                                                   // Number of cooldowns after which the admin is notified
+	TelegramChatRate           float64         `envconfig:"telegram_chat_rate" default:"0"`                                                                                                                           // Requests per minute answered in each chat, unlimited when zero
+	TelegramChatBurst          int             `envconfig:"telegram_chat_burst" default:"10"`                                                                                                                         // Requests a chat may make at once before the chat rate applies
+	TelegramBlockedReaction    string          `envconfig:"telegram_blocked_reaction"`                                                                                                                                // Emoji reaction to requests of blocked users, none if empty
+	TelegramTriggerCooldown    float64         `envconfig:"telegram_trigger_cooldown" default:"30"`                                                                                                                   // Seconds between answers to trigger words in a chat
+	TelegramApprovalTimeout    float64         `envconfig:"telegram_approval_timeout" default:"600"`                                                                                                                  // Seconds a reply waits for the admin's approval before being dropped
+	TelegramDuplicateWindow    float64         `envconfig:"telegram_duplicate_window" default:"30"`                                                                                                                   // Seconds a repeated request is answered with the previous reply
+	TelegramRegenerateCooldown float64         `envconfig:"telegram_regenerate_cooldown" default:"60"`                                                                                                                // Seconds between regenerated replies in a chat
+	TelegramStreamInterval     float64         `envconfig:"telegram_stream_interval" default:"1"`                                                                                                                     // Seconds between updates of streamed replies
+	TelegramSuggestCooldown    float64         `envconfig:"telegram_suggest_cooldown" default:"60"`                                                                                                                   // Seconds between suggestions for unknown commands in a chat
+	TelegramReplyMode          string          `envconfig:"telegram_reply_mode" default:"reply"`                                                                                                                      // How answers refer to the triggering message: reply, quote, or plain
+	OpenAIToken                string          `envconfig:"openai_token" required:"true"`                                                                                                                             // Token for accessing the OpenAI API
+	OpenAIInstruction          string          `envconfig:"openai_instruction" required:"true"`                                                                                                                       // Instruction string for OpenAI
+	OpenAIModel                string          `envconfig:"openai_model" default:"gpt-4o"`                                                                                                                            // Model name for OpenAI
+	OpenAIModels               []string        `envconfig:"openai_models"`                                                                                                                                            // Models chats may choose besides the default one
+	OpenAITemperature          float32         `envconfig:"openai_temperature" default:"0.5"`                                                                                                                         // Temperature setting for OpenAI
+	OpenAITopP                 float32         `envconfig:"openai_top_p" default:"0.5"`                                                                                                                               // TopP setting for OpenAI
+	OpenAIAdaptiveTemperature  bool            `envconfig:"openai_adaptive_temperature" default:"false"`                                                                                                              // Lower the temperature for factual questions
+	OpenAIFactualTemperature   float32         `envconfig:"openai_factual_temperature" default:"0.2"`                                                                                                                 // Temperature for factual questions
+	OpenAIFactualKeywords      []string        `envconfig:"openai_factual_keywords" default:"como,qual,quando,onde,quanto,quantos,explique,calcule,código,erro,how,what,when,where,why,explain,calculate,code,error"` // Keywords marking factual questions
+	OpenAIThreading            bool            `envconfig:"openai_threading" default:"false"`                                                                                                                         // Continue conversations on the provider side
+	Stateless                  bool            `envconfig:"stateless" default:"false"`                                                                                                                                // Answer without storing chat history
+	OpenAIMaxContextTokens     int             `envconfig:"openai_max_context_tokens" default:"0"`                                                                                                                    // Token budget of the prompt, unlimited when zero
+	OpenAIContextStrategy      string          `envconfig:"openai_context_strategy" default:"truncate"`                                                                                                               // How history over budget is handled: truncate or summarize
+	OpenAISummaryMaxTokens     int             `envconfig:"openai_summary_max_tokens" default:"4000"`                                                                                                                 // Maximum number of overflow tokens sent for summarization
+	OpenAIMaxInputTokens       int             `envconfig:"openai_max_input_tokens" default:"1000"`                                                                                                                   // Token cap of the user message in the prompt, unlimited when zero
+	OpenAIInputStrategy        string          `envconfig:"openai_input_strategy" default:"truncate"`                                                                                                                 // How user messages over the cap are handled: truncate or summarize
+	OpenAICritiqueModel        string          `envconfig:"openai_critique_model" default:"gpt-4o-mini"`                                                                                                              // Model reviewing draft replies in chats with critique enabled
+	OpenAICritiqueDailyTokens  int             `envconfig:"openai_critique_daily_tokens" default:"50000"`                                                                                                             // Daily token budget of the critique pass, unlimited when zero
+	OpenAIEmbeddingModel       string          `envconfig:"openai_embedding_model" default:"text-embedding-3-small"`                                                                                                  // Model computing embeddings for OpenAI
+	OpenAITranscriptionModel   string          `envconfig:"openai_transcription_model" default:"whisper-1"`                                                                                                           // Model transcribing voice messages for OpenAI
+	VoiceMessages              bool            `envconfig:"voice_messages" default:"false"`                                                                                                                           // Transcribe and answer voice messages and audio files
+	VoiceMaxDuration           int             `envconfig:"voice_max_duration" default:"120"`                                                                                                                         // Longest voice message transcribed, in seconds
+	DriftPromptsFile           string          `envconfig:"drift_prompts_file"`                                                                                                                                       // File with the benchmark prompts of drift detection, one per line, disabled if empty
+	DriftCheckInterval         float64         `envconfig:"drift_check_interval" default:"1440"`                                                                                                                      // Minutes between drift checks, disabled when zero
+	DriftThreshold             float64         `envconfig:"drift_threshold" default:"0.85"`                                                                                                                           // Similarity to the baseline below which an answer has drifted
+	SemanticRecall             int             `envconfig:"semantic_recall" default:"0"`                                                                                                                              // Number of related earlier messages recalled into prompts, disabled when zero
+	SemanticRecallThreshold    float64         `envconfig:"semantic_recall_threshold" default:"0.5"`                                                                                                                  // Similarity to the current message above which an earlier message is recalled
+	SemanticIndexInterval      float64         `envconfig:"semantic_index_interval" default:"5"`                                                                                                                      // Minutes between runs embedding new chat history for recall
+	TelegramStyleLearning      bool            `envconfig:"telegram_style_learning" default:"false"`                                                                                                                  // Hint the writing style of each chat in the system prompt
+	ChatMemory                 bool            `envconfig:"chat_memory" default:"false"`                                                                                                                              // Let the model keep short facts about each chat
+	FollowUps                  bool            `envconfig:"follow_ups" default:"false"`                                                                                                                               // Let the model schedule follow-up messages in chats
+	FollowUpsMaxPerChat        int             `envconfig:"follow_ups_max_per_chat" default:"5"`                                                                                                                      // Maximum number of follow-ups scheduled per chat
+	FollowUpsMaxDays           int             `envconfig:"follow_ups_max_days" default:"30"`                                                                                                                         // Maximum number of days ahead a follow-up may be scheduled
+	ChatMemoryMaxEntries       int             `envconfig:"chat_memory_max_entries" default:"10"`                                                                                                                     // Maximum number of facts kept per chat
+	FeatureFlags               map[string]bool `envconfig:"feature_flags"`                                                                                                                                            // Feature flags enabled or disabled in all chats, e.g. regenerate:false
+	LogSampling                map[string]int  `envconfig:"log_sampling"`                                                                                                                                             // Log one in every N lines of noisy components, e.g. incoming:10,flood:5
+	AnalyticsDir               string          `envconfig:"analytics_dir"`                                                                                                                                            // Directory for daily CSV analytics files, disabled if empty
+	MetricsListenAddr          string          `envconfig:"metrics_listen_addr"`                                                                                                                                      // Address of the HTTP server with /healthz and /metrics, disabled if empty
+	WebhookURLs                []string        `envconfig:"webhook_urls"`                                                                                                                                             // URLs notified of bot events
+	WebhookSecret              string          `envconfig:"webhook_secret"`                                                                                                                                           // Secret used to sign webhook payloads
+	WebhookEvents              []string        `envconfig:"webhook_events"`                                                                                                                                           // Events sent to webhooks, all events if empty
+	AdminDigest                bool            `envconfig:"admin_digest" default:"false"`                                                                                                                             // Send the admin a daily digest of all chats
+	AdminDigestHour            int             `envconfig:"admin_digest_hour" default:"9"`                                                                                                                            // Hour of the day after which the admin digest is sent
+	MembershipSyncInterval     float64         `envconfig:"membership_sync_interval" default:"360"`                                                                                                                   // Minutes between chat membership syncs, disabled when zero
+	StorageCheckInterval       float64         `envconfig:"storage_check_interval" default:"60"`                                                                                                                      // Minutes between storage checks, disabled when zero
+	StorageMaxSize             int             `envconfig:"storage_max_size" default:"500"`                                                                                                                           // Database size limit in MB, unlimited when zero
+	StorageMaxHistoryRows      int             `envconfig:"storage_max_history_rows" default:"200000"`                                                                                                                // Chat history row limit, unlimited when zero
+	StorageHistoryRetention    int             `envconfig:"storage_history_retention" default:"0"`                                                                                                                    // Days chat history is kept, forever when zero
+	StorageAutoRetention       bool            `envconfig:"storage_auto_retention" default:"false"`                                                                                                                   // Halve the history retention when a storage limit is exceeded
+	StorageMinRetention        int             `envconfig:"storage_min_retention" default:"7"`                                                                                                                        // Minimum days of history kept by automatic retention
+	DBReadConnection           bool            `envconfig:"db_read_connection" default:"false"`                                                                                                                       // Run stats and export queries on a separate read-only connection
+	DBName                     string          `envconfig:"db_name" default:"storage.db"`                                                                                                                             // Database name
 }
 
 // NewConfig initializes the configuration by processing environment variables.
@@ -27,5 +94,49 @@ func NewConfig() (*Config, error) {
 		return nil, WrapError("failed to process environment variables", err)
 	}
 
+	if config.TelegramReplyMode != "reply" && config.TelegramReplyMode != "quote" && config.TelegramReplyMode != "plain" {
+		return nil, WrapError(fmt.Sprintf("invalid reply mode %q", config.TelegramReplyMode))
+	}
+	if config.OpenAIContextStrategy != "truncate" && config.OpenAIContextStrategy != "summarize" {
+		return nil, WrapError(fmt.Sprintf("invalid context strategy %q", config.OpenAIContextStrategy))
+	}
+	if config.OpenAIInputStrategy != "truncate" && config.OpenAIInputStrategy != "summarize" {
+		return nil, WrapError(fmt.Sprintf("invalid input strategy %q", config.OpenAIInputStrategy))
+	}
+
+	// Resolve secrets given as references instead of plain values
+	for _, secret := range []*string{&config.TelegramToken, &config.OpenAIToken, &config.WebhookSecret} {
+		*secret, err = resolveSecret(*secret)
+		if err != nil {
+			return nil, WrapError("failed to resolve secret", err)
+		}
+	}
+
 	return &config, nil
 }
+
+// resolveSecret returns the value of a secret, which is either given in plain text or as an
+// env://NAME reference to another environment variable or a file://path reference to a file.
+func resolveSecret(value string) (string, error) {
+	scheme, ref, found := strings.Cut(value, "://")
+	if !found {
+		return value, nil
+	}
+
+	switch scheme {
+	case "env":
+		secret, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", WrapError(fmt.Sprintf("environment variable %q not set", ref))
+		}
+		return secret, nil
+	case "file":
+		data, err := os.ReadFile(ref)
+		if err != nil {
+			return "", WrapError("failed to read secret file", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	default:
+		return "", WrapError(fmt.Sprintf("unsupported secret scheme %q", scheme))
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// chatContextSizeSetting is the chat settings key overriding the number of history entries
+// included in prompts.
+const chatContextSizeSetting = "context_size"
+
+// maxContextSize is the largest number of history entries a chat may include in prompts.
+const maxContextSize = 200
+
+// historyLimit returns the number of recent chat history entries included in prompts of a chat.
+func (tg *Telegram) historyLimit(chatID ChatID) int {
+	value, ok, err := tg.db.GetChatSetting(chatID, chatContextSizeSetting)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to get chat context size")
+		return promptHistoryLimit
+	}
+	if !ok {
+		return promptHistoryLimit
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 || limit > maxContextSize {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Str("value", value).Msg("Invalid chat context size")
+		return promptHistoryLimit
+	}
+	return limit
+}
+
+// handleMrlContextSizeRequest processes the /mrl_context_size command, which sets the number of
+// history entries included in prompts of the chat or, with "default", restores the default.
+func (tg *Telegram) handleMrlContextSizeRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_CONTEXT_SIZE request")
+
+	usage := fmt.Sprintf("/mrl_context_size <0-%d|default>", maxContextSize)
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 1)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	arg := args.Arg(0)
+	switch arg {
+	case "":
+		return tg.sendTelegramMessage(ctx, fmt.Sprintf("Context size: %d\nUsage: %s", tg.historyLimit(chatID), usage))
+	case "default":
+		err = tg.db.DeleteChatSetting(chatID, chatContextSizeSetting)
+		if err != nil {
+			return WrapError("failed to delete chat context size", err)
+		}
+		return tg.sendTelegramMessage(ctx, fmt.Sprintf("Context size set to the default of %d.", promptHistoryLimit))
+	}
+
+	limit, err := strconv.Atoi(arg)
+	if err != nil || limit < 0 || limit > maxContextSize {
+		return tg.sendTelegramMessage(ctx, fmt.Sprintf("Context size must be a number between 0 and %d, or default.", maxContextSize))
+	}
+	err = tg.db.SetChatSetting(chatID, chatContextSizeSetting, strconv.Itoa(limit))
+	if err != nil {
+		return WrapError("failed to set chat context size", err)
+	}
+	return tg.sendTelegramMessage(ctx, fmt.Sprintf("Context size set to %d.", limit))
+}
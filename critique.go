@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// chatCritiqueSetting is the chat settings key marking chats whose replies are reviewed before
+// being sent.
+const chatCritiqueSetting = "critique"
+
+// critiqueInstruction asks the reviewing model to correct a draft reply without rewriting it.
+const critiqueInstruction = `You review a draft reply written by a chat bot before it is sent. The bot's persona and rules are given below.
+Fix factual mistakes, tone that breaks the persona, and anything the rules forbid. Keep the language, length and formatting of the draft, and change nothing that is already fine.
+Answer with the final reply only, without comments.
+
+Persona and rules:
+`
+
+// TokenBudget limits the number of tokens spent per day.
+type TokenBudget struct {
+	mu        sync.Mutex
+	limit     int    // Tokens allowed per day, unlimited when zero
+	day       string // Day the used tokens refer to
+	used      int    // Tokens spent on the day
+	exhausted bool   // Whether a request was refused on the day
+}
+
+// NewTokenBudget creates a new daily token budget.
+func NewTokenBudget(limit int) *TokenBudget {
+	return &TokenBudget{limit: limit}
+}
+
+// spend reserves tokens from the budget of the current day, reporting whether they were available
+// and, when not, whether this is the first refusal of the day.
+func (budget *TokenBudget) spend(tokens int) (allowed, exhausted bool) {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	day := time.Now().Format(time.DateOnly)
+	if budget.day != day {
+		budget.day, budget.used, budget.exhausted = day, 0, false
+	}
+	if budget.limit > 0 && budget.used+tokens > budget.limit {
+		exhausted = !budget.exhausted
+		budget.exhausted = true
+		return false, exhausted
+	}
+	budget.used += tokens
+	return true, false
+}
+
+// critiqueEnabled reports whether replies in a chat are reviewed before being sent.
+func (tg *Telegram) critiqueEnabled(chatID ChatID) bool {
+	_, enabled, err := tg.db.GetChatSetting(chatID, chatCritiqueSetting)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to get critique setting")
+		return false
+	}
+	return enabled
+}
+
+// critiqueReply passes a draft reply through a second, cheaper model call that fixes tone and
+// errors against the persona. The draft is returned unchanged when critique is disabled in the
+// chat, the daily budget is exhausted, or the call fails.
+func (tg *Telegram) critiqueReply(chatID ChatID, instruction, request, draft string) string {
+	if !tg.critiqueEnabled(chatID) {
+		return draft
+	}
+
+	messages := []map[string]string{
+		{"role": "system", "content": critiqueInstruction + instruction},
+		{"role": "user", "content": "Message: " + request + "\n\nDraft reply: " + draft},
+	}
+	maxTokens := estimateTokens(draft)*2 + 100
+	allowed, exhausted := tg.critique.spend(messagesTokens(messages) + maxTokens)
+	if !allowed {
+		log.Warn().Int64("chat_id", int64(chatID)).Msg("Critique budget exhausted, sending draft")
+		if exhausted {
+			tg.webhooks.Notify(EventBudgetHit, map[string]interface{}{"budget": "critique", "limit": tg.config.OpenAICritiqueDailyTokens, "chat_id": chatID})
+		}
+		return draft
+	}
+
+	temperature := float32(0)
+	revised, err := tg.oai.CallWithOptions(messages, CallOptions{
+		MaxTokens:   maxTokens,
+		Temperature: &temperature,
+		Model:       tg.config.OpenAICritiqueModel,
+	})
+	if err != nil {
+		log.Warn().Err(err).Int64("chat_id", int64(chatID)).Msg("Critique failed, sending draft")
+		return draft
+	}
+	return strings.TrimSpace(revised)
+}
+
+// handleMrlCritiqueRequest processes the /mrl_critique command, which shows or toggles the review
+// of replies in the chat.
+func (tg *Telegram) handleMrlCritiqueRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_CRITIQUE request")
+
+	usage := "/mrl_critique <on|off>"
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 1)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	switch args.Arg(0) {
+	case "":
+		_, enabled, err := tg.db.GetChatSetting(chatID, chatCritiqueSetting)
+		if err != nil {
+			return WrapError("failed to get critique setting", err)
+		}
+		state := "off"
+		if enabled {
+			state = "on"
+		}
+		return tg.sendTelegramMessage(ctx, "Critique: "+state+"\nUsage: "+usage)
+	case "on":
+		err = tg.db.SetChatSetting(chatID, chatCritiqueSetting, "on")
+		if err != nil {
+			return WrapError("failed to enable critique", err)
+		}
+		return tg.sendTelegramMessage(ctx, "Critique enabled.")
+	case "off":
+		err = tg.db.DeleteChatSetting(chatID, chatCritiqueSetting)
+		if err != nil {
+			return WrapError("failed to disable critique", err)
+		}
+		return tg.sendTelegramMessage(ctx, "Critique disabled.")
+	default:
+		return tg.sendUsage(ctx, usage, nil)
+	}
+}
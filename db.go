@@ -2,6 +2,9 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -9,34 +12,157 @@ import (
 
 // User represents a user in the database.
 type User struct {
-	UserID   int64     // Unique identifier for the user
+	UserID   UserID    // Unique identifier for the user
 	LastUsed time.Time // Timestamp of the last time the user was active
 }
 
 // MessageRef represents a message reference in the database.
 type MessageRef struct {
 	ID        uint      // Unique identifier for the message reference
-	MessageID int64     // ID of the message
-	ChatID    int64     // ID of the chat
+	MessageID MessageID // ID of the message
+	ChatID    ChatID    // ID of the chat
 	LastUsed  time.Time // Timestamp of the last time the message reference was used
 }
 
 // ChatHistory represents chat history in the database.
 type ChatHistory struct {
-	ID       uint      // Unique identifier for the chat history entry
-	UserID   int64     // ID of the user
-	UserName string    // Name of the user
-	UserMsg  string    // Message sent by the user
-	BotMsg   string    // Message sent by the bot
-	LastUsed time.Time // Timestamp of the last time the chat history entry was used
+	ID               uint          // Unique identifier for the chat history entry
+	ChatID           ChatID        // ID of the chat
+	UserID           UserID        // ID of the user
+	UserName         string        // Name of the user
+	UserMsg          string        // Message sent by the user
+	BotMsg           string        // Message sent by the bot
+	LastUsed         time.Time     // Timestamp of the last time the chat history entry was used
+	ResponseID       string        // ID of the provider-side response, when threading is enabled
+	LanguageCode     string        // Telegram language code of the user
+	Latency          time.Duration // Time between receiving the request and sending the reply
+	MessageID        MessageID     // Telegram ID of the user message
+	ReplyToMessageID MessageID     // Telegram ID of the message the user message replied to
+	ReplyContext     string        // Note on the replied message prepended to the user message in the prompt
+	BotMessageID     MessageID     // Telegram ID of the bot reply
+	Regenerated      bool          // Whether the bot reply replaced a regenerated one
+	Edited           bool          // Whether the user edited their message after it was stored
+	EditedAt         time.Time     // Timestamp of the last edit of the user message
 }
 
+// ChatMemoryEntry represents a fact the model keeps about a chat.
+type ChatMemoryEntry struct {
+	Key       string    // Name of the fact
+	Value     string    // Content of the fact
+	UpdatedAt time.Time // Timestamp of the last change
+}
+
+// Stats represents aggregate counters over the stored data.
+type Stats struct {
+	MessageRefs   int64 // Number of stored message references
+	ChatHistory   int64 // Number of stored chat history entries
+	BlockedChats  int64 // Number of chats where the bot is blocked
+	MigratedChats int64 // Number of chats migrated to supergroups
+	Refusals      int64 // Number of recorded model refusals and empty responses
+	Exports       int64 // Number of forwarded message references
+	Throttled     int64 // Number of requests throttled as floods
+}
+
+// ExportAudit represents a record of a stored message forwarded to a chat.
+type ExportAudit struct {
+	ID           uint      // Unique identifier for the audit record
+	SourceChatID ChatID    // ID of the chat the message was stored from
+	MessageID    MessageID // ID of the forwarded message
+	DestChatID   ChatID    // ID of the chat the message was forwarded to
+	UserID       UserID    // ID of the user who requested the forward
+	ExportedAt   time.Time // Timestamp of the forward
+}
+
+// AdminAudit represents a record of an admin command invocation.
+type AdminAudit struct {
+	ID        uint      // Unique identifier for the audit record
+	UserID    UserID    // ID of the user who sent the command
+	UserName  string    // Username of the user who sent the command
+	ChatID    ChatID    // ID of the chat the command was sent in
+	Command   string    // Command name without the leading slash
+	Args      string    // Text after the command
+	Result    string    // Outcome: ok, denied, or the error message
+	CreatedAt time.Time // Timestamp of the invocation
+}
+
+// Quote represents a memorable message saved in a chat.
+type Quote struct {
+	ID         uint      // Unique identifier for the quote
+	ChatID     ChatID    // ID of the chat the message was sent in
+	MessageID  MessageID // ID of the quoted message
+	AuthorID   UserID    // ID of the author of the message
+	AuthorName string    // Name of the author of the message
+	Text       string    // Text of the message
+	SavedBy    UserID    // ID of the user who saved the quote
+	SentAt     time.Time // Timestamp of the message
+}
+
+// FollowUp represents a message the model scheduled to post in a chat later.
+type FollowUp struct {
+	ID        uint      // Unique identifier for the follow-up
+	ChatID    ChatID    // ID of the chat to post in
+	UserID    UserID    // ID of the user whose message led to the follow-up
+	MessageID MessageID // ID of the message the follow-up replies to
+	Note      string    // Text to post
+	DueAt     time.Time // Time to post at
+	CreatedAt time.Time // Timestamp of the scheduling
+}
+
+// APIErrorCount represents the number of Telegram API errors of a kind.
+type APIErrorCount struct {
+	Kind     string    // Kind of the errors
+	Count    int64     // Number of errors
+	LastSeen time.Time // Timestamp of the last error
+}
+
+// APIErrorSample represents a recent Telegram API error.
+type APIErrorSample struct {
+	Kind        string    // Kind of the error
+	ChatID      ChatID    // ID of the chat of the failed request
+	Description string    // Description returned by Telegram
+	CreatedAt   time.Time // Timestamp of the error
+}
+
+// ChatRule represents a canned answer to requests matching a pattern in a chat.
+type ChatRule struct {
+	ID        uint      // Unique identifier for the rule
+	ChatID    ChatID    // ID of the chat the rule applies to
+	Pattern   string    // Trigger words or /regex/ entries, comma-separated
+	Action    string    // What to do on a match: reply or react
+	Response  string    // Reply text, or the reaction emoji
+	CreatedAt time.Time // Timestamp of the rule creation
+}
+
+// UserActivity represents the activity of a user in a chat.
+type UserActivity struct {
+	FirstSeen time.Time      // Timestamp of the first recorded message
+	LastSeen  time.Time      // Timestamp of the last recorded message
+	Messages  int64          // Total number of recorded messages
+	Weeks     map[string]int // Number of messages by ISO week, formatted as YYYY-Www
+}
+
+// Refusal represents a model refusal or empty response in the database.
+type Refusal struct {
+	ID           uint      // Unique identifier for the refusal
+	ChatID       ChatID    // ID of the chat
+	UserID       UserID    // ID of the user who made the request
+	PromptHash   string    // Hash of the prompt sent to the model
+	FinishReason string    // Reason the model stopped generating
+	Refusal      string    // Refusal message of the model, if any
+	CreatedAt    time.Time // Timestamp of the refusal
+}
+
+// maxHistoryTimeRange is the longest time range accepted by time-window history queries.
+const maxHistoryTimeRange = 31 * 24 * time.Hour
+
 // DB implements the database interactions using SQLite.
 type DB struct {
 	conn *sql.DB // Database connection
+	read *sql.DB // Read-only connection for analytical queries, nil when they share conn
 }
 
-// NewDB initializes the database connection and schema.
+// NewDB initializes the database connection and schema, applies the pending migrations, and
+// opens the read-only connection when enabled.
 func NewDB(config *Config) (*DB, error) {
 	conn, err := sql.Open("sqlite3", config.DBName)
 	if err != nil {
@@ -48,40 +174,71 @@ func NewDB(config *Config) (*DB, error) {
 	if err != nil {
 		return nil, WrapError("failed to set up database schema", err)
 	}
+
+	if config.DBReadConnection {
+		// In WAL mode readers do not wait for the writer, which the read-only connection needs
+		// to keep analytical queries from contending with it
+		_, err = conn.Exec("PRAGMA journal_mode=WAL")
+		if err != nil {
+			return nil, WrapError("failed to enable WAL mode", err)
+		}
+		db.read, err = sql.Open("sqlite3", readOnlyDSN(config.DBName))
+		if err != nil {
+			return nil, WrapError("failed to open read-only connection", err)
+		}
+	}
 	return db, nil
 }
 
-// setupSchema creates the necessary tables if they don't already exist.
-func (db *DB) setupSchema() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS message_ref (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		message_id INTEGER NOT NULL,
-		chat_id INTEGER NOT NULL,
-		last_used DATETIME
-	);
-	CREATE TABLE IF NOT EXISTS user (
-		user_id INTEGER PRIMARY KEY,
-		last_used DATETIME
-	);
-	CREATE TABLE IF NOT EXISTS chat_history (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_id INTEGER NOT NULL,
-		user_name TEXT NOT NULL,
-		user_msg TEXT NOT NULL,
-		bot_msg TEXT NOT NULL,
-		last_used DATETIME
-	);`
+// readOnlyDSN returns the data source name opening a database file in read-only mode.
+func readOnlyDSN(name string) string {
+	if !strings.HasPrefix(name, "file:") {
+		name = "file:" + name
+	}
+	if strings.Contains(name, "?") {
+		return name + "&mode=ro"
+	}
+	return name + "?mode=ro"
+}
+
+// reader returns the connection for analytical queries, the read-only one when enabled.
+func (db *DB) reader() *sql.DB {
+	if db.read != nil {
+		return db.read
+	}
+	return db.conn
+}
+
+// Close closes the database connections.
+func (db *DB) Close() error {
+	if db.read != nil {
+		err := db.read.Close()
+		if err != nil {
+			return WrapError("failed to close read-only connection", err)
+		}
+	}
+	err := db.conn.Close()
+	if err != nil {
+		return WrapError("failed to close database", err)
+	}
+	return nil
+}
 
-	_, err := db.conn.Exec(schema)
+// setupSchema brings the database schema up to date by applying the pending migrations.
+func (db *DB) setupSchema() error {
+	err := db.prepareMigrations()
+	if err != nil {
+		return err
+	}
+	_, err = db.MigrateUp()
 	if err != nil {
-		return WrapError("failed to execute schema setup", err)
+		return WrapError("failed to migrate database", err)
 	}
 	return nil
 }
 
 // GetOrCreateUser fetches a user from the database or creates one if not found.
-func (db *DB) GetOrCreateUser(userID int64, timeout float64) (User, error) {
+func (db *DB) GetOrCreateUser(userID UserID, timeout float64) (User, error) {
 	var user User
 	query := "SELECT user_id, last_used FROM user WHERE user_id = ?"
 	insertQuery := "INSERT INTO user (user_id, last_used) VALUES (?, ?)"
@@ -115,18 +272,25 @@ func (db *DB) UpdateUserLastUsed(user User) error {
 	return nil
 }
 
-// GetRandomMessageRef retrieves a random message reference from the database.
-func (db *DB) GetRandomMessageRef() (MessageRef, error) {
+// GetRandomMessageRef retrieves a random message reference that may be forwarded to the given
+// chat, skipping chats whose export policy only allows forwarding within the same chat.
+func (db *DB) GetRandomMessageRef(destChatID ChatID) (MessageRef, error) {
 	var msgRef MessageRef
 	selectQuery := `
 		SELECT id, message_id, chat_id, last_used
 		FROM message_ref
-		WHERE id IN (SELECT id FROM message_ref ORDER BY last_used ASC LIMIT 5)
+		WHERE id IN (
+			SELECT id FROM message_ref
+			WHERE chat_id = ? OR chat_id NOT IN (
+				SELECT chat_id FROM chat_setting WHERE key = ? AND value = ?
+			)
+			ORDER BY last_used ASC LIMIT 5
+		)
 		ORDER BY RANDOM()
 		LIMIT 1`
 	updateQuery := "UPDATE message_ref SET last_used = ? WHERE id = ?"
 
-	err := db.conn.QueryRow(selectQuery).Scan(&msgRef.ID, &msgRef.MessageID, &msgRef.ChatID, &msgRef.LastUsed)
+	err := db.conn.QueryRow(selectQuery, destChatID, chatExportPolicySetting, ExportPolicySameChat).Scan(&msgRef.ID, &msgRef.MessageID, &msgRef.ChatID, &msgRef.LastUsed)
 	if err != nil {
 		return msgRef, WrapError("failed to retrieve random message reference", err)
 	}
@@ -149,14 +313,37 @@ func (db *DB) AddMessageRef(msgRef *MessageRef) error {
 	return nil
 }
 
-// GetRecentChatHistory retrieves recent chat history from the database.
-func (db *DB) GetRecentChatHistory(limit int) ([]ChatHistory, error) {
+// chatHistoryColumns lists the chat history columns read by scanChatHistory.
+const chatHistoryColumns = `id, chat_id, user_id, user_name, user_msg, bot_msg, last_used, response_id,
+	language_code, latency_ms, message_id, reply_to_message_id, reply_context, bot_message_id, regenerated, edited, edited_at`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanChatHistory scans a chat history entry selected with chatHistoryColumns.
+func scanChatHistory(row rowScanner) (ChatHistory, error) {
+	var entry ChatHistory
+	var latencyMs int64
+	var editedAt sql.NullTime
+	err := row.Scan(&entry.ID, &entry.ChatID, &entry.UserID, &entry.UserName, &entry.UserMsg, &entry.BotMsg, &entry.LastUsed, &entry.ResponseID,
+		&entry.LanguageCode, &latencyMs, &entry.MessageID, &entry.ReplyToMessageID, &entry.ReplyContext, &entry.BotMessageID, &entry.Regenerated, &entry.Edited, &editedAt)
+	entry.Latency = time.Duration(latencyMs) * time.Millisecond
+	entry.EditedAt = editedAt.Time
+	return entry, err
+}
+
+// GetRecentChatHistory retrieves recent chat history of a chat stored since the given time from
+// the database, skipping retracted replies.
+func (db *DB) GetRecentChatHistory(chatID ChatID, since time.Time, limit int) ([]ChatHistory, error) {
 	query := `
-		SELECT id, user_id, user_name, user_msg, bot_msg, last_used
+		SELECT ` + chatHistoryColumns + `
 		FROM chat_history
+		WHERE chat_id = ? AND last_used >= ? AND retracted = 0
 		ORDER BY last_used DESC
 		LIMIT ?`
-	rows, err := db.conn.Query(query, limit)
+	rows, err := db.conn.Query(query, chatID, since, limit)
 	if err != nil {
 		return nil, WrapError("failed to retrieve recent chat history", err)
 	}
@@ -164,8 +351,38 @@ func (db *DB) GetRecentChatHistory(limit int) ([]ChatHistory, error) {
 
 	var history []ChatHistory
 	for rows.Next() {
-		var entry ChatHistory
-		err := rows.Scan(&entry.ID, &entry.UserID, &entry.UserName, &entry.UserMsg, &entry.BotMsg, &entry.LastUsed)
+		entry, err := scanChatHistory(rows)
+		if err != nil {
+			return nil, WrapError("failed to scan chat history", err)
+		}
+		history = append(history, entry)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return history, nil
+}
+
+// GetChatHistoryBefore retrieves the chat history entries of a chat stored since the given time
+// and right before another, newest first, as GetRecentChatHistory returned them at that time.
+func (db *DB) GetChatHistoryBefore(chatID ChatID, since, before time.Time, limit int) ([]ChatHistory, error) {
+	query := `
+		SELECT ` + chatHistoryColumns + `
+		FROM chat_history
+		WHERE chat_id = ? AND last_used >= ? AND last_used < ? AND retracted = 0
+		ORDER BY last_used DESC
+		LIMIT ?`
+	rows, err := db.conn.Query(query, chatID, since, before, limit)
+	if err != nil {
+		return nil, WrapError("failed to retrieve chat history before time", err)
+	}
+	defer rows.Close()
+
+	var history []ChatHistory
+	for rows.Next() {
+		entry, err := scanChatHistory(rows)
 		if err != nil {
 			return nil, WrapError("failed to scan chat history", err)
 		}
@@ -181,14 +398,221 @@ func (db *DB) GetRecentChatHistory(limit int) ([]ChatHistory, error) {
 
 // AddChatHistory inserts new chat history into the database.
 func (db *DB) AddChatHistory(history *ChatHistory) error {
-	query := "INSERT INTO chat_history (user_id, user_name, user_msg, bot_msg, last_used) VALUES (?, ?, ?, ?, ?)"
-	_, err := db.conn.Exec(query, history.UserID, history.UserName, history.UserMsg, history.BotMsg, history.LastUsed)
+	query := `
+		INSERT INTO chat_history (chat_id, user_id, user_name, user_msg, bot_msg, last_used, response_id,
+			language_code, latency_ms, message_id, reply_to_message_id, reply_context, bot_message_id, regenerated, edited, edited_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.conn.Exec(query, history.ChatID, history.UserID, history.UserName, history.UserMsg, history.BotMsg, history.LastUsed, history.ResponseID,
+		history.LanguageCode, history.Latency.Milliseconds(), history.MessageID, history.ReplyToMessageID, history.ReplyContext, history.BotMessageID, history.Regenerated,
+		history.Edited, sql.NullTime{Time: history.EditedAt, Valid: history.Edited})
 	if err != nil {
 		return WrapError("failed to add chat history", err)
 	}
 	return nil
 }
 
+// GetChatHistoryInTimeRange retrieves the chat history of a chat between from (inclusive) and to
+// (exclusive), oldest first, skipping retracted replies. Ranges longer than maxHistoryTimeRange
+// are rejected.
+func (db *DB) GetChatHistoryInTimeRange(chatID ChatID, from, to time.Time) ([]ChatHistory, error) {
+	if !from.Before(to) {
+		return nil, WrapError("invalid time range: start must be before end")
+	}
+	if to.Sub(from) > maxHistoryTimeRange {
+		return nil, WrapError(fmt.Sprintf("time range too long: at most %s allowed", maxHistoryTimeRange))
+	}
+
+	query := `
+		SELECT ` + chatHistoryColumns + `
+		FROM chat_history
+		WHERE chat_id = ? AND last_used >= ? AND last_used < ? AND retracted = 0
+		ORDER BY last_used ASC`
+	rows, err := db.reader().Query(query, chatID, from, to)
+	if err != nil {
+		return nil, WrapError("failed to retrieve chat history in time range", err)
+	}
+	defer rows.Close()
+
+	var history []ChatHistory
+	for rows.Next() {
+		entry, err := scanChatHistory(rows)
+		if err != nil {
+			return nil, WrapError("failed to scan chat history", err)
+		}
+		history = append(history, entry)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return history, nil
+}
+
+// GetChatHistoryByMessage returns the chat history entry of a chat whose user message or bot
+// reply has the given Telegram message ID, unless the reply was retracted.
+func (db *DB) GetChatHistoryByMessage(chatID ChatID, messageID MessageID) (ChatHistory, bool, error) {
+	query := `
+		SELECT ` + chatHistoryColumns + `
+		FROM chat_history
+		WHERE chat_id = ? AND (message_id = ? OR bot_message_id = ?) AND retracted = 0
+		LIMIT 1`
+	entry, err := scanChatHistory(db.conn.QueryRow(query, chatID, messageID, messageID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entry, false, nil
+		}
+		return entry, false, WrapError("failed to retrieve chat history by message", err)
+	}
+	return entry, true, nil
+}
+
+// GetActiveChats returns the chats with chat history stored since the given time.
+func (db *DB) GetActiveChats(since time.Time) ([]ChatID, error) {
+	rows, err := db.conn.Query("SELECT DISTINCT chat_id FROM chat_history WHERE last_used >= ?", since)
+	if err != nil {
+		return nil, WrapError("failed to retrieve active chats", err)
+	}
+	defer rows.Close()
+
+	var chatIDs []ChatID
+	for rows.Next() {
+		var chatID ChatID
+		err := rows.Scan(&chatID)
+		if err != nil {
+			return nil, WrapError("failed to scan chat ID", err)
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return chatIDs, nil
+}
+
+// GetUserMessages returns up to limit of the newest user messages answered by the bot in a chat
+// since the given time.
+func (db *DB) GetUserMessages(chatID ChatID, since time.Time, limit int) ([]string, error) {
+	query := `
+		SELECT user_msg
+		FROM chat_history
+		WHERE chat_id = ? AND last_used >= ? AND bot_msg != ''
+		ORDER BY last_used DESC
+		LIMIT ?`
+	rows, err := db.conn.Query(query, chatID, since, limit)
+	if err != nil {
+		return nil, WrapError("failed to retrieve user messages", err)
+	}
+	defer rows.Close()
+
+	var messages []string
+	for rows.Next() {
+		var message string
+		err := rows.Scan(&message)
+		if err != nil {
+			return nil, WrapError("failed to scan user message", err)
+		}
+		messages = append(messages, message)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return messages, nil
+}
+
+// GetDominantLanguage returns the language code used most often by users in a chat, or an
+// empty string when it is unknown.
+func (db *DB) GetDominantLanguage(chatID ChatID) (string, error) {
+	var languageCode string
+	query := `
+		SELECT language_code
+		FROM chat_history
+		WHERE chat_id = ? AND language_code != ''
+		GROUP BY language_code
+		ORDER BY COUNT(*) DESC
+		LIMIT 1`
+	err := db.conn.QueryRow(query, chatID).Scan(&languageCode)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", WrapError("failed to get dominant language", err)
+	}
+	return languageCode, nil
+}
+
+// GetReplyLatencies returns the reply latencies recorded for a chat since the given time.
+func (db *DB) GetReplyLatencies(chatID ChatID, since time.Time) ([]time.Duration, error) {
+	query := `
+		SELECT latency_ms
+		FROM chat_history
+		WHERE chat_id = ? AND last_used >= ? AND latency_ms > 0`
+	rows, err := db.reader().Query(query, chatID, since)
+	if err != nil {
+		return nil, WrapError("failed to retrieve reply latencies", err)
+	}
+	defer rows.Close()
+
+	var latencies []time.Duration
+	for rows.Next() {
+		var latencyMs int64
+		err := rows.Scan(&latencyMs)
+		if err != nil {
+			return nil, WrapError("failed to scan reply latency", err)
+		}
+		latencies = append(latencies, time.Duration(latencyMs)*time.Millisecond)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return latencies, nil
+}
+
+// GetLastResponseID returns the provider-side response ID of the most recent chat history entry
+// of a chat stored since the given time.
+func (db *DB) GetLastResponseID(chatID ChatID, since time.Time) (string, error) {
+	var responseID string
+	query := "SELECT response_id FROM chat_history WHERE chat_id = ? AND last_used >= ? AND retracted = 0 ORDER BY last_used DESC LIMIT 1"
+	err := db.conn.QueryRow(query, chatID, since).Scan(&responseID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", WrapError("failed to get last response ID", err)
+	}
+	return responseID, nil
+}
+
+// RetractBotMessage marks the chat history entry of a bot reply as retracted, excluding it from
+// future context. It reports whether an entry was found.
+func (db *DB) RetractBotMessage(chatID ChatID, messageID MessageID) (bool, error) {
+	result, err := db.conn.Exec("UPDATE chat_history SET retracted = 1 WHERE chat_id = ? AND bot_message_id = ?", chatID, messageID)
+	if err != nil {
+		return false, WrapError("failed to retract bot message", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, WrapError("failed to get affected rows", err)
+	}
+	return affected > 0, nil
+}
+
+// RetractChatHistory marks a chat history entry as retracted, excluding it from future context,
+// such as when its reply was regenerated.
+func (db *DB) RetractChatHistory(id uint) error {
+	_, err := db.conn.Exec("UPDATE chat_history SET retracted = 1 WHERE id = ?", id)
+	if err != nil {
+		return WrapError("failed to retract chat history", err)
+	}
+	return nil
+}
+
 // ClearChatHistory deletes all chat history from the database.
 func (db *DB) ClearChatHistory() error {
 	query := "DELETE FROM chat_history"
@@ -198,3 +622,1298 @@ func (db *DB) ClearChatHistory() error {
 	}
 	return nil
 }
+
+// DeleteChatHistoryBefore deletes the chat history entries stored before the given time and
+// returns the number of deleted entries.
+func (db *DB) DeleteChatHistoryBefore(before time.Time) (int64, error) {
+	result, err := db.conn.Exec("DELETE FROM chat_history WHERE last_used < ?", before)
+	if err != nil {
+		return 0, WrapError("failed to delete old chat history", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, WrapError("failed to get affected rows", err)
+	}
+	return deleted, nil
+}
+
+// GetOldestChatHistoryTime returns the time of the oldest chat history entry, or the zero time
+// when there is none.
+func (db *DB) GetOldestChatHistoryTime() (time.Time, error) {
+	var oldest time.Time
+	err := db.conn.QueryRow("SELECT last_used FROM chat_history ORDER BY last_used ASC LIMIT 1").Scan(&oldest)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, nil
+		}
+		return time.Time{}, WrapError("failed to get oldest chat history entry", err)
+	}
+	return oldest, nil
+}
+
+// GetTableRowCounts returns the number of rows of each table.
+func (db *DB) GetTableRowCounts() (map[string]int64, error) {
+	rows, err := db.reader().Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return nil, WrapError("failed to list tables", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		err := rows.Scan(&table)
+		if err != nil {
+			rows.Close()
+			return nil, WrapError("failed to scan table name", err)
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, WrapError("failed to iterate over tables", err)
+	}
+
+	counts := make(map[string]int64, len(tables))
+	for _, table := range tables {
+		var count int64
+		err := db.reader().QueryRow(`SELECT COUNT(*) FROM "` + table + `"`).Scan(&count)
+		if err != nil {
+			return nil, WrapError(fmt.Sprintf("failed to count rows of %s", table), err)
+		}
+		counts[table] = count
+	}
+	return counts, nil
+}
+
+// GetDatabaseSize returns the size of the database in bytes.
+func (db *DB) GetDatabaseSize() (int64, error) {
+	var pageCount, pageSize int64
+	err := db.conn.QueryRow("SELECT page_count, page_size FROM pragma_page_count(), pragma_page_size()").Scan(&pageCount, &pageSize)
+	if err != nil {
+		return 0, WrapError("failed to get database size", err)
+	}
+	return pageCount * pageSize, nil
+}
+
+// Vacuum rebuilds the database file, returning the space of deleted rows to the filesystem.
+func (db *DB) Vacuum() error {
+	_, err := db.conn.Exec("VACUUM")
+	if err != nil {
+		return WrapError("failed to vacuum database", err)
+	}
+	return nil
+}
+
+// MarkChatBlocked records that the bot is blocked in a chat.
+func (db *DB) MarkChatBlocked(chatID ChatID) error {
+	query := "INSERT OR REPLACE INTO blocked_chat (chat_id, blocked_at) VALUES (?, ?)"
+	_, err := db.conn.Exec(query, chatID, time.Now())
+	if err != nil {
+		return WrapError("failed to mark chat as blocked", err)
+	}
+	return nil
+}
+
+// UnmarkChatBlocked removes the blocked record of a chat.
+func (db *DB) UnmarkChatBlocked(chatID ChatID) error {
+	query := "DELETE FROM blocked_chat WHERE chat_id = ?"
+	_, err := db.conn.Exec(query, chatID)
+	if err != nil {
+		return WrapError("failed to unmark blocked chat", err)
+	}
+	return nil
+}
+
+// IsChatBlocked reports whether the bot is blocked in a chat.
+func (db *DB) IsChatBlocked(chatID ChatID) (bool, error) {
+	var count int
+	query := "SELECT COUNT(*) FROM blocked_chat WHERE chat_id = ?"
+	err := db.conn.QueryRow(query, chatID).Scan(&count)
+	if err != nil {
+		return false, WrapError("failed to check blocked chat", err)
+	}
+	return count > 0, nil
+}
+
+// BlockUser records that the bot must not answer a user in a chat.
+func (db *DB) BlockUser(chatID ChatID, userID UserID) error {
+	query := "INSERT OR REPLACE INTO blocked_user (chat_id, user_id, blocked_at) VALUES (?, ?, ?)"
+	_, err := db.conn.Exec(query, chatID, userID, time.Now())
+	if err != nil {
+		return WrapError("failed to block user", err)
+	}
+	return nil
+}
+
+// UnblockUser removes the block of a user in a chat and reports whether the user was blocked.
+func (db *DB) UnblockUser(chatID ChatID, userID UserID) (bool, error) {
+	query := "DELETE FROM blocked_user WHERE chat_id = ? AND user_id = ?"
+	result, err := db.conn.Exec(query, chatID, userID)
+	if err != nil {
+		return false, WrapError("failed to unblock user", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, WrapError("failed to get affected rows", err)
+	}
+	return affected > 0, nil
+}
+
+// IsUserBlocked reports whether the bot must not answer a user in a chat.
+func (db *DB) IsUserBlocked(chatID ChatID, userID UserID) (bool, error) {
+	var count int
+	query := "SELECT COUNT(*) FROM blocked_user WHERE chat_id = ? AND user_id = ?"
+	err := db.conn.QueryRow(query, chatID, userID).Scan(&count)
+	if err != nil {
+		return false, WrapError("failed to check blocked user", err)
+	}
+	return count > 0, nil
+}
+
+// MigrateChat atomically re-points the stored data of a chat to its new ID after a supergroup
+// migration. Settings and blocks already present for the new ID are kept. It reports whether the migration
+// was new, since Telegram announces it both in the old and in the new chat.
+func (db *DB) MigrateChat(oldChatID, newChatID ChatID) (bool, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return false, WrapError("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	var recorded ChatID
+	err = tx.QueryRow("SELECT new_chat_id FROM chat_migration WHERE old_chat_id = ?", oldChatID).Scan(&recorded)
+	if err != nil && err != sql.ErrNoRows {
+		return false, WrapError("failed to check chat migration", err)
+	}
+	if recorded == newChatID {
+		return false, nil
+	}
+
+	updates := []struct {
+		query string
+		what  string
+	}{
+		{"UPDATE message_ref SET chat_id = ? WHERE chat_id = ?", "message references"},
+		{"UPDATE chat_history SET chat_id = ? WHERE chat_id = ?", "chat history"},
+		{"UPDATE OR IGNORE chat_setting SET chat_id = ? WHERE chat_id = ?", "chat settings"},
+		{"UPDATE OR IGNORE blocked_user SET chat_id = ? WHERE chat_id = ?", "blocked users"},
+		{"UPDATE OR IGNORE user_activity SET chat_id = ? WHERE chat_id = ?", "user activity"},
+		{"UPDATE OR IGNORE chat_memory SET chat_id = ? WHERE chat_id = ?", "chat memory"},
+		{"UPDATE OR IGNORE quote SET chat_id = ? WHERE chat_id = ?", "quotes"},
+		{"UPDATE follow_up SET chat_id = ? WHERE chat_id = ?", "follow-ups"},
+		{"UPDATE chat_rule SET chat_id = ? WHERE chat_id = ?", "chat rules"},
+		{"UPDATE OR IGNORE chat_member SET chat_id = ? WHERE chat_id = ?", "chat members"},
+		{"UPDATE message_embedding SET chat_id = ? WHERE chat_id = ?", "message embeddings"},
+		{"UPDATE ai_refusal SET chat_id = ? WHERE chat_id = ?", "refusals"},
+		{"UPDATE export_audit SET source_chat_id = ? WHERE source_chat_id = ?", "export audit sources"},
+		{"UPDATE export_audit SET dest_chat_id = ? WHERE dest_chat_id = ?", "export audit destinations"},
+	}
+	for _, update := range updates {
+		_, err = tx.Exec(update.query, newChatID, oldChatID)
+		if err != nil {
+			return false, WrapError("failed to migrate "+update.what, err)
+		}
+	}
+	for _, table := range []string{"chat_setting", "blocked_user", "user_activity", "chat_memory", "quote", "chat_member"} {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE chat_id = ?", oldChatID)
+		if err != nil {
+			return false, WrapError("failed to remove leftover rows of "+table, err)
+		}
+	}
+	_, err = tx.Exec("INSERT OR REPLACE INTO chat_migration (old_chat_id, new_chat_id, migrated_at) VALUES (?, ?, ?)", oldChatID, newChatID, time.Now())
+	if err != nil {
+		return false, WrapError("failed to record chat migration", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, WrapError("failed to commit chat migration", err)
+	}
+	return true, nil
+}
+
+// GetStats returns aggregate counters over the stored data.
+func (db *DB) GetStats() (Stats, error) {
+	var stats Stats
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM message_ref),
+			(SELECT COUNT(*) FROM chat_history),
+			(SELECT COUNT(*) FROM blocked_chat),
+			(SELECT COUNT(*) FROM chat_migration),
+			(SELECT COUNT(*) FROM ai_refusal),
+			(SELECT COUNT(*) FROM export_audit),
+			(SELECT COUNT(*) FROM flood_event)`
+	err := db.reader().QueryRow(query).Scan(&stats.MessageRefs, &stats.ChatHistory, &stats.BlockedChats, &stats.MigratedChats, &stats.Refusals, &stats.Exports, &stats.Throttled)
+	if err != nil {
+		return stats, WrapError("failed to get stats", err)
+	}
+	return stats, nil
+}
+
+// GetSetting returns the value stored under a settings key.
+func (db *DB) GetSetting(key string) (string, bool, error) {
+	var value string
+	query := "SELECT value FROM setting WHERE key = ?"
+	err := db.conn.QueryRow(query, key).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, nil
+		}
+		return "", false, WrapError("failed to get setting", err)
+	}
+	return value, true, nil
+}
+
+// SetSetting stores a value under a settings key.
+func (db *DB) SetSetting(key, value string) error {
+	query := "INSERT OR REPLACE INTO setting (key, value) VALUES (?, ?)"
+	_, err := db.conn.Exec(query, key, value)
+	if err != nil {
+		return WrapError("failed to set setting", err)
+	}
+	return nil
+}
+
+// AddRefusal inserts a model refusal into the database.
+func (db *DB) AddRefusal(refusal *Refusal) error {
+	query := "INSERT INTO ai_refusal (chat_id, user_id, prompt_hash, finish_reason, refusal, created_at) VALUES (?, ?, ?, ?, ?, ?)"
+	_, err := db.conn.Exec(query, refusal.ChatID, refusal.UserID, refusal.PromptHash, refusal.FinishReason, refusal.Refusal, refusal.CreatedAt)
+	if err != nil {
+		return WrapError("failed to add refusal", err)
+	}
+	return nil
+}
+
+// GetRecentRefusals retrieves the most recent model refusals, newest first.
+func (db *DB) GetRecentRefusals(limit int) ([]Refusal, error) {
+	query := `
+		SELECT id, chat_id, user_id, prompt_hash, finish_reason, refusal, created_at
+		FROM ai_refusal
+		ORDER BY created_at DESC
+		LIMIT ?`
+	rows, err := db.conn.Query(query, limit)
+	if err != nil {
+		return nil, WrapError("failed to retrieve recent refusals", err)
+	}
+	defer rows.Close()
+
+	var refusals []Refusal
+	for rows.Next() {
+		var refusal Refusal
+		err := rows.Scan(&refusal.ID, &refusal.ChatID, &refusal.UserID, &refusal.PromptHash, &refusal.FinishReason, &refusal.Refusal, &refusal.CreatedAt)
+		if err != nil {
+			return nil, WrapError("failed to scan refusal", err)
+		}
+		refusals = append(refusals, refusal)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return refusals, nil
+}
+
+// GetChatSetting returns the value stored under a settings key for a chat.
+func (db *DB) GetChatSetting(chatID ChatID, key string) (string, bool, error) {
+	var value string
+	query := "SELECT value FROM chat_setting WHERE chat_id = ? AND key = ?"
+	err := db.conn.QueryRow(query, chatID, key).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, nil
+		}
+		return "", false, WrapError("failed to get chat setting", err)
+	}
+	return value, true, nil
+}
+
+// GetChatSettingsByKey returns the values stored under a settings key, by chat.
+func (db *DB) GetChatSettingsByKey(key string) (map[ChatID]string, error) {
+	rows, err := db.conn.Query("SELECT chat_id, value FROM chat_setting WHERE key = ?", key)
+	if err != nil {
+		return nil, WrapError("failed to retrieve chat settings", err)
+	}
+	defer rows.Close()
+
+	settings := make(map[ChatID]string)
+	for rows.Next() {
+		var chatID ChatID
+		var value string
+		err := rows.Scan(&chatID, &value)
+		if err != nil {
+			return nil, WrapError("failed to scan chat setting", err)
+		}
+		settings[chatID] = value
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return settings, nil
+}
+
+// SetChatSetting stores a value under a settings key for a chat.
+func (db *DB) SetChatSetting(chatID ChatID, key, value string) error {
+	query := "INSERT OR REPLACE INTO chat_setting (chat_id, key, value) VALUES (?, ?, ?)"
+	_, err := db.conn.Exec(query, chatID, key, value)
+	if err != nil {
+		return WrapError("failed to set chat setting", err)
+	}
+	return nil
+}
+
+// DeleteChatSetting removes a settings key of a chat.
+func (db *DB) DeleteChatSetting(chatID ChatID, key string) error {
+	query := "DELETE FROM chat_setting WHERE chat_id = ? AND key = ?"
+	_, err := db.conn.Exec(query, chatID, key)
+	if err != nil {
+		return WrapError("failed to delete chat setting", err)
+	}
+	return nil
+}
+
+// AddExportAudit records a stored message forwarded to a chat.
+func (db *DB) AddExportAudit(audit *ExportAudit) error {
+	query := "INSERT INTO export_audit (source_chat_id, message_id, dest_chat_id, user_id, exported_at) VALUES (?, ?, ?, ?, ?)"
+	_, err := db.conn.Exec(query, audit.SourceChatID, audit.MessageID, audit.DestChatID, audit.UserID, audit.ExportedAt)
+	if err != nil {
+		return WrapError("failed to add export audit", err)
+	}
+	return nil
+}
+
+// AddAdminAudit records an admin command invocation.
+func (db *DB) AddAdminAudit(audit *AdminAudit) error {
+	query := "INSERT INTO admin_audit (user_id, user_name, chat_id, command, args, result, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	_, err := db.conn.Exec(query, audit.UserID, audit.UserName, audit.ChatID, audit.Command, audit.Args, audit.Result, audit.CreatedAt)
+	if err != nil {
+		return WrapError("failed to add admin audit", err)
+	}
+	return nil
+}
+
+// GetRecentAdminAudit retrieves the most recent admin command invocations, newest first.
+func (db *DB) GetRecentAdminAudit(limit int) ([]AdminAudit, error) {
+	query := `
+		SELECT id, user_id, user_name, chat_id, command, args, result, created_at
+		FROM admin_audit
+		ORDER BY id DESC
+		LIMIT ?`
+	rows, err := db.conn.Query(query, limit)
+	if err != nil {
+		return nil, WrapError("failed to retrieve admin audit", err)
+	}
+	defer rows.Close()
+
+	var entries []AdminAudit
+	for rows.Next() {
+		var entry AdminAudit
+		err := rows.Scan(&entry.ID, &entry.UserID, &entry.UserName, &entry.ChatID, &entry.Command, &entry.Args, &entry.Result, &entry.CreatedAt)
+		if err != nil {
+			return nil, WrapError("failed to scan admin audit", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return entries, nil
+}
+
+// AddQuote saves a quote, reporting false when the message was already saved.
+func (db *DB) AddQuote(quote *Quote) (bool, error) {
+	query := `
+		INSERT OR IGNORE INTO quote (chat_id, message_id, author_id, author_name, text, saved_by, sent_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
+	result, err := db.conn.Exec(query, quote.ChatID, quote.MessageID, quote.AuthorID, quote.AuthorName, quote.Text, quote.SavedBy, quote.SentAt)
+	if err != nil {
+		return false, WrapError("failed to add quote", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, WrapError("failed to get affected rows", err)
+	}
+	return affected > 0, nil
+}
+
+// GetRandomQuote returns a random quote saved in a chat.
+func (db *DB) GetRandomQuote(chatID ChatID) (Quote, bool, error) {
+	var quote Quote
+	query := `
+		SELECT id, chat_id, message_id, author_id, author_name, text, saved_by, sent_at
+		FROM quote
+		WHERE chat_id = ?
+		ORDER BY RANDOM()
+		LIMIT 1`
+	err := db.conn.QueryRow(query, chatID).Scan(&quote.ID, &quote.ChatID, &quote.MessageID, &quote.AuthorID, &quote.AuthorName, &quote.Text, &quote.SavedBy, &quote.SentAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return quote, false, nil
+		}
+		return quote, false, WrapError("failed to get random quote", err)
+	}
+	return quote, true, nil
+}
+
+// AddFollowUp stores a follow-up.
+func (db *DB) AddFollowUp(followUp *FollowUp) error {
+	query := "INSERT INTO follow_up (chat_id, user_id, message_id, note, due_at, created_at) VALUES (?, ?, ?, ?, ?, ?)"
+	_, err := db.conn.Exec(query, followUp.ChatID, followUp.UserID, followUp.MessageID, followUp.Note, followUp.DueAt, followUp.CreatedAt)
+	if err != nil {
+		return WrapError("failed to add follow-up", err)
+	}
+	return nil
+}
+
+// CountFollowUps returns the number of follow-ups scheduled in a chat.
+func (db *DB) CountFollowUps(chatID ChatID) (int, error) {
+	var count int
+	err := db.conn.QueryRow("SELECT COUNT(*) FROM follow_up WHERE chat_id = ?", chatID).Scan(&count)
+	if err != nil {
+		return 0, WrapError("failed to count follow-ups", err)
+	}
+	return count, nil
+}
+
+// GetFollowUps retrieves the scheduled follow-ups of all chats, soonest first.
+func (db *DB) GetFollowUps() ([]FollowUp, error) {
+	return db.queryFollowUps("SELECT id, chat_id, user_id, message_id, note, due_at, created_at FROM follow_up ORDER BY due_at ASC")
+}
+
+// GetDueFollowUps retrieves the follow-ups due at the given time, soonest first.
+func (db *DB) GetDueFollowUps(now time.Time) ([]FollowUp, error) {
+	return db.queryFollowUps("SELECT id, chat_id, user_id, message_id, note, due_at, created_at FROM follow_up WHERE due_at <= ? ORDER BY due_at ASC", now)
+}
+
+// queryFollowUps runs a query selecting follow-ups.
+func (db *DB) queryFollowUps(query string, args ...interface{}) ([]FollowUp, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, WrapError("failed to retrieve follow-ups", err)
+	}
+	defer rows.Close()
+
+	var followUps []FollowUp
+	for rows.Next() {
+		var followUp FollowUp
+		err := rows.Scan(&followUp.ID, &followUp.ChatID, &followUp.UserID, &followUp.MessageID, &followUp.Note, &followUp.DueAt, &followUp.CreatedAt)
+		if err != nil {
+			return nil, WrapError("failed to scan follow-up", err)
+		}
+		followUps = append(followUps, followUp)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return followUps, nil
+}
+
+// DeleteFollowUp removes a follow-up, reporting whether it existed.
+func (db *DB) DeleteFollowUp(id uint) (bool, error) {
+	result, err := db.conn.Exec("DELETE FROM follow_up WHERE id = ?", id)
+	if err != nil {
+		return false, WrapError("failed to delete follow-up", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, WrapError("failed to get affected rows", err)
+	}
+	return affected > 0, nil
+}
+
+// AddAPIErrors adds Telegram API errors to the counters by kind and stores their samples, keeping
+// the most recent ones of each kind.
+func (db *DB) AddAPIErrors(counts map[string]int64, samples []APIErrorSample, keep int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return WrapError("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	lastSeen := make(map[string]time.Time)
+	for _, sample := range samples {
+		if sample.CreatedAt.After(lastSeen[sample.Kind]) {
+			lastSeen[sample.Kind] = sample.CreatedAt
+		}
+	}
+	for kind, count := range counts {
+		query := `
+			INSERT INTO api_error_count (kind, count, last_seen) VALUES (?, ?, ?)
+			ON CONFLICT (kind) DO UPDATE SET count = count + excluded.count, last_seen = excluded.last_seen`
+		_, err = tx.Exec(query, kind, count, lastSeen[kind])
+		if err != nil {
+			return WrapError("failed to add API error count", err)
+		}
+	}
+	for _, sample := range samples {
+		query := "INSERT INTO api_error_sample (kind, chat_id, description, created_at) VALUES (?, ?, ?, ?)"
+		_, err = tx.Exec(query, sample.Kind, sample.ChatID, sample.Description, sample.CreatedAt)
+		if err != nil {
+			return WrapError("failed to add API error sample", err)
+		}
+	}
+	for kind := range counts {
+		query := `
+			DELETE FROM api_error_sample
+			WHERE kind = ? AND id NOT IN (SELECT id FROM api_error_sample WHERE kind = ? ORDER BY id DESC LIMIT ?)`
+		_, err = tx.Exec(query, kind, kind, keep)
+		if err != nil {
+			return WrapError("failed to trim API error samples", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return WrapError("failed to commit transaction", err)
+	}
+	return nil
+}
+
+// GetAPIErrorCounts returns the Telegram API error counters, most frequent first.
+func (db *DB) GetAPIErrorCounts() ([]APIErrorCount, error) {
+	rows, err := db.conn.Query("SELECT kind, count, last_seen FROM api_error_count ORDER BY count DESC")
+	if err != nil {
+		return nil, WrapError("failed to retrieve API error counts", err)
+	}
+	defer rows.Close()
+
+	var counts []APIErrorCount
+	for rows.Next() {
+		var count APIErrorCount
+		err := rows.Scan(&count.Kind, &count.Count, &count.LastSeen)
+		if err != nil {
+			return nil, WrapError("failed to scan API error count", err)
+		}
+		counts = append(counts, count)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return counts, nil
+}
+
+// GetRecentAPIErrorSamples retrieves the most recent Telegram API errors, newest first.
+func (db *DB) GetRecentAPIErrorSamples(limit int) ([]APIErrorSample, error) {
+	query := "SELECT kind, chat_id, description, created_at FROM api_error_sample ORDER BY id DESC LIMIT ?"
+	rows, err := db.conn.Query(query, limit)
+	if err != nil {
+		return nil, WrapError("failed to retrieve API error samples", err)
+	}
+	defer rows.Close()
+
+	var samples []APIErrorSample
+	for rows.Next() {
+		var sample APIErrorSample
+		err := rows.Scan(&sample.Kind, &sample.ChatID, &sample.Description, &sample.CreatedAt)
+		if err != nil {
+			return nil, WrapError("failed to scan API error sample", err)
+		}
+		samples = append(samples, sample)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return samples, nil
+}
+
+// AddChatRule stores a chat rule and sets its ID.
+func (db *DB) AddChatRule(rule *ChatRule) error {
+	query := "INSERT INTO chat_rule (chat_id, pattern, action, response, created_at) VALUES (?, ?, ?, ?, ?)"
+	result, err := db.conn.Exec(query, rule.ChatID, rule.Pattern, rule.Action, rule.Response, rule.CreatedAt)
+	if err != nil {
+		return WrapError("failed to add chat rule", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return WrapError("failed to get chat rule ID", err)
+	}
+	rule.ID = uint(id)
+	return nil
+}
+
+// GetChatRules retrieves the rules of a chat, oldest first.
+func (db *DB) GetChatRules(chatID ChatID) ([]ChatRule, error) {
+	query := "SELECT id, chat_id, pattern, action, response, created_at FROM chat_rule WHERE chat_id = ? ORDER BY id ASC"
+	rows, err := db.conn.Query(query, chatID)
+	if err != nil {
+		return nil, WrapError("failed to retrieve chat rules", err)
+	}
+	defer rows.Close()
+
+	var rules []ChatRule
+	for rows.Next() {
+		var rule ChatRule
+		err := rows.Scan(&rule.ID, &rule.ChatID, &rule.Pattern, &rule.Action, &rule.Response, &rule.CreatedAt)
+		if err != nil {
+			return nil, WrapError("failed to scan chat rule", err)
+		}
+		rules = append(rules, rule)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return rules, nil
+}
+
+// DeleteChatRule removes a rule of a chat, reporting whether it existed.
+func (db *DB) DeleteChatRule(chatID ChatID, id uint) (bool, error) {
+	result, err := db.conn.Exec("DELETE FROM chat_rule WHERE chat_id = ? AND id = ?", chatID, id)
+	if err != nil {
+		return false, WrapError("failed to delete chat rule", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, WrapError("failed to get affected rows", err)
+	}
+	return affected > 0, nil
+}
+
+// SetChatMemberStatus records whether a user is a member of a chat or left it.
+func (db *DB) SetChatMemberStatus(chatID ChatID, userID UserID, status string, at time.Time) error {
+	query := "INSERT OR REPLACE INTO chat_member (chat_id, user_id, status, updated_at) VALUES (?, ?, ?, ?)"
+	_, err := db.conn.Exec(query, chatID, userID, status, at)
+	if err != nil {
+		return WrapError("failed to set chat member status", err)
+	}
+	return nil
+}
+
+// GetChatsWithActivity returns the chats with user activity recorded since the given time.
+func (db *DB) GetChatsWithActivity(since time.Time) ([]ChatID, error) {
+	rows, err := db.conn.Query("SELECT DISTINCT chat_id FROM user_activity WHERE last_seen >= ?", since)
+	if err != nil {
+		return nil, WrapError("failed to retrieve chats with activity", err)
+	}
+	defer rows.Close()
+
+	var chatIDs []ChatID
+	for rows.Next() {
+		var chatID ChatID
+		err := rows.Scan(&chatID)
+		if err != nil {
+			return nil, WrapError("failed to scan chat ID", err)
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return chatIDs, nil
+}
+
+// CountActiveMembers returns the number of users who wrote in a chat since the given time and
+// have not left it since.
+func (db *DB) CountActiveMembers(chatID ChatID, since time.Time) (int64, error) {
+	query := `
+		SELECT COUNT(DISTINCT a.user_id)
+		FROM user_activity a
+		LEFT JOIN chat_member m ON m.chat_id = a.chat_id AND m.user_id = a.user_id
+		WHERE a.chat_id = ? AND a.last_seen >= ? AND (m.status IS NULL OR m.status != ?)`
+	var count int64
+	err := db.reader().QueryRow(query, chatID, since, MemberStatusLeft).Scan(&count)
+	if err != nil {
+		return 0, WrapError("failed to count active members", err)
+	}
+	return count, nil
+}
+
+// GetChatDigests returns the activity of each chat with chat history since the given time, the
+// busiest chats first.
+func (db *DB) GetChatDigests(since time.Time) ([]ChatDigest, error) {
+	query := `
+		SELECT h.chat_id, COUNT(*), COUNT(DISTINCT h.user_id),
+			(SELECT COUNT(*) FROM ai_refusal r WHERE r.chat_id = h.chat_id AND r.created_at >= ?),
+			(SELECT COUNT(*) FROM flood_event f WHERE f.chat_id = h.chat_id AND f.created_at >= ?)
+		FROM chat_history h
+		WHERE h.last_used >= ?
+		GROUP BY h.chat_id
+		ORDER BY COUNT(*) DESC`
+	rows, err := db.reader().Query(query, since, since, since)
+	if err != nil {
+		return nil, WrapError("failed to retrieve chat digests", err)
+	}
+	defer rows.Close()
+
+	var digests []ChatDigest
+	for rows.Next() {
+		var digest ChatDigest
+		err := rows.Scan(&digest.ChatID, &digest.Messages, &digest.Users, &digest.Refusals, &digest.Throttled)
+		if err != nil {
+			return nil, WrapError("failed to scan chat digest", err)
+		}
+		digests = append(digests, digest)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return digests, nil
+}
+
+// GetBusiestThread returns the message of a chat with the most replies in the chat history since
+// the given time, and the number of replies, zero when nothing was replied to.
+func (db *DB) GetBusiestThread(chatID ChatID, since time.Time) (MessageID, int64, error) {
+	query := `
+		SELECT reply_to_message_id, COUNT(*)
+		FROM chat_history
+		WHERE chat_id = ? AND last_used >= ? AND reply_to_message_id != 0
+		GROUP BY reply_to_message_id
+		ORDER BY COUNT(*) DESC
+		LIMIT 1`
+	var messageID MessageID
+	var replies int64
+	err := db.reader().QueryRow(query, chatID, since).Scan(&messageID, &replies)
+	if err == sql.ErrNoRows {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, WrapError("failed to get busiest thread", err)
+	}
+	return messageID, replies, nil
+}
+
+// GetUserChatHistory retrieves the chat history entries of a user's messages in all chats, oldest
+// first.
+func (db *DB) GetUserChatHistory(userID UserID) ([]ChatHistory, error) {
+	query := `
+		SELECT ` + chatHistoryColumns + `
+		FROM chat_history
+		WHERE user_id = ?
+		ORDER BY last_used ASC`
+	rows, err := db.reader().Query(query, userID)
+	if err != nil {
+		return nil, WrapError("failed to retrieve user chat history", err)
+	}
+	defer rows.Close()
+
+	var history []ChatHistory
+	for rows.Next() {
+		entry, err := scanChatHistory(rows)
+		if err != nil {
+			return nil, WrapError("failed to scan chat history", err)
+		}
+		history = append(history, entry)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return history, nil
+}
+
+// GetUserChats returns the chats with recorded activity of a user.
+func (db *DB) GetUserChats(userID UserID) ([]ChatID, error) {
+	rows, err := db.reader().Query("SELECT DISTINCT chat_id FROM user_activity WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, WrapError("failed to retrieve user chats", err)
+	}
+	defer rows.Close()
+
+	var chatIDs []ChatID
+	for rows.Next() {
+		var chatID ChatID
+		err := rows.Scan(&chatID)
+		if err != nil {
+			return nil, WrapError("failed to scan chat ID", err)
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return chatIDs, nil
+}
+
+// GetUserQuotes retrieves the quotes a user wrote or saved.
+func (db *DB) GetUserQuotes(userID UserID) ([]Quote, error) {
+	query := `
+		SELECT id, chat_id, message_id, author_id, author_name, text, saved_by, sent_at
+		FROM quote
+		WHERE author_id = ? OR saved_by = ?
+		ORDER BY sent_at ASC`
+	rows, err := db.reader().Query(query, userID, userID)
+	if err != nil {
+		return nil, WrapError("failed to retrieve user quotes", err)
+	}
+	defer rows.Close()
+
+	var quotes []Quote
+	for rows.Next() {
+		var quote Quote
+		err := rows.Scan(&quote.ID, &quote.ChatID, &quote.MessageID, &quote.AuthorID, &quote.AuthorName, &quote.Text, &quote.SavedBy, &quote.SentAt)
+		if err != nil {
+			return nil, WrapError("failed to scan quote", err)
+		}
+		quotes = append(quotes, quote)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return quotes, nil
+}
+
+// GetUserFollowUps retrieves the follow-ups scheduled from a user's messages, soonest first.
+func (db *DB) GetUserFollowUps(userID UserID) ([]FollowUp, error) {
+	return db.queryFollowUps("SELECT id, chat_id, user_id, message_id, note, due_at, created_at FROM follow_up WHERE user_id = ? ORDER BY due_at ASC", userID)
+}
+
+// GetUserRefusals retrieves the model refusals of a user's requests, oldest first.
+func (db *DB) GetUserRefusals(userID UserID) ([]Refusal, error) {
+	query := `
+		SELECT id, chat_id, user_id, prompt_hash, finish_reason, refusal, created_at
+		FROM ai_refusal
+		WHERE user_id = ?
+		ORDER BY created_at ASC`
+	rows, err := db.reader().Query(query, userID)
+	if err != nil {
+		return nil, WrapError("failed to retrieve user refusals", err)
+	}
+	defer rows.Close()
+
+	var refusals []Refusal
+	for rows.Next() {
+		var refusal Refusal
+		err := rows.Scan(&refusal.ID, &refusal.ChatID, &refusal.UserID, &refusal.PromptHash, &refusal.FinishReason, &refusal.Refusal, &refusal.CreatedAt)
+		if err != nil {
+			return nil, WrapError("failed to scan refusal", err)
+		}
+		refusals = append(refusals, refusal)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return refusals, nil
+}
+
+// GetUserMembership retrieves the membership state of a user in every chat where it is known.
+func (db *DB) GetUserMembership(userID UserID) ([]UserDataMembership, error) {
+	rows, err := db.reader().Query("SELECT chat_id, status, updated_at FROM chat_member WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, WrapError("failed to retrieve user membership", err)
+	}
+	defer rows.Close()
+
+	var membership []UserDataMembership
+	for rows.Next() {
+		var member UserDataMembership
+		err := rows.Scan(&member.ChatID, &member.Status, &member.UpdatedAt)
+		if err != nil {
+			return nil, WrapError("failed to scan chat member", err)
+		}
+		membership = append(membership, member)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return membership, nil
+}
+
+// EditUserMessage replaces the stored text of an edited user message in the chat history and in
+// the quotes, reporting whether anything was stored for it.
+func (db *DB) EditUserMessage(chatID ChatID, messageID MessageID, text string, editedAt time.Time) (bool, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return false, WrapError("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE chat_history SET user_msg = ?, edited = 1, edited_at = ? WHERE chat_id = ? AND message_id = ?"
+	result, err := tx.Exec(query, text, editedAt, chatID, messageID)
+	if err != nil {
+		return false, WrapError("failed to edit chat history", err)
+	}
+	history, err := result.RowsAffected()
+	if err != nil {
+		return false, WrapError("failed to get affected rows", err)
+	}
+	result, err = tx.Exec("UPDATE quote SET text = ? WHERE chat_id = ? AND message_id = ?", text, chatID, messageID)
+	if err != nil {
+		return false, WrapError("failed to edit quote", err)
+	}
+	quotes, err := result.RowsAffected()
+	if err != nil {
+		return false, WrapError("failed to get affected rows", err)
+	}
+	// The embedding of the earlier text is recomputed by the next indexing run
+	_, err = tx.Exec("DELETE FROM message_embedding WHERE history_id IN (SELECT id FROM chat_history WHERE chat_id = ? AND message_id = ?)", chatID, messageID)
+	if err != nil {
+		return false, WrapError("failed to delete message embedding", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, WrapError("failed to commit message edit", err)
+	}
+	return history+quotes > 0, nil
+}
+
+// GetDriftBaseline returns the baseline of a drift benchmark prompt.
+func (db *DB) GetDriftBaseline(prompt string) (DriftBaseline, bool, error) {
+	baseline := DriftBaseline{Prompt: prompt}
+	var embedding string
+	query := "SELECT model, response, embedding, created_at FROM drift_baseline WHERE prompt = ?"
+	err := db.conn.QueryRow(query, prompt).Scan(&baseline.Model, &baseline.Response, &embedding, &baseline.CreatedAt)
+	if err == sql.ErrNoRows {
+		return DriftBaseline{}, false, nil
+	}
+	if err != nil {
+		return DriftBaseline{}, false, WrapError("failed to get drift baseline", err)
+	}
+	err = json.Unmarshal([]byte(embedding), &baseline.Embedding)
+	if err != nil {
+		return DriftBaseline{}, false, WrapError("failed to decode drift baseline embedding", err)
+	}
+	return baseline, true, nil
+}
+
+// SetDriftBaseline stores the baseline of a drift benchmark prompt, replacing any previous one.
+func (db *DB) SetDriftBaseline(baseline *DriftBaseline) error {
+	embedding, err := json.Marshal(baseline.Embedding)
+	if err != nil {
+		return WrapError("failed to encode drift baseline embedding", err)
+	}
+	query := "INSERT OR REPLACE INTO drift_baseline (prompt, model, response, embedding, created_at) VALUES (?, ?, ?, ?, ?)"
+	_, err = db.conn.Exec(query, baseline.Prompt, baseline.Model, baseline.Response, string(embedding), baseline.CreatedAt)
+	if err != nil {
+		return WrapError("failed to set drift baseline", err)
+	}
+	return nil
+}
+
+// DeleteDriftBaselines deletes all drift baselines and returns how many were deleted.
+func (db *DB) DeleteDriftBaselines() (int64, error) {
+	result, err := db.conn.Exec("DELETE FROM drift_baseline")
+	if err != nil {
+		return 0, WrapError("failed to delete drift baselines", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, WrapError("failed to get affected rows", err)
+	}
+	return deleted, nil
+}
+
+// AddFloodEvent records a request throttled as a flood.
+func (db *DB) AddFloodEvent(chatID ChatID, userID UserID, reason string) error {
+	query := "INSERT INTO flood_event (chat_id, user_id, reason, created_at) VALUES (?, ?, ?, ?)"
+	_, err := db.conn.Exec(query, chatID, userID, reason, time.Now())
+	if err != nil {
+		return WrapError("failed to add flood event", err)
+	}
+	return nil
+}
+
+// RecordUserActivity counts a message of a user in a chat towards the ISO week it was sent in.
+func (db *DB) RecordUserActivity(chatID ChatID, userID UserID, at time.Time) error {
+	query := `
+		INSERT INTO user_activity (chat_id, user_id, week, messages, first_seen, last_seen)
+		VALUES (?, ?, ?, 1, ?, ?)
+		ON CONFLICT (chat_id, user_id, week) DO UPDATE SET messages = messages + 1, last_seen = excluded.last_seen`
+	_, err := db.conn.Exec(query, chatID, userID, activityWeek(at), at, at)
+	if err != nil {
+		return WrapError("failed to record user activity", err)
+	}
+	return nil
+}
+
+// GetUserActivity returns the recorded activity of a user in a chat. It reports false when no
+// activity was recorded.
+func (db *DB) GetUserActivity(chatID ChatID, userID UserID) (UserActivity, bool, error) {
+	activity := UserActivity{Weeks: make(map[string]int)}
+	query := "SELECT first_seen FROM user_activity WHERE chat_id = ? AND user_id = ? ORDER BY first_seen ASC LIMIT 1"
+	err := db.conn.QueryRow(query, chatID, userID).Scan(&activity.FirstSeen)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return activity, false, nil
+		}
+		return activity, false, WrapError("failed to get first activity", err)
+	}
+	query = "SELECT last_seen FROM user_activity WHERE chat_id = ? AND user_id = ? ORDER BY last_seen DESC LIMIT 1"
+	err = db.conn.QueryRow(query, chatID, userID).Scan(&activity.LastSeen)
+	if err != nil {
+		return activity, false, WrapError("failed to get last activity", err)
+	}
+
+	rows, err := db.conn.Query("SELECT week, messages FROM user_activity WHERE chat_id = ? AND user_id = ?", chatID, userID)
+	if err != nil {
+		return activity, false, WrapError("failed to get weekly activity", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var week string
+		var messages int
+		err := rows.Scan(&week, &messages)
+		if err != nil {
+			return activity, false, WrapError("failed to scan weekly activity", err)
+		}
+		activity.Weeks[week] = messages
+		activity.Messages += int64(messages)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return activity, false, WrapError("rows iteration error", err)
+	}
+	return activity, true, nil
+}
+
+// GetChatMemory returns the memory entries of a chat, oldest first.
+func (db *DB) GetChatMemory(chatID ChatID) ([]ChatMemoryEntry, error) {
+	rows, err := db.conn.Query("SELECT key, value, updated_at FROM chat_memory WHERE chat_id = ? ORDER BY updated_at ASC", chatID)
+	if err != nil {
+		return nil, WrapError("failed to get chat memory", err)
+	}
+	defer rows.Close()
+
+	var entries []ChatMemoryEntry
+	for rows.Next() {
+		var entry ChatMemoryEntry
+		err := rows.Scan(&entry.Key, &entry.Value, &entry.UpdatedAt)
+		if err != nil {
+			return nil, WrapError("failed to scan chat memory entry", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return entries, nil
+}
+
+// SetChatMemory stores a memory entry of a chat, replacing the entry with the same key.
+func (db *DB) SetChatMemory(chatID ChatID, key, value string) error {
+	query := "INSERT OR REPLACE INTO chat_memory (chat_id, key, value, updated_at) VALUES (?, ?, ?, ?)"
+	_, err := db.conn.Exec(query, chatID, key, value, time.Now())
+	if err != nil {
+		return WrapError("failed to set chat memory", err)
+	}
+	return nil
+}
+
+// DeleteChatMemory deletes a memory entry of a chat. It reports whether the entry existed.
+func (db *DB) DeleteChatMemory(chatID ChatID, key string) (bool, error) {
+	result, err := db.conn.Exec("DELETE FROM chat_memory WHERE chat_id = ? AND key = ?", chatID, key)
+	if err != nil {
+		return false, WrapError("failed to delete chat memory", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, WrapError("failed to get affected rows", err)
+	}
+	return affected > 0, nil
+}
+
+// ClearChatMemory deletes all memory entries of a chat.
+func (db *DB) ClearChatMemory(chatID ChatID) error {
+	_, err := db.conn.Exec("DELETE FROM chat_memory WHERE chat_id = ?", chatID)
+	if err != nil {
+		return WrapError("failed to clear chat memory", err)
+	}
+	return nil
+}
+
+// ReplaceChatConfig replaces the given settings keys and the memory of a chat in one transaction.
+// Keys missing from settings are deleted.
+func (db *DB) ReplaceChatConfig(chatID ChatID, keys []string, settings, memory map[string]string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return WrapError("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	for _, key := range keys {
+		value, ok := settings[key]
+		if ok {
+			_, err = tx.Exec("INSERT OR REPLACE INTO chat_setting (chat_id, key, value) VALUES (?, ?, ?)", chatID, key, value)
+		} else {
+			_, err = tx.Exec("DELETE FROM chat_setting WHERE chat_id = ? AND key = ?", chatID, key)
+		}
+		if err != nil {
+			return WrapError("failed to replace chat setting", err)
+		}
+	}
+	_, err = tx.Exec("DELETE FROM chat_memory WHERE chat_id = ?", chatID)
+	if err != nil {
+		return WrapError("failed to clear chat memory", err)
+	}
+	for key, value := range memory {
+		_, err = tx.Exec("INSERT INTO chat_memory (chat_id, key, value, updated_at) VALUES (?, ?, ?, ?)", chatID, key, value, time.Now())
+		if err != nil {
+			return WrapError("failed to add chat memory", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return WrapError("failed to commit chat configuration", err)
+	}
+	return nil
+}
+
+// GetUnembeddedChatHistory retrieves the newest chat history entries without an embedding from
+// the given model, skipping retracted replies and entries without text.
+func (db *DB) GetUnembeddedChatHistory(model string, limit int) ([]ChatHistory, error) {
+	query := `
+		SELECT ` + chatHistoryColumns + `
+		FROM chat_history
+		WHERE retracted = 0 AND (user_msg != '' OR bot_msg != '')
+			AND id NOT IN (SELECT history_id FROM message_embedding WHERE model = ?)
+		ORDER BY last_used DESC
+		LIMIT ?`
+	rows, err := db.conn.Query(query, model, limit)
+	if err != nil {
+		return nil, WrapError("failed to retrieve unembedded chat history", err)
+	}
+	defer rows.Close()
+
+	var history []ChatHistory
+	for rows.Next() {
+		entry, err := scanChatHistory(rows)
+		if err != nil {
+			return nil, WrapError("failed to scan chat history", err)
+		}
+		history = append(history, entry)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return history, nil
+}
+
+// SetMessageEmbedding stores the embedding of a chat history entry, replacing any earlier one.
+func (db *DB) SetMessageEmbedding(embedding MessageEmbedding) error {
+	encoded, err := json.Marshal(embedding.Embedding)
+	if err != nil {
+		return WrapError("failed to encode message embedding", err)
+	}
+	query := "INSERT OR REPLACE INTO message_embedding (history_id, chat_id, model, embedding, created_at) VALUES (?, ?, ?, ?, ?)"
+	_, err = db.conn.Exec(query, embedding.HistoryID, embedding.ChatID, embedding.Model, string(encoded), time.Now())
+	if err != nil {
+		return WrapError("failed to set message embedding", err)
+	}
+	return nil
+}
+
+// GetMessageEmbeddings retrieves the embeddings from the given model of the newest chat history
+// entries of a chat stored since one time and before another, skipping retracted replies.
+func (db *DB) GetMessageEmbeddings(chatID ChatID, model string, since, before time.Time, limit int) ([]MessageEmbedding, error) {
+	query := `
+		SELECT e.history_id, e.embedding
+		FROM message_embedding e
+		JOIN chat_history h ON h.id = e.history_id
+		WHERE e.chat_id = ? AND e.model = ? AND h.retracted = 0 AND h.last_used >= ? AND h.last_used < ?
+		ORDER BY h.last_used DESC
+		LIMIT ?`
+	rows, err := db.reader().Query(query, chatID, model, since, before, limit)
+	if err != nil {
+		return nil, WrapError("failed to retrieve message embeddings", err)
+	}
+	defer rows.Close()
+
+	var embeddings []MessageEmbedding
+	for rows.Next() {
+		embedding := MessageEmbedding{ChatID: chatID, Model: model}
+		var encoded string
+		err := rows.Scan(&embedding.HistoryID, &encoded)
+		if err != nil {
+			return nil, WrapError("failed to scan message embedding", err)
+		}
+		err = json.Unmarshal([]byte(encoded), &embedding.Embedding)
+		if err != nil {
+			return nil, WrapError("failed to decode message embedding", err)
+		}
+		embeddings = append(embeddings, embedding)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return embeddings, nil
+}
+
+// DeleteOrphanedEmbeddings deletes the embeddings of chat history entries that no longer exist.
+func (db *DB) DeleteOrphanedEmbeddings() (int64, error) {
+	result, err := db.conn.Exec("DELETE FROM message_embedding WHERE history_id NOT IN (SELECT id FROM chat_history)")
+	if err != nil {
+		return 0, WrapError("failed to delete orphaned embeddings", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, WrapError("failed to get affected rows", err)
+	}
+	return affected, nil
+}
+
+// GetChatHistoryByIDs retrieves the chat history entries with the given IDs, oldest first.
+func (db *DB) GetChatHistoryByIDs(ids []uint) ([]ChatHistory, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	query := `
+		SELECT ` + chatHistoryColumns + `
+		FROM chat_history
+		WHERE id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)
+		ORDER BY last_used ASC`
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, WrapError("failed to retrieve chat history by IDs", err)
+	}
+	defer rows.Close()
+
+	var history []ChatHistory
+	for rows.Next() {
+		entry, err := scanChatHistory(rows)
+		if err != nil {
+			return nil, WrapError("failed to scan chat history", err)
+		}
+		history = append(history, entry)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return history, nil
+}
+
+// Ping checks that the database answers queries.
+func (db *DB) Ping() error {
+	_, err := db.conn.Exec("SELECT 1")
+	if err != nil {
+		return WrapError("failed to query database", err)
+	}
+	return nil
+}
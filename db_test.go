@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestDB opens a database in a temporary directory, with every migration applied.
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewDB(&Config{DBName: t.TempDir() + "/storage.db"})
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// addTestHistory stores a chat history entry of a chat at the given time.
+func addTestHistory(t *testing.T, db *DB, chatID ChatID, message string, at time.Time) {
+	t.Helper()
+	err := db.AddChatHistory(&ChatHistory{ChatID: chatID, UserID: 1, UserName: "user", UserMsg: message, BotMsg: "reply", LastUsed: at})
+	if err != nil {
+		t.Fatalf("AddChatHistory: %v", err)
+	}
+}
+
+func TestGetChatHistoryInTimeRange(t *testing.T) {
+	db := newTestDB(t)
+	from := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+
+	addTestHistory(t, db, 1, "before", from.Add(-time.Second))
+	addTestHistory(t, db, 1, "at start", from)
+	addTestHistory(t, db, 1, "inside", from.Add(time.Hour))
+	addTestHistory(t, db, 2, "other chat", from.Add(time.Hour))
+	addTestHistory(t, db, 1, "at end", to)
+
+	history, err := db.GetChatHistoryInTimeRange(1, from, to)
+	if err != nil {
+		t.Fatalf("GetChatHistoryInTimeRange: %v", err)
+	}
+	var got []string
+	for _, entry := range history {
+		got = append(got, entry.UserMsg)
+	}
+	want := []string{"at start", "inside"}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestGetChatHistoryInTimeRangeSkipsRetracted(t *testing.T) {
+	db := newTestDB(t)
+	from := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	addTestHistory(t, db, 1, "kept", from.Add(time.Minute))
+	addTestHistory(t, db, 1, "retracted", from.Add(2*time.Minute))
+	history, err := db.GetChatHistoryInTimeRange(1, from, from.Add(time.Hour))
+	if err != nil || len(history) != 2 {
+		t.Fatalf("got %d entries, err %v, want 2", len(history), err)
+	}
+	err = db.RetractChatHistory(history[1].ID)
+	if err != nil {
+		t.Fatalf("RetractChatHistory: %v", err)
+	}
+
+	history, err = db.GetChatHistoryInTimeRange(1, from, from.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetChatHistoryInTimeRange: %v", err)
+	}
+	if len(history) != 1 || history[0].UserMsg != "kept" {
+		t.Fatalf("got %+v, want only the kept entry", history)
+	}
+}
+
+func TestGetChatHistoryInTimeRangeRejectsInvalidRanges(t *testing.T) {
+	db := newTestDB(t)
+	from := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		to   time.Time
+	}{
+		{"empty", from},
+		{"reversed", from.Add(-time.Hour)},
+		{"too long", from.Add(maxHistoryTimeRange + time.Second)},
+	}
+	for _, tt := range tests {
+		_, err := db.GetChatHistoryInTimeRange(1, from, tt.to)
+		if err == nil {
+			t.Errorf("%s range: expected an error", tt.name)
+		}
+	}
+
+	_, err := db.GetChatHistoryInTimeRange(1, from, from.Add(maxHistoryTimeRange))
+	if err != nil {
+		t.Errorf("range of maxHistoryTimeRange: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// inFlightKey identifies the requests of a user in a chat.
+type inFlightKey struct {
+	chatID ChatID
+	userID UserID
+}
+
+// inFlightRequest is the latest request of a user in a chat.
+type inFlightRequest struct {
+	text     string    // Normalized text of the request
+	notified bool      // Whether a repeat was told the request is being answered
+	done     bool      // Whether the reply was sent
+	content  string    // Reply sent, once done
+	doneAt   time.Time // Time the reply was sent
+}
+
+// InFlightTracker tracks the latest request of each user per chat, so repeated requests can be
+// answered without generating the reply again.
+type InFlightTracker struct {
+	mu       sync.Mutex
+	requests map[inFlightKey]*inFlightRequest
+}
+
+// NewInFlightTracker creates a new request tracker.
+func NewInFlightTracker() *InFlightTracker {
+	return &InFlightTracker{requests: make(map[inFlightKey]*inFlightRequest)}
+}
+
+// begin starts tracking a request. When it repeats a request still being answered, or one
+// answered within the window, a copy of that request is returned as the duplicate instead, and a
+// request still being answered is marked as notified.
+func (tracker *InFlightTracker) begin(key inFlightKey, text string, window time.Duration) (*inFlightRequest, *inFlightRequest) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	normalized := normalizeFloodText(text)
+	previous, ok := tracker.requests[key]
+	if ok && normalized != "" && previous.text == normalized && (!previous.done || time.Since(previous.doneAt) < window) {
+		duplicate := *previous
+		if !previous.done {
+			previous.notified = true
+		}
+		return nil, &duplicate
+	}
+	request := &inFlightRequest{text: normalized}
+	tracker.requests[key] = request
+	return request, nil
+}
+
+// finish records the reply sent to a request. An empty reply means none was sent, and the
+// request stops being tracked.
+func (tracker *InFlightTracker) finish(key inFlightKey, request *inFlightRequest, content string) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if tracker.requests[key] != request {
+		return
+	}
+	if content == "" {
+		delete(tracker.requests, key)
+		return
+	}
+	request.done, request.content, request.doneAt = true, content, time.Now()
+}
+
+// answerDuplicate answers a repeated request: with a notice while the original is still being
+// answered, once per request, and with the reply already sent otherwise.
+func (tg *Telegram) answerDuplicate(ctx *ext.Context, duplicate *inFlightRequest) error {
+	log.Info().Int64("chat_id", ctx.EffectiveMessage.Chat.Id).Int64("user_id", ctx.EffectiveMessage.From.Id).Bool("done", duplicate.done).Msg("Short-circuited repeated request")
+	if !duplicate.done {
+		if duplicate.notified {
+			return nil
+		}
+		return tg.sendTelegramMessage(ctx, "Já estou respondendo, um momento.")
+	}
+	_, err := tg.replyTelegramMessage(ctx, duplicate.content)
+	if err != nil {
+		return WrapError("failed to resend reply", err)
+	}
+	return nil
+}
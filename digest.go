@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// chatDigestSetting is the chat setting excluding a chat from the admin digest when set to "off".
+const chatDigestSetting = "digest"
+
+// digestSentSetting is the settings key holding the day the last admin digest was sent.
+const digestSentSetting = "digest_sent_day"
+
+// digestThreadLength is the maximum number of characters of a thread shown in the digest.
+const digestThreadLength = 80
+
+// digestChatsLength is the maximum length of the chats part of the digest, leaving room for the
+// error and budget status within Telegram's message limit.
+const digestChatsLength = 3000
+
+// ChatDigest holds the activity of a chat over the digest period.
+type ChatDigest struct {
+	ChatID    ChatID // Chat the activity belongs to
+	Messages  int64  // Number of stored chat history entries
+	Users     int64  // Number of distinct users in the chat history
+	Refusals  int64  // Number of model refusals
+	Throttled int64  // Number of requests throttled as floods
+}
+
+// usage returns the tokens spent on the current day and the daily limit.
+func (budget *TokenBudget) usage() (int, int) {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	if budget.day != time.Now().Format(time.DateOnly) {
+		return 0, budget.limit
+	}
+	return budget.used, budget.limit
+}
+
+// busiestThread describes the message with the most replies in a chat since the given time, or
+// returns an empty string when nothing was replied to.
+func (tg *Telegram) busiestThread(chatID ChatID, since time.Time) (string, error) {
+	messageID, replies, err := tg.db.GetBusiestThread(chatID, since)
+	if err != nil {
+		return "", WrapError("failed to get busiest thread", err)
+	}
+	if replies == 0 {
+		return "", nil
+	}
+	text := fmt.Sprintf("message %d", messageID)
+	entry, ok, err := tg.db.GetChatHistoryByMessage(chatID, messageID)
+	if err != nil {
+		return "", WrapError("failed to get thread message", err)
+	}
+	if ok {
+		text = entry.UserMsg
+		if entry.BotMessageID == messageID {
+			text = entry.BotMsg
+		}
+		text = strings.Join(strings.Fields(text), " ")
+		if runes := []rune(text); len(runes) > digestThreadLength {
+			text = string(runes[:digestThreadLength]) + "…"
+		}
+		text = strconv.Quote(text)
+	}
+	return fmt.Sprintf("%s (%d replies)", text, replies), nil
+}
+
+// adminDigest summarizes the activity of the chats that did not opt out of the digest over the
+// last day, along with the Telegram API errors and the critique budget.
+func (tg *Telegram) adminDigest() (string, error) {
+	since := time.Now().Add(-24 * time.Hour)
+	digests, err := tg.db.GetChatDigests(since)
+	if err != nil {
+		return "", WrapError("failed to get chat digests", err)
+	}
+	optOuts, err := tg.db.GetChatSettingsByKey(chatDigestSetting)
+	if err != nil {
+		return "", WrapError("failed to get digest opt-outs", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Daily digest")
+	var total int64
+	for _, digest := range digests {
+		if optOuts[digest.ChatID] == "off" {
+			continue
+		}
+		total += digest.Messages
+		thread, err := tg.busiestThread(digest.ChatID, since)
+		if err != nil {
+			return "", err
+		}
+		line := fmt.Sprintf("\n\nChat %d: %d messages from %d users", digest.ChatID, digest.Messages, digest.Users)
+		if digest.Refusals > 0 || digest.Throttled > 0 {
+			line += fmt.Sprintf(", %d refusals, %d throttled", digest.Refusals, digest.Throttled)
+		}
+		if thread != "" {
+			line += "\nBusiest thread: " + thread
+		}
+		if sb.Len()+len(line) > digestChatsLength {
+			sb.WriteString("\n\nMore chats left out.")
+			break
+		}
+		sb.WriteString(line)
+	}
+	if total == 0 {
+		sb.WriteString("\n\nNo activity in the last day.")
+	}
+
+	apiErrors, err := tg.apiErrorSummary()
+	if err != nil {
+		return "", WrapError("failed to get API error summary", err)
+	}
+	sb.WriteString("\n\n" + apiErrors)
+	used, limit := tg.critique.usage()
+	if limit > 0 {
+		fmt.Fprintf(&sb, "\nCritique budget: %d of %d tokens used today", used, limit)
+	} else {
+		fmt.Fprintf(&sb, "\nCritique budget: %d tokens used today, unlimited", used)
+	}
+	text := sb.String()
+	if runes := []rune(text); len(runes) > listMessageLength {
+		text = string(runes[:listMessageLength])
+	}
+	return text, nil
+}
+
+// sendAdminDigest sends the digest to the admin's private chat.
+func (tg *Telegram) sendAdminDigest() error {
+	text, err := tg.adminDigest()
+	if err != nil {
+		return err
+	}
+	_, err = tg.newMessage(ChatID(tg.config.TelegramAdminUID), text).Send()
+	if err != nil {
+		return WrapError("failed to send admin digest", err)
+	}
+	return nil
+}
+
+// runAdminDigest sends the digest once a day, after the configured hour, until the jobs are
+// stopped. The day of the last digest is stored so restarts do not send it twice.
+func (tg *Telegram) runAdminDigest() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		day := now.Format(time.DateOnly)
+		sent, _, err := tg.db.GetSetting(digestSentSetting)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get last digest day")
+		} else if now.Hour() >= tg.config.AdminDigestHour && sent != day {
+			err = tg.sendAdminDigest()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to send admin digest")
+			} else {
+				log.Info().Str("day", day).Msg("Sent admin digest")
+				tg.analytics.Record(AnalyticsJobRun, 0, 0, map[string]interface{}{"job": "admin_digest"})
+				err = tg.db.SetSetting(digestSentSetting, day)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to store last digest day")
+				}
+			}
+		}
+		select {
+		case <-tg.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleMrlDigestRequest processes the /mrl_digest command, which includes the chat in the admin
+// digest or excludes it, or with "now" sends the digest right away.
+func (tg *Telegram) handleMrlDigestRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_DIGEST request")
+
+	usage := "/mrl_digest [on|off|now]"
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 1)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	switch args.Arg(0) {
+	case "":
+		value, _, err := tg.db.GetChatSetting(chatID, chatDigestSetting)
+		if err != nil {
+			return WrapError("failed to get digest setting", err)
+		}
+		if value == "off" {
+			return tg.sendTelegramMessage(ctx, "This chat is left out of the admin digest.")
+		}
+		return tg.sendTelegramMessage(ctx, "This chat is included in the admin digest.")
+	case "on":
+		err = tg.db.DeleteChatSetting(chatID, chatDigestSetting)
+		if err != nil {
+			return WrapError("failed to delete digest setting", err)
+		}
+		return tg.sendTelegramMessage(ctx, "This chat is now included in the admin digest.")
+	case "off":
+		err = tg.db.SetChatSetting(chatID, chatDigestSetting, "off")
+		if err != nil {
+			return WrapError("failed to set digest setting", err)
+		}
+		return tg.sendTelegramMessage(ctx, "This chat is now left out of the admin digest.")
+	case "now":
+		err = tg.sendAdminDigest()
+		if err != nil {
+			return err
+		}
+		if chatID != ChatID(tg.config.TelegramAdminUID) {
+			return tg.sendTelegramMessage(ctx, "Digest sent.")
+		}
+		return nil
+	}
+	return tg.sendUsage(ctx, usage, nil)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// DriftBaseline is the reference answer to a benchmark prompt.
+type DriftBaseline struct {
+	Prompt    string    // Benchmark prompt
+	Model     string    // Model that gave the answer
+	Response  string    // Answer to the prompt
+	Embedding []float64 // Embedding of the answer
+	CreatedAt time.Time // When the baseline was stored
+}
+
+// driftResult is the outcome of a benchmark prompt in a drift check.
+type driftResult struct {
+	Prompt        string  // Benchmark prompt
+	BaselineModel string  // Model of the baseline, empty when the baseline was just stored
+	Similarity    float64 // Similarity between the answer and the baseline
+}
+
+// cosineSimilarity returns the cosine similarity of two vectors, zero when they cannot be compared.
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// driftPrompts reads the benchmark prompts, one per line, from the configured file.
+func (tg *Telegram) driftPrompts() ([]string, error) {
+	data, err := os.ReadFile(tg.config.DriftPromptsFile)
+	if err != nil {
+		return nil, WrapError("failed to read drift prompts", err)
+	}
+	var prompts []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			prompts = append(prompts, line)
+		}
+	}
+	return prompts, nil
+}
+
+// checkDrift answers the benchmark prompts with the current instruction and default model, and
+// compares the answers with the stored baselines. Prompts without a baseline get the answer as
+// their baseline.
+func (tg *Telegram) checkDrift() ([]driftResult, error) {
+	if tg.oai == nil {
+		return nil, WrapError("OpenAI client not configured")
+	}
+	prompts, err := tg.driftPrompts()
+	if err != nil {
+		return nil, err
+	}
+	if len(prompts) == 0 {
+		return nil, nil
+	}
+
+	// The lowest temperature keeps the answers as stable as possible between checks
+	temperature := float32(0)
+	model := tg.config.OpenAIModel
+	responses := make([]string, 0, len(prompts))
+	for _, prompt := range prompts {
+		messages := []map[string]string{
+			{"role": "system", "content": tg.config.OpenAIInstruction},
+			{"role": "user", "content": prompt},
+		}
+		response, err := tg.oai.CallWithOptions(messages, CallOptions{Temperature: &temperature, Model: model})
+		if err != nil {
+			return nil, WrapError("failed to answer drift prompt", err)
+		}
+		responses = append(responses, response)
+	}
+	embeddings, err := tg.oai.Embed(responses)
+	if err != nil {
+		return nil, WrapError("failed to embed drift responses", err)
+	}
+
+	results := make([]driftResult, 0, len(prompts))
+	for i, prompt := range prompts {
+		baseline, ok, err := tg.db.GetDriftBaseline(prompt)
+		if err != nil {
+			return nil, WrapError("failed to get drift baseline", err)
+		}
+		if !ok {
+			err = tg.db.SetDriftBaseline(&DriftBaseline{
+				Prompt:    prompt,
+				Model:     model,
+				Response:  responses[i],
+				Embedding: embeddings[i],
+				CreatedAt: time.Now(),
+			})
+			if err != nil {
+				return nil, WrapError("failed to store drift baseline", err)
+			}
+			results = append(results, driftResult{Prompt: prompt, Similarity: 1})
+			continue
+		}
+		results = append(results, driftResult{
+			Prompt:        prompt,
+			BaselineModel: baseline.Model,
+			Similarity:    cosineSimilarity(baseline.Embedding, embeddings[i]),
+		})
+	}
+	return results, nil
+}
+
+// driftReport describes the results of a drift check, only listing the drifted prompts unless all
+// is set.
+func (tg *Telegram) driftReport(results []driftResult, all bool) string {
+	var sb strings.Builder
+	for _, result := range results {
+		drifted := result.Similarity < tg.config.DriftThreshold
+		if !drifted && !all {
+			continue
+		}
+		switch {
+		case result.BaselineModel == "":
+			fmt.Fprintf(&sb, "\n- %q: baseline stored", result.Prompt)
+		case result.BaselineModel != tg.config.OpenAIModel:
+			fmt.Fprintf(&sb, "\n- %q: similarity %.2f (baseline from %s)", result.Prompt, result.Similarity, result.BaselineModel)
+		default:
+			fmt.Fprintf(&sb, "\n- %q: similarity %.2f", result.Prompt, result.Similarity)
+		}
+		if drifted {
+			sb.WriteString(" [drifted]")
+		}
+	}
+	return sb.String()
+}
+
+// runDriftCheck checks the bot's behavior for drift at startup and then once per check interval,
+// alerting the admin when answers drift from their baselines, until the jobs are stopped.
+func (tg *Telegram) runDriftCheck() {
+	ticker := time.NewTicker(time.Duration(tg.config.DriftCheckInterval * float64(time.Minute)))
+	defer ticker.Stop()
+	for {
+		results, err := tg.checkDrift()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to check behavior drift")
+		} else {
+			report := tg.driftReport(results, false)
+			log.Info().Int("prompts", len(results)).Bool("drifted", report != "").Msg("Checked behavior drift")
+			tg.analytics.Record(AnalyticsJobRun, 0, 0, map[string]interface{}{"job": "drift_check", "prompts": len(results), "drifted": report != ""})
+			if report != "" {
+				err = tg.notifyAdmin(fmt.Sprintf("The bot's answers drifted from their baselines with %s:%s", tg.config.OpenAIModel, report))
+				if err != nil {
+					log.Warn().Err(err).Msg("Failed to announce behavior drift")
+				}
+			}
+		}
+		select {
+		case <-tg.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleMrlDriftRequest processes the /mrl_drift command, which runs a drift check and reports the
+// similarity of every benchmark prompt, or with "reset" deletes the baselines so the next check
+// stores new ones.
+func (tg *Telegram) handleMrlDriftRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_DRIFT request")
+
+	usage := "/mrl_drift [reset]"
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 1)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+	if tg.config.DriftPromptsFile == "" {
+		return tg.sendTelegramMessage(ctx, "Drift detection is not configured.")
+	}
+
+	switch args.Arg(0) {
+	case "":
+	case "reset":
+		deleted, err := tg.db.DeleteDriftBaselines()
+		if err != nil {
+			return WrapError("failed to delete drift baselines", err)
+		}
+		return tg.sendTelegramMessage(ctx, fmt.Sprintf("Deleted %d drift baselines.", deleted))
+	default:
+		return tg.sendUsage(ctx, usage, nil)
+	}
+
+	results, err := tg.checkDrift()
+	if err != nil {
+		return WrapError("failed to check behavior drift", err)
+	}
+	if len(results) == 0 {
+		return tg.sendTelegramMessage(ctx, "No drift prompts configured.")
+	}
+	return tg.sendTelegramMessage(ctx, fmt.Sprintf("Drift check with %s (threshold %.2f):%s", tg.config.OpenAIModel, tg.config.DriftThreshold, tg.driftReport(results, true)))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// isEditedMessage reports whether a message is an edit of a text or captioned message. Voice
+// messages are left out, since their stored text is a transcript and not their caption.
+func isEditedMessage(msg *gotgbot.Message) bool {
+	return msg.EditDate != 0 && msg.Voice == nil && (msg.Text != "" || msg.Caption != "")
+}
+
+// handleEditedMessage replaces the stored text of an edited message, so later prompts and quotes
+// use its latest version.
+func (tg *Telegram) handleEditedMessage(b *gotgbot.Bot, ctx *ext.Context) error {
+	msg := ctx.EffectiveMessage
+	text := msg.Text
+	if text == "" {
+		text = msg.Caption
+	}
+	if strings.HasPrefix(text, "/") {
+		text = commandText(text)
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+
+	chatID, messageID := ChatID(msg.Chat.Id), MessageID(msg.MessageId)
+	edited, err := tg.db.EditUserMessage(chatID, messageID, text, time.Unix(msg.EditDate, 0))
+	if err != nil {
+		return WrapError("failed to store edited message", err)
+	}
+	if edited {
+		log.Info().Int64("chat_id", int64(chatID)).Int64("message_id", int64(messageID)).Msg("Stored edited message")
+	}
+	return nil
+}
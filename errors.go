@@ -19,6 +19,11 @@ func (fle *FileLineError) Error() string {
 	return fmt.Sprintf("[%s:%d] %v", fle.File, fle.Line, fle.Err)
 }
 
+// Unwrap returns the wrapped error, so errors.Is and errors.As can inspect it.
+func (fle *FileLineError) Unwrap() error {
+	return fle.Err
+}
+
 // WrapError wraps an error with the file name and line number where it occurred, and a custom message.
 func WrapError(message string, err ...error) error {
 	var originalErr error
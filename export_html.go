@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strconv"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultExportDays is the number of days exported when /mrl_export_html gets no argument.
+const defaultExportDays = 7
+
+// chatExportTemplate renders chat history as a self-contained HTML page.
+var chatExportTemplate = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html lang="pt">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { background: #e6ebee; font-family: -apple-system, "Segoe UI", Roboto, sans-serif; margin: 0; padding: 16px; }
+h1 { font-size: 18px; text-align: center; color: #555; }
+.day { text-align: center; color: #fff; background: #8a9ba8; border-radius: 12px; width: fit-content; margin: 16px auto; padding: 2px 12px; font-size: 13px; }
+.msg { max-width: 70%; margin: 6px 0; padding: 8px 12px; border-radius: 12px; background: #fff; white-space: pre-wrap; word-wrap: break-word; }
+.bot { margin-left: auto; background: #effdde; }
+.name { font-weight: bold; color: #3a6d99; font-size: 13px; }
+.time { color: #999; font-size: 11px; text-align: right; }
+.empty { color: #999; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Entries}}{{if .Day}}<div class="day">{{.Day}}</div>
+{{end}}<div class="msg"><div class="name">{{.UserName}}</div>{{if .UserMsg}}{{.UserMsg}}{{else}}<span class="empty">[sem texto]</span>{{end}}<div class="time">{{.Time}}</div></div>
+{{if .BotMsg}}<div class="msg bot"><div class="name">MurailoBOT</div>{{.BotMsg}}<div class="time">{{.Time}}</div></div>
+{{end}}{{end}}</body>
+</html>
+`))
+
+// chatExportEntry is a chat history entry prepared for the HTML export.
+type chatExportEntry struct {
+	Day      string // Date heading shown before the first entry of a day
+	UserName string // Name of the user
+	UserMsg  string // Message sent by the user
+	BotMsg   string // Reply of the bot
+	Time     string // Time of the entry
+}
+
+// renderChatHTML renders chat history, sorted oldest first, as a self-contained HTML page.
+func renderChatHTML(title string, history []ChatHistory) ([]byte, error) {
+	var entries []chatExportEntry
+	lastDay := ""
+	for _, entry := range history {
+		day := entry.LastUsed.Format("2006-01-02")
+		exported := chatExportEntry{
+			UserName: entry.UserName,
+			UserMsg:  entry.UserMsg,
+			BotMsg:   entry.BotMsg,
+			Time:     entry.LastUsed.Format("15:04"),
+		}
+		if exported.UserName == "" {
+			exported.UserName = fmt.Sprintf("UID %d", entry.UserID)
+		}
+		if day != lastDay {
+			exported.Day = day
+			lastDay = day
+		}
+		entries = append(entries, exported)
+	}
+
+	var buf bytes.Buffer
+	err := chatExportTemplate.Execute(&buf, struct {
+		Title   string
+		Entries []chatExportEntry
+	}{title, entries})
+	if err != nil {
+		return nil, WrapError("failed to render chat export", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// handleMrlExportHTMLRequest processes the /mrl_export_html command, which sends the chat
+// history of the last days as an HTML page to the admin chat.
+func (tg *Telegram) handleMrlExportHTMLRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_EXPORT_HTML request")
+
+	maxDays := int(maxHistoryTimeRange / (24 * time.Hour))
+	usage := fmt.Sprintf("/mrl_export_html [days], with at most %d days", maxDays)
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 1)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+	days := defaultExportDays
+	if arg := args.Arg(0); arg != "" {
+		days, err = strconv.Atoi(arg)
+		if err != nil || days < 1 || days > maxDays {
+			return tg.sendUsage(ctx, usage, nil)
+		}
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	to := time.Now()
+	from := to.Add(-time.Duration(days) * 24 * time.Hour)
+	history, err := tg.db.GetChatHistoryInTimeRange(chatID, from, to)
+	if err != nil {
+		return WrapError("failed to get chat history", err)
+	}
+	if len(history) == 0 {
+		return tg.sendTelegramMessage(ctx, "No chat history in this period.")
+	}
+
+	title := fmt.Sprintf("%s, %s to %s", ctx.EffectiveMessage.Chat.Title, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if ctx.EffectiveMessage.Chat.Title == "" {
+		title = fmt.Sprintf("Chat %d, %s to %s", chatID, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	}
+	page, err := renderChatHTML(title, history)
+	if err != nil {
+		return WrapError("failed to render chat export", err)
+	}
+
+	name := fmt.Sprintf("chat-%d-%s.html", chatID, to.Format("20060102"))
+	_, err = tg.bot.SendDocument(int64(tg.adminChatID()), gotgbot.NamedFile{FileName: name, File: bytes.NewReader(page)}, &gotgbot.SendDocumentOpts{
+		Caption: fmt.Sprintf("%d entries", len(history)),
+	})
+	if err != nil {
+		return WrapError("failed to send chat export", err)
+	}
+	return tg.sendTelegramMessage(ctx, fmt.Sprintf("Exported %d entries to the admin chat.", len(history)))
+}
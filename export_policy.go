@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// chatExportPolicySetting is the chat settings key holding the export policy of a chat.
+const chatExportPolicySetting = "export_policy"
+
+// Export policies controlling where messages stored from a chat may be forwarded.
+const (
+	ExportPolicyAllow    = "allow"     // Messages may be forwarded to any chat
+	ExportPolicySameChat = "same_chat" // Messages may only be forwarded within the chat they were stored from
+)
+
+// handleMrlExportPolicyRequest processes the /mrl_export_policy command, which shows or sets the
+// export policy of the chat.
+func (tg *Telegram) handleMrlExportPolicyRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_EXPORT_POLICY request")
+
+	usage := "/mrl_export_policy <allow|same_chat>"
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 1)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	policy := args.Arg(0)
+	switch policy {
+	case "":
+		current, ok, err := tg.db.GetChatSetting(chatID, chatExportPolicySetting)
+		if err != nil {
+			return WrapError("failed to get export policy", err)
+		}
+		if !ok {
+			current = ExportPolicyAllow
+		}
+		return tg.sendTelegramMessage(ctx, "Export policy: "+current+"\nUsage: "+usage)
+	case ExportPolicyAllow:
+		err = tg.db.DeleteChatSetting(chatID, chatExportPolicySetting)
+		if err != nil {
+			return WrapError("failed to reset export policy", err)
+		}
+	case ExportPolicySameChat:
+		err = tg.db.SetChatSetting(chatID, chatExportPolicySetting, policy)
+		if err != nil {
+			return WrapError("failed to set export policy", err)
+		}
+	default:
+		return tg.sendUsage(ctx, usage, nil)
+	}
+	return tg.sendTelegramMessage(ctx, "Export policy set to "+policy+".")
+}
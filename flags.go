@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// Feature flags gating capabilities during their rollout.
+const (
+	FlagRegenerate        = "regenerate"         // Regenerate replies on a 🔄 reaction
+	FlagStructuredContext = "structured_context" // Keep polls, contacts and locations as context
+	FlagStreaming         = "streaming"          // Show replies while they are generated
+)
+
+// flagDefaults holds the known feature flags and their state when not configured.
+var flagDefaults = map[string]bool{
+	FlagRegenerate:        true,
+	FlagStructuredContext: true,
+	FlagStreaming:         false,
+}
+
+// flagSettingPrefix prefixes the chat settings overriding feature flags.
+const flagSettingPrefix = "flag."
+
+// globalFlagChat is the chat ID under which the overrides for all chats are stored.
+const globalFlagChat ChatID = 0
+
+// Flags decides whether feature flags are enabled and lets them be overridden at runtime.
+type Flags interface {
+	Enabled(name string, chatID ChatID) bool                 // Whether the flag is enabled in a chat
+	Override(name string, chatID ChatID, enabled bool) error // Override the flag in a chat
+	Reset(name string, chatID ChatID) error                  // Remove the override of the flag in a chat
+}
+
+// FeatureFlags evaluates feature flags from, in order of precedence, the overrides of the chat,
+// the overrides for all chats, the configuration, and the defaults.
+type FeatureFlags struct {
+	db       *DB
+	defaults map[string]bool
+}
+
+// NewFeatureFlags creates the feature flags from the configuration, rejecting unknown flags.
+func NewFeatureFlags(config *Config, db *DB) (*FeatureFlags, error) {
+	defaults := make(map[string]bool, len(flagDefaults))
+	for name, enabled := range flagDefaults {
+		defaults[name] = enabled
+	}
+	for name, enabled := range config.FeatureFlags {
+		if _, ok := flagDefaults[name]; !ok {
+			return nil, WrapError(fmt.Sprintf("unknown feature flag %q", name))
+		}
+		defaults[name] = enabled
+	}
+	return &FeatureFlags{db: db, defaults: defaults}, nil
+}
+
+// state returns whether a flag is enabled in a chat and where the value comes from.
+func (flags *FeatureFlags) state(name string, chatID ChatID) (bool, string) {
+	for _, scope := range []ChatID{chatID, globalFlagChat} {
+		value, ok, err := flags.db.GetChatSetting(scope, flagSettingPrefix+name)
+		if err != nil {
+			log.Error().Err(err).Str("flag", name).Int64("chat_id", int64(scope)).Msg("Failed to get feature flag override")
+			continue
+		}
+		if !ok {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Error().Err(err).Str("flag", name).Str("value", value).Msg("Invalid feature flag override")
+			continue
+		}
+		if scope == globalFlagChat {
+			return enabled, "global"
+		}
+		return enabled, "chat"
+	}
+	return flags.defaults[name], "config"
+}
+
+// Enabled implements Flags.
+func (flags *FeatureFlags) Enabled(name string, chatID ChatID) bool {
+	enabled, source := flags.state(name, chatID)
+	componentLog(LogFlags).Debug().Str("flag", name).Int64("chat_id", int64(chatID)).Bool("enabled", enabled).Str("source", source).Msg("Evaluated feature flag")
+	return enabled
+}
+
+// Override implements Flags.
+func (flags *FeatureFlags) Override(name string, chatID ChatID, enabled bool) error {
+	if _, ok := flags.defaults[name]; !ok {
+		return WrapError(fmt.Sprintf("unknown feature flag %q", name))
+	}
+	return flags.db.SetChatSetting(chatID, flagSettingPrefix+name, strconv.FormatBool(enabled))
+}
+
+// Reset implements Flags.
+func (flags *FeatureFlags) Reset(name string, chatID ChatID) error {
+	if _, ok := flags.defaults[name]; !ok {
+		return WrapError(fmt.Sprintf("unknown feature flag %q", name))
+	}
+	return flags.db.DeleteChatSetting(chatID, flagSettingPrefix+name)
+}
+
+// handleMrlFlagRequest processes the /mrl_flag command, which lists the feature flags of the chat
+// or overrides one of them, in the chat or, with scope=global, in all chats.
+func (tg *Telegram) handleMrlFlagRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_FLAG request")
+
+	usage := "/mrl_flag [<name> <on|off|default>] [scope=chat|global]"
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 2, "scope")
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	if len(args.Positional) == 0 {
+		names := make([]string, 0, len(flagDefaults))
+		for name := range flagDefaults {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var b strings.Builder
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s: %t\n", name, tg.flags.Enabled(name, chatID))
+		}
+		return tg.sendTelegramMessage(ctx, strings.TrimSpace(b.String()))
+	}
+	if len(args.Positional) != 2 {
+		return tg.sendUsage(ctx, usage, nil)
+	}
+
+	scope, name, value := chatID, args.Arg(0), args.Arg(1)
+	switch args.Named["scope"] {
+	case "", "chat":
+	case "global":
+		scope = globalFlagChat
+	default:
+		return tg.sendUsage(ctx, usage, nil)
+	}
+	if _, ok := flagDefaults[name]; !ok {
+		return tg.sendTelegramMessage(ctx, fmt.Sprintf("Unknown feature flag %q.", name))
+	}
+
+	switch value {
+	case "on", "off":
+		err = tg.flags.Override(name, scope, value == "on")
+	case "default":
+		err = tg.flags.Reset(name, scope)
+	default:
+		return tg.sendUsage(ctx, usage, nil)
+	}
+	if err != nil {
+		return WrapError("failed to override feature flag", err)
+	}
+	log.Info().Str("flag", name).Int64("chat_id", int64(scope)).Str("value", value).Msg("Feature flag overridden")
+	return tg.sendTelegramMessage(ctx, fmt.Sprintf("Feature flag %s is now %t in this chat.", name, tg.flags.Enabled(name, chatID)))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// floodDuplicateLimit is the number of near-identical requests in the flood window that counts
+// as a flood.
+const floodDuplicateLimit = 3
+
+// floodStateRetention is how long the state of a user is kept after their last request, along
+// with the count of their cooldowns.
+const floodStateRetention = 24 * time.Hour
+
+// throttlePruneInterval is how often the flood guard and the rate limiter drop stale state.
+const throttlePruneInterval = 10 * time.Minute
+
+// floodState tracks the recent requests of a user.
+type floodState struct {
+	requests      []time.Time // Times of the requests in the window
+	texts         []string    // Normalized texts of the requests in the window
+	cooldownUntil time.Time   // Time until which the user is throttled
+	throttles     int         // Number of cooldowns the user got
+	lastSeen      time.Time   // Time of the last request
+}
+
+// FloodVerdict is the outcome of checking a request for flooding.
+type FloodVerdict struct {
+	Throttled bool   // Whether the request must be skipped
+	Started   bool   // Whether this request started the cooldown
+	Reason    string // Why the cooldown started: rate or duplicate
+	Throttles int    // Number of cooldowns the user got so far
+}
+
+// FloodGuard detects users flooding the bot with requests or repeating the same message.
+type FloodGuard struct {
+	mu     sync.Mutex
+	users  map[UserID]*floodState
+	pruned time.Time // When stale users were last dropped
+}
+
+// NewFloodGuard creates a new flood guard.
+func NewFloodGuard() *FloodGuard {
+	return &FloodGuard{users: make(map[UserID]*floodState)}
+}
+
+// normalizeFloodText reduces a text to its lowercase letters and digits, so near-identical
+// messages compare equal.
+func normalizeFloodText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, text)
+}
+
+// Check records a request of a user and reports whether it must be throttled. A user is put on
+// cooldown after more than limit requests in the window, or after repeating the same text.
+func (guard *FloodGuard) Check(userID UserID, text string, now time.Time, limit int, window, cooldown time.Duration) FloodVerdict {
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+
+	guard.prune(now)
+	state, ok := guard.users[userID]
+	if !ok {
+		state = &floodState{}
+		guard.users[userID] = state
+	}
+	state.lastSeen = now
+	if now.Before(state.cooldownUntil) {
+		return FloodVerdict{Throttled: true, Throttles: state.throttles}
+	}
+
+	kept := 0
+	for i, requestedAt := range state.requests {
+		if now.Sub(requestedAt) < window {
+			state.requests[kept] = requestedAt
+			state.texts[kept] = state.texts[i]
+			kept++
+		}
+	}
+	state.requests = append(state.requests[:kept], now)
+	state.texts = append(state.texts[:kept], normalizeFloodText(text))
+
+	reason := ""
+	if len(state.requests) > limit {
+		reason = "rate"
+	} else if current := state.texts[len(state.texts)-1]; current != "" {
+		duplicates := 0
+		for _, previous := range state.texts {
+			if previous == current {
+				duplicates++
+			}
+		}
+		if duplicates >= floodDuplicateLimit {
+			reason = "duplicate"
+		}
+	}
+	if reason == "" {
+		return FloodVerdict{}
+	}
+
+	state.cooldownUntil = now.Add(cooldown)
+	state.throttles++
+	state.requests = nil
+	state.texts = nil
+	return FloodVerdict{Throttled: true, Started: true, Reason: reason, Throttles: state.throttles}
+}
+
+// prune drops the users without requests within floodStateRetention and no running cooldown, at
+// most once per throttlePruneInterval. The caller holds the lock.
+func (guard *FloodGuard) prune(now time.Time) {
+	if now.Sub(guard.pruned) < throttlePruneInterval {
+		return
+	}
+	guard.pruned = now
+	for userID, state := range guard.users {
+		if now.Sub(state.lastSeen) > floodStateRetention && !now.Before(state.cooldownUntil) {
+			delete(guard.users, userID)
+		}
+	}
+}
+
+// checkFlood reports whether a request must be skipped because its user is flooding the bot. The
+// user is told once per cooldown, and the admin is notified of repeated abuse.
+func (tg *Telegram) checkFlood(ctx *ext.Context, text string) bool {
+	if tg.config.TelegramFloodLimit <= 0 || UserID(ctx.EffectiveMessage.From.Id) == tg.config.TelegramAdminUID {
+		return false
+	}
+
+	userID, chatID := UserID(ctx.EffectiveMessage.From.Id), ChatID(ctx.EffectiveMessage.Chat.Id)
+	window := time.Duration(tg.config.TelegramFloodWindow * float64(time.Second))
+	cooldown := time.Duration(tg.config.TelegramFloodCooldown * float64(time.Second))
+	verdict := tg.flood.Check(userID, text, time.Now(), tg.config.TelegramFloodLimit, window, cooldown)
+	if !verdict.Throttled {
+		return false
+	}
+	if !verdict.Started {
+		componentLog(LogFlood).Debug().Int64("user_id", int64(userID)).Msg("Ignoring request of throttled user")
+		return true
+	}
+
+	log.Warn().Int64("chat_id", int64(chatID)).Int64("user_id", int64(userID)).Str("reason", verdict.Reason).Int("throttles", verdict.Throttles).Msg("User throttled for flooding")
+	err := tg.db.AddFloodEvent(chatID, userID, verdict.Reason)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to record flood event")
+	}
+	tg.webhooks.Notify(EventModeration, map[string]interface{}{
+		"chat_id": chatID, "user_id": userID, "action": "throttled", "reason": verdict.Reason, "throttles": verdict.Throttles,
+	})
+	err = tg.sendTelegramMessage(ctx, fmt.Sprintf("Calma! Muitas mensagens seguidas. Tente novamente em %s.", cooldown.Round(time.Second)))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to send cooldown notice")
+	}
+
+	if tg.config.TelegramFloodAlertAfter > 0 && verdict.Throttles%tg.config.TelegramFloodAlertAfter == 0 {
+		text := fmt.Sprintf("User %d (@%s) was throttled %d times for flooding, last in chat %d (%s).",
+			userID, ctx.EffectiveMessage.From.Username, verdict.Throttles, chatID, verdict.Reason)
+		err = tg.notifyAdmin(text)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to send flood alert to admin")
+		}
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// chatFocusSetting is the chat setting holding the Unix time focus mode ends in the chat.
+const chatFocusSetting = "focus_until"
+
+// Focus mode limits.
+const (
+	defaultFocusDuration = 15 * time.Minute // Duration of focus mode when none is given
+	maxFocusDuration     = 4 * time.Hour    // Longest focus mode allowed
+	focusCheckInterval   = 30 * time.Second // How often ended focus modes are announced
+)
+
+// focusUntil returns when focus mode ends in a chat, and whether it is active.
+func (tg *Telegram) focusUntil(chatID ChatID) (time.Time, bool) {
+	value, ok, err := tg.db.GetChatSetting(chatID, chatFocusSetting)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to get focus mode")
+		return time.Time{}, false
+	}
+	if !ok {
+		return time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Str("value", value).Msg("Invalid focus mode end")
+		return time.Time{}, false
+	}
+	until := time.Unix(unix, 0)
+	return until, time.Now().Before(until)
+}
+
+// inFocus reports whether the bot answers every message of the chat that is not a command.
+func (tg *Telegram) inFocus(ctx *ext.Context) bool {
+	if strings.HasPrefix(ctx.EffectiveMessage.Text, "/") || ctx.EffectiveMessage.From == nil || ctx.EffectiveMessage.From.IsBot {
+		return false
+	}
+	_, active := tg.focusUntil(ChatID(ctx.EffectiveMessage.Chat.Id))
+	return active
+}
+
+// endFocus turns focus mode off in a chat and announces it.
+func (tg *Telegram) endFocus(chatID ChatID) {
+	err := tg.db.DeleteChatSetting(chatID, chatFocusSetting)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to end focus mode")
+		return
+	}
+	log.Info().Int64("chat_id", int64(chatID)).Msg("Focus mode ended")
+	_, err = tg.newMessage(chatID, "Modo foco encerrado. Voltei a responder só quando chamado.").Send()
+	if err != nil {
+		log.Warn().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to announce end of focus mode")
+	}
+}
+
+// runFocusExpiry periodically ends and announces the focus modes that ran out.
+func (tg *Telegram) runFocusExpiry() {
+	ticker := time.NewTicker(focusCheckInterval)
+	defer ticker.Stop()
+	for {
+		settings, err := tg.db.GetChatSettingsByKey(chatFocusSetting)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get focus modes")
+		}
+		for chatID := range settings {
+			if _, active := tg.focusUntil(chatID); !active {
+				tg.endFocus(chatID)
+			}
+		}
+		select {
+		case <-tg.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleMrlFocusRequest processes the /mrl_focus command, which makes the bot answer every
+// message of the chat for the given duration, or with "off" ends focus mode early.
+func (tg *Telegram) handleMrlFocusRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_FOCUS request")
+
+	usage := "/mrl_focus [<duration, e.g. 15m>|off]"
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 1)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	if args.Arg(0) == "off" {
+		if _, active := tg.focusUntil(chatID); !active {
+			return tg.sendTelegramMessage(ctx, "Focus mode is not active.")
+		}
+		tg.endFocus(chatID)
+		return nil
+	}
+
+	duration := defaultFocusDuration
+	if arg := args.Arg(0); arg != "" {
+		duration, err = time.ParseDuration(arg)
+		if err != nil || duration < time.Minute || duration > maxFocusDuration {
+			return tg.sendTelegramMessage(ctx, "Duration must be between 1m and "+maxFocusDuration.String()+", or off.")
+		}
+	}
+	until := time.Now().Add(duration)
+	err = tg.db.SetChatSetting(chatID, chatFocusSetting, strconv.FormatInt(until.Unix(), 10))
+	if err != nil {
+		return WrapError("failed to set focus mode", err)
+	}
+	log.Info().Int64("chat_id", int64(chatID)).Time("until", until).Msg("Focus mode started")
+	return tg.sendTelegramMessage(ctx, "Modo foco ativado por "+duration.String()+": vou responder todas as mensagens deste chat.")
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// Follow-up limits.
+const (
+	followUpMaxNote       = 300              // Maximum number of characters of a follow-up note
+	followUpMinDelay      = time.Minute      // Shortest delay of a follow-up
+	followUpCheckInterval = 30 * time.Second // How often due follow-ups are posted
+)
+
+// followUpTools are the tools letting the model schedule follow-up messages in a chat.
+var followUpTools = []Tool{
+	{
+		Name:        "schedule_followup",
+		Description: "Schedule a message to be posted in this chat later, to check back on something or remind the user who asked for it.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"at":   map[string]interface{}{"type": "string", "description": "When to post the message, as an RFC 3339 timestamp with time zone offset"},
+				"note": map[string]interface{}{"type": "string", "description": fmt.Sprintf("The message to post, written to the user, at most %d characters", followUpMaxNote)},
+			},
+			"required": []string{"at", "note"},
+		},
+	},
+}
+
+// scheduleFollowUp stores a follow-up, enforcing the limits. Limit violations are returned as an
+// ArgsError.
+func (tg *Telegram) scheduleFollowUp(followUp *FollowUp) error {
+	followUp.Note = strings.TrimSpace(followUp.Note)
+	maxDelay := time.Duration(tg.config.FollowUpsMaxDays) * 24 * time.Hour
+	delay := time.Until(followUp.DueAt)
+	switch {
+	case followUp.Note == "":
+		return &ArgsError{Message: "note must not be empty"}
+	case len([]rune(followUp.Note)) > followUpMaxNote:
+		return &ArgsError{Message: fmt.Sprintf("note is longer than %d characters", followUpMaxNote)}
+	case delay < followUpMinDelay:
+		return &ArgsError{Message: "time must be at least a minute in the future"}
+	case delay > maxDelay:
+		return &ArgsError{Message: fmt.Sprintf("time must be at most %d days in the future", tg.config.FollowUpsMaxDays)}
+	}
+
+	pending, err := tg.db.CountFollowUps(followUp.ChatID)
+	if err != nil {
+		return WrapError("failed to count follow-ups", err)
+	}
+	if pending >= tg.config.FollowUpsMaxPerChat {
+		return &ArgsError{Message: fmt.Sprintf("this chat already has %d scheduled follow-ups", pending)}
+	}
+	return tg.db.AddFollowUp(followUp)
+}
+
+// followUpToolHandler returns the handler of the follow-up tool calls made while answering a
+// message. Its results are meant for the model.
+func (tg *Telegram) followUpToolHandler(msg *gotgbot.Message) func(name, arguments string) string {
+	return func(name, arguments string) string {
+		var args struct {
+			At   string `json:"at"`
+			Note string `json:"note"`
+		}
+		err := json.Unmarshal([]byte(arguments), &args)
+		if err != nil {
+			return "error: invalid arguments"
+		}
+		dueAt, err := time.Parse(time.RFC3339, args.At)
+		if err != nil {
+			return "error: at must be an RFC 3339 timestamp"
+		}
+
+		followUp := FollowUp{
+			ChatID:    ChatID(msg.Chat.Id),
+			UserID:    UserID(msg.From.Id),
+			MessageID: MessageID(msg.MessageId),
+			Note:      args.Note,
+			DueAt:     dueAt.Local(), // Stored times are compared as text, so they share the zone
+
+			CreatedAt: time.Now(),
+		}
+		err = tg.scheduleFollowUp(&followUp)
+		var argsErr *ArgsError
+		if errors.As(err, &argsErr) {
+			return "error: " + argsErr.Message
+		}
+		if err != nil {
+			log.Warn().Err(err).Int64("chat_id", msg.Chat.Id).Msg("Failed to schedule follow-up")
+			return "error: the follow-up could not be scheduled"
+		}
+		log.Info().Int64("chat_id", msg.Chat.Id).Time("due_at", dueAt).Msg("Model scheduled follow-up")
+		return "ok"
+	}
+}
+
+// postDueFollowUps posts the follow-ups that are due, as replies to the messages that led to them.
+// Follow-ups are removed once attempted, so a failing chat is not retried forever.
+func (tg *Telegram) postDueFollowUps() error {
+	followUps, err := tg.db.GetDueFollowUps(time.Now())
+	if err != nil {
+		return WrapError("failed to get due follow-ups", err)
+	}
+	for _, followUp := range followUps {
+		_, err = tg.newMessage(followUp.ChatID, "⏰ "+followUp.Note).ReplyToID(followUp.MessageID).Send()
+		if err != nil {
+			log.Warn().Err(err).Int64("chat_id", int64(followUp.ChatID)).Uint("follow_up_id", followUp.ID).Msg("Failed to post follow-up")
+		}
+		_, err = tg.db.DeleteFollowUp(followUp.ID)
+		if err != nil {
+			return WrapError("failed to delete follow-up", err)
+		}
+	}
+	return nil
+}
+
+// runFollowUps periodically posts the due follow-ups.
+func (tg *Telegram) runFollowUps() {
+	ticker := time.NewTicker(followUpCheckInterval)
+	defer ticker.Stop()
+	for {
+		err := tg.postDueFollowUps()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to post follow-ups")
+		}
+		select {
+		case <-tg.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleMrlRemindersRequest processes the /mrl_reminders command, which lists the follow-ups
+// scheduled in all chats or cancels one of them.
+func (tg *Telegram) handleMrlRemindersRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_REMINDERS request")
+
+	usage := "/mrl_reminders [cancel <id>]"
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 2)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	switch args.Arg(0) {
+	case "":
+	case "cancel":
+		id, err := strconv.ParseUint(args.Arg(1), 10, 64)
+		if err != nil {
+			return tg.sendUsage(ctx, usage, nil)
+		}
+		deleted, err := tg.db.DeleteFollowUp(uint(id))
+		if err != nil {
+			return WrapError("failed to delete follow-up", err)
+		}
+		if !deleted {
+			return tg.sendTelegramMessage(ctx, fmt.Sprintf("No scheduled follow-up with ID %d.", id))
+		}
+		return tg.sendTelegramMessage(ctx, fmt.Sprintf("Follow-up %d cancelled.", id))
+	default:
+		return tg.sendUsage(ctx, usage, nil)
+	}
+
+	followUps, err := tg.db.GetFollowUps()
+	if err != nil {
+		return WrapError("failed to get follow-ups", err)
+	}
+	if len(followUps) == 0 {
+		return tg.sendTelegramMessage(ctx, "No follow-ups scheduled.")
+	}
+	var sb strings.Builder
+	for _, followUp := range followUps {
+		line := fmt.Sprintf("%d. %s chat %d user %d: %s\n", followUp.ID, followUp.DueAt.Format(time.RFC3339), followUp.ChatID, followUp.UserID, followUp.Note)
+		if sb.Len()+len(line) > listMessageLength {
+			break
+		}
+		sb.WriteString(line)
+	}
+	return tg.sendTelegramMessage(ctx, strings.TrimSpace(sb.String()))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// helpStrings holds the texts of the /help command in one language.
+type helpStrings struct {
+	Intro      string // Opening line
+	Commands   string // Heading of the command list
+	Settings   string // Heading of the chat settings
+	Memory     string // Label of the memory setting
+	Remembered string // Memory enabled
+	NotStored  string // Memory disabled
+	Forwards   string // Label of the export policy
+	AnyChat    string // Messages may be forwarded anywhere
+	ThisChat   string // Messages may only be forwarded within the chat
+	Temp       string // Label of the reply temperature
+	AdminNote  string // Note on admin commands
+}
+
+// helpTexts holds the texts of the /help command by IETF language code.
+var helpTexts = map[string]helpStrings{
+	"pt": {
+		Intro:      "Eu guardo mensagens encaminhadas para mim e respondo perguntas com /mrl.",
+		Commands:   "Comandos:",
+		Settings:   "Neste chat:",
+		Memory:     "Memória das conversas",
+		Remembered: "ativada",
+		NotStored:  "desativada",
+		Forwards:   "Mensagens guardadas daqui podem ir para",
+		AnyChat:    "qualquer chat",
+		ThisChat:   "apenas este chat",
+		Temp:       "Temperatura das respostas",
+		AdminNote:  "Comandos de administração estão disponíveis apenas para o admin do bot.",
+	},
+	"en": {
+		Intro:      "I store messages forwarded to me and answer questions with /mrl.",
+		Commands:   "Commands:",
+		Settings:   "In this chat:",
+		Memory:     "Conversation memory",
+		Remembered: "on",
+		NotStored:  "off",
+		Forwards:   "Messages stored from here may go to",
+		AnyChat:    "any chat",
+		ThisChat:   "this chat only",
+		Temp:       "Reply temperature",
+		AdminNote:  "Administration commands are only available to the bot admin.",
+	},
+}
+
+// helpLanguage returns the language of the /help texts for an IETF language code.
+func helpLanguage(languageCode string) string {
+	base, _, _ := strings.Cut(languageCode, "-")
+	if _, ok := helpTexts[base]; ok {
+		return base
+	}
+	return "pt"
+}
+
+// handleHelpRequest processes the /help command, which lists the commands the user can run in the
+// chat and how the bot is configured there.
+func (tg *Telegram) handleHelpRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received HELP request")
+
+	language := helpLanguage(ctx.EffectiveMessage.From.LanguageCode)
+	texts := helpTexts[language]
+	// Command descriptions default to Portuguese and are translated by language code
+	languageCode := language
+	if language == "pt" {
+		languageCode = ""
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	isAdmin := UserID(ctx.EffectiveMessage.From.Id) == tg.config.TelegramAdminUID
+	scope := ScopeGroup
+	if ctx.EffectiveMessage.Chat.Type == "private" {
+		scope = ScopePrivate
+	}
+	if isAdmin {
+		scope |= ScopeAdmin
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s\n\n%s\n%s\n", texts.Intro, texts.Commands, tg.commands.HelpText(scope, languageCode))
+	if !isAdmin {
+		fmt.Fprintf(&sb, "%s\n", texts.AdminNote)
+	}
+
+	memory := texts.Remembered
+	if tg.config.Stateless || !tg.learnsFrom(chatID) {
+		memory = texts.NotStored
+	}
+	exportPolicy, _, err := tg.db.GetChatSetting(chatID, chatExportPolicySetting)
+	if err != nil {
+		return WrapError("failed to get export policy", err)
+	}
+	forwards := texts.AnyChat
+	if exportPolicy == ExportPolicySameChat {
+		forwards = texts.ThisChat
+	}
+	temperature := tg.config.OpenAITemperature
+	if override := tg.responseTemperature(chatID, ""); override != nil {
+		temperature = *override
+	}
+	fmt.Fprintf(&sb, "\n%s\n%s: %s\n%s: %s\n%s: %.2g\n", texts.Settings, texts.Memory, memory, texts.Forwards, forwards, texts.Temp, temperature)
+
+	return tg.sendTelegramMessage(ctx, strings.TrimSpace(sb.String()))
+}
@@ -0,0 +1,10 @@
+package main
+
+// ChatID identifies a Telegram chat.
+type ChatID int64
+
+// UserID identifies a Telegram user.
+type UserID int64
+
+// MessageID identifies a Telegram message within a chat.
+type MessageID int64
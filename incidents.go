@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// incidentReplyTemplate is the reply to a message whose handling failed. It only carries the
+// incident ID, never the error, which may contain internal details.
+const incidentReplyTemplate = "Algo deu errado (ref %s). Se continuar acontecendo, avise o administrador com esse código."
+
+// newIncidentID returns a short random ID correlating an error reply with its log line.
+func newIncidentID() string {
+	b := make([]byte, 2)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "0000"
+	}
+	return hex.EncodeToString(b)
+}
+
+// handleDispatchError logs an error that occurred while handling an update under a new incident ID
+// and, when the update is a message, tells its sender the ID so the admin can find the log line.
+func (tg *Telegram) handleDispatchError(b *gotgbot.Bot, ctx *ext.Context, err error) ext.DispatcherAction {
+	incident := newIncidentID()
+	event := log.Error().Err(err).Str("incident", incident)
+	if ctx.EffectiveChat != nil {
+		event = event.Int64("chat_id", ctx.EffectiveChat.Id)
+	}
+	event.Msg("Error occurred while handling update")
+	tg.metrics.recordError("dispatch", "handler")
+
+	if ctx.Message == nil {
+		return ext.DispatcherActionNoop
+	}
+	_, err = tg.newMessage(ChatID(ctx.Message.Chat.Id), fmt.Sprintf(incidentReplyTemplate, incident)).PlainText().ReplyTo(ctx.Message).Send()
+	if err != nil {
+		log.Warn().Err(err).Str("incident", incident).Msg("Failed to send error reply")
+	}
+	return ext.DispatcherActionNoop
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// LatencySummary holds percentiles of the reply latencies observed in a window.
+type LatencySummary struct {
+	Count int           // Number of replies in the window
+	P50   time.Duration // Median reply latency
+	P95   time.Duration // 95th percentile reply latency
+}
+
+// summarizeLatencies computes the latency percentiles of the given samples.
+func summarizeLatencies(latencies []time.Duration) LatencySummary {
+	if len(latencies) == 0 {
+		return LatencySummary{}
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return LatencySummary{
+		Count: len(sorted),
+		P50:   percentile(sorted, 0.50),
+		P95:   percentile(sorted, 0.95),
+	}
+}
+
+// percentile returns the nearest-rank percentile of sorted samples.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// SLOTracker alerts the admin when the reply latency of a chat breaches the configured SLO.
+type SLOTracker struct {
+	mu         sync.Mutex
+	lastAlerts map[ChatID]time.Time // Time of the last alert per chat
+}
+
+// NewSLOTracker creates a new reply latency SLO tracker.
+func NewSLOTracker() *SLOTracker {
+	return &SLOTracker{lastAlerts: make(map[ChatID]time.Time)}
+}
+
+// shouldAlert reports whether a breach in a chat should be alerted, allowing one alert per window.
+func (tracker *SLOTracker) shouldAlert(chatID ChatID, window time.Duration) bool {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if time.Since(tracker.lastAlerts[chatID]) < window {
+		return false
+	}
+	tracker.lastAlerts[chatID] = time.Now()
+	return true
+}
+
+// sloWindow returns the configured reply latency window.
+func (tg *Telegram) sloWindow() time.Duration {
+	return time.Duration(tg.config.TelegramReplySLOWindow * float64(time.Minute))
+}
+
+// chatLatencySummary returns the reply latency percentiles of a chat over the SLO window.
+func (tg *Telegram) chatLatencySummary(chatID ChatID) (LatencySummary, error) {
+	latencies, err := tg.db.GetReplyLatencies(chatID, time.Now().Add(-tg.sloWindow()))
+	if err != nil {
+		return LatencySummary{}, WrapError("failed to get reply latencies", err)
+	}
+	return summarizeLatencies(latencies), nil
+}
+
+// checkReplySLO alerts the admin when the p95 reply latency of a chat exceeds the SLO.
+func (tg *Telegram) checkReplySLO(chatID ChatID) {
+	if tg.config.TelegramReplySLO <= 0 {
+		return
+	}
+	summary, err := tg.chatLatencySummary(chatID)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to check reply latency SLO")
+		return
+	}
+
+	slo := time.Duration(tg.config.TelegramReplySLO * float64(time.Second))
+	if summary.P95 <= slo || !tg.slo.shouldAlert(chatID, tg.sloWindow()) {
+		return
+	}
+
+	log.Warn().Int64("chat_id", int64(chatID)).Dur("p95", summary.P95).Dur("slo", slo).Msg("Reply latency SLO breached")
+	text := fmt.Sprintf("Reply latency SLO breached in chat %d: p95 %s over the last %s (target %s, %d replies).",
+		chatID, summary.P95.Round(time.Millisecond), tg.sloWindow(), slo, summary.Count)
+	tg.webhooks.Notify(EventSLOBreached, map[string]interface{}{
+		"chat_id":    chatID,
+		"p95_ms":     summary.P95.Milliseconds(),
+		"slo_ms":     slo.Milliseconds(),
+		"replies":    summary.Count,
+		"window_min": tg.config.TelegramReplySLOWindow,
+	})
+	err = tg.notifyAdmin(text)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to send SLO alert to admin")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// chatLearningSetting is the chat settings key holding the learning policy of a chat.
+const chatLearningSetting = "learning"
+
+// Learning policies controlling whether conversations in a chat are remembered.
+const (
+	LearningPolicyAll       = "learn_all"  // Conversations are stored as chat history
+	LearningPolicyReplyOnly = "reply_only" // The bot replies but stores nothing from the chat
+)
+
+// learnsFrom reports whether conversations in a chat may be stored as chat history. When the
+// policy cannot be read, nothing is stored.
+func (tg *Telegram) learnsFrom(chatID ChatID) bool {
+	policy, _, err := tg.db.GetChatSetting(chatID, chatLearningSetting)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to get learning policy")
+		return false
+	}
+	return policy != LearningPolicyReplyOnly
+}
+
+// handleMrlLearningRequest processes the /mrl_learning command, which shows or sets the learning
+// policy of the chat.
+func (tg *Telegram) handleMrlLearningRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_LEARNING request")
+
+	usage := "/mrl_learning <learn_all|reply_only>"
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 1)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	policy := args.Arg(0)
+	switch policy {
+	case "":
+		current, ok, err := tg.db.GetChatSetting(chatID, chatLearningSetting)
+		if err != nil {
+			return WrapError("failed to get learning policy", err)
+		}
+		if !ok {
+			current = LearningPolicyAll
+		}
+		return tg.sendTelegramMessage(ctx, "Learning policy: "+current+"\nUsage: "+usage)
+	case LearningPolicyAll:
+		err = tg.db.DeleteChatSetting(chatID, chatLearningSetting)
+		if err != nil {
+			return WrapError("failed to reset learning policy", err)
+		}
+	case LearningPolicyReplyOnly:
+		err = tg.db.SetChatSetting(chatID, chatLearningSetting, policy)
+		if err != nil {
+			return WrapError("failed to set learning policy", err)
+		}
+	default:
+		return tg.sendUsage(ctx, usage, nil)
+	}
+	return tg.sendTelegramMessage(ctx, "Learning policy set to "+policy+".")
+}
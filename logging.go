@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// logRollupInterval is how often the number of lines dropped by log sampling is reported.
+const logRollupInterval = 5 * time.Minute
+
+// Components with noisy log paths that can be sampled.
+const (
+	LogIncoming  = "incoming"  // Plain messages the bot ignores
+	LogFlood     = "flood"     // Requests of throttled users
+	LogBlocklist = "blocklist" // Requests of blocked users
+	LogFlags     = "flags"     // Feature flag evaluations
+)
+
+// countingSampler lets one in every N log lines through and counts the dropped ones.
+type countingSampler struct {
+	every   uint64
+	seen    atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// Sample implements zerolog.Sampler.
+func (sampler *countingSampler) Sample(lvl zerolog.Level) bool {
+	if lvl >= zerolog.WarnLevel || (sampler.seen.Add(1)-1)%sampler.every == 0 {
+		return true
+	}
+	sampler.dropped.Add(1)
+	return false
+}
+
+var (
+	sampledLoggersMu sync.RWMutex
+	sampledLoggers   = make(map[string]zerolog.Logger)
+	logSamplers      = make(map[string]*countingSampler)
+)
+
+// setupLogSampling configures the components whose logs are sampled, letting one in every N
+// lines below warning level through, and starts reporting the dropped lines periodically.
+func setupLogSampling(rates map[string]int) {
+	sampledLoggersMu.Lock()
+	defer sampledLoggersMu.Unlock()
+
+	for component, every := range rates {
+		if every <= 1 {
+			continue
+		}
+		sampler := &countingSampler{every: uint64(every)}
+		logSamplers[component] = sampler
+		sampledLoggers[component] = log.Sample(sampler).With().Str("component", component).Logger()
+	}
+	if len(logSamplers) > 0 {
+		go reportDroppedLogs()
+	}
+}
+
+// componentLog returns the logger of a component, which is sampled when configured so.
+func componentLog(component string) *zerolog.Logger {
+	sampledLoggersMu.RLock()
+	defer sampledLoggersMu.RUnlock()
+
+	logger, ok := sampledLoggers[component]
+	if !ok {
+		return &log.Logger
+	}
+	return &logger
+}
+
+// reportDroppedLogs periodically logs how many lines each component dropped since the last report.
+func reportDroppedLogs() {
+	ticker := time.NewTicker(logRollupInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		sampledLoggersMu.RLock()
+		components := make([]string, 0, len(logSamplers))
+		for component := range logSamplers {
+			components = append(components, component)
+		}
+		sort.Strings(components)
+		for _, component := range components {
+			dropped := logSamplers[component].dropped.Swap(0)
+			if dropped > 0 {
+				log.Info().Str("component", component).Uint64("dropped", dropped).Dur("interval", logRollupInterval).Msg("Log lines dropped by sampling")
+			}
+		}
+		sampledLoggersMu.RUnlock()
+	}
+}
@@ -1,15 +1,29 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/rs/zerolog/log"
 )
 
+// version is the bot version, set at build time.
+var version = "dev"
+
 // App encapsulates the entire application.
 type App struct {
-	Config *Config   // Configuration settings
-	DB     *DB       // Database handler
-	OAI    *OpenAI   // OpenAI handler
-	TB     *Telegram // Telegram bot handler
+	Config *Config              // Configuration settings
+	DB     *DB                  // Database handler
+	OAI    *OpenAI              // OpenAI handler
+	TB     *Telegram            // Telegram bot handler
+	WH     *Webhooks            // Webhook notifier
+	AN     *Analytics           // Analytics sink
+	MX     *Metrics             // Activity metrics
+	FF     *FeatureFlags        // Feature flags
+	OB     *ObservabilityServer // Health check and metrics server, nil if disabled
 }
 
 // NewApp creates and initializes a new App instance.
@@ -23,38 +37,106 @@ func NewApp() (*App, error) {
 		return nil, WrapError("failed to load config", err)
 	}
 
+	// Sample noisy log paths
+	setupLogSampling(app.Config.LogSampling)
+
 	// Initialize database
 	app.DB, err = NewDB(app.Config)
 	if err != nil {
 		return nil, WrapError("failed to init database", err)
 	}
 
+	// Initialize metrics
+	app.MX = NewMetrics()
+
 	// Initialize OpenAI
 	app.OAI, err = NewOpenAI(app.Config)
 	if err != nil {
 		return nil, WrapError("failed to init OpenAI", err)
 	}
+	app.OAI.Metrics = app.MX
+
+	// Initialize webhooks
+	app.WH = NewWebhooks(app.Config)
+
+	// Initialize analytics
+	app.AN = NewAnalytics(app.Config)
+	app.AN.Metrics = app.MX
+
+	// Initialize feature flags
+	app.FF, err = NewFeatureFlags(app.Config, app.DB)
+	if err != nil {
+		return nil, WrapError("failed to init feature flags", err)
+	}
 
 	// Initialize Telegram bot
-	app.TB, err = NewTelegram(app.Config, app.DB, app.OAI)
+	app.TB, err = NewTelegram(app.Config, app.DB, app.OAI, app.WH, app.AN, app.MX, app.FF)
 	if err != nil {
 		return nil, WrapError("failed to init Telegram bot", err)
 	}
 
+	// Initialize the health check and metrics server
+	if app.Config.MetricsListenAddr != "" {
+		app.OB = NewObservabilityServer(app.Config.MetricsListenAddr, app.DB, app.TB, app.OAI, app.MX)
+	}
+
 	return app, nil
 }
 
 // Run starts the App and handles graceful shutdown.
 func (app *App) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Start the Telegram bot
 	err := app.TB.Start()
 	if err != nil {
 		return WrapError("failed to start Telegram bot", err)
 	}
+	if app.OB != nil {
+		err = app.OB.Start()
+		if err != nil {
+			return WrapError("failed to start observability server", err)
+		}
+	}
+
+	<-ctx.Done()
+	log.Info().Msg("Shutting down")
+	app.shutdownManager().Run()
+	log.Info().Msg("Shutdown complete")
 	return nil
 }
 
+// shutdownManager returns the shutdown stages of the App: stop taking updates, drain the updates
+// being handled, flush the outgoing notifications, stop the background jobs and the observability
+// server, and close the database.
+func (app *App) shutdownManager() *ShutdownManager {
+	manager := &ShutdownManager{}
+	manager.Add("stop intake", 5*time.Second, app.TB.StopIntake)
+	manager.Add("drain workers", 30*time.Second, app.TB.Drain)
+	// Events the background jobs report from now until they stop are dropped and logged
+	manager.Add("flush outbox", 15*time.Second, func() error {
+		app.WH.Close()
+		return app.AN.Close()
+	})
+	manager.Add("stop scheduler", 10*time.Second, app.TB.StopJobs)
+	if app.OB != nil {
+		manager.Add("stop observability server", 10*time.Second, app.OB.Stop)
+	}
+	manager.Add("close database", 5*time.Second, app.DB.Close)
+	return manager
+}
+
 func main() {
+	// Run a subcommand if one is given
+	if len(os.Args) > 1 {
+		err := runCommand(os.Args[1], os.Args[2:])
+		if err != nil {
+			log.Fatal().Err(err).Msg("Command failed")
+		}
+		return
+	}
+
 	// Initialize the application
 	app, err := NewApp()
 	if err != nil {
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// Membership states of users in a chat.
+const (
+	MemberStatusMember = "member" // The user is in the chat
+	MemberStatusLeft   = "left"   // The user left or was removed from the chat
+)
+
+// chatMemberCountSetting is the chat setting holding the member count last reported by Telegram.
+const chatMemberCountSetting = "member_count"
+
+// activeMemberWindow is how recently a member must have written to count as active.
+const activeMemberWindow = 30 * 24 * time.Hour
+
+// isMembershipMessage reports whether a message is a service message about users joining or
+// leaving the chat.
+func isMembershipMessage(msg *gotgbot.Message) bool {
+	return len(msg.NewChatMembers) > 0 || msg.LeftChatMember != nil
+}
+
+// handleMembershipMessage records the users joining or leaving a chat.
+func (tg *Telegram) handleMembershipMessage(b *gotgbot.Bot, ctx *ext.Context) error {
+	msg := ctx.EffectiveMessage
+	chatID := ChatID(msg.Chat.Id)
+	now := time.Now()
+	for _, user := range msg.NewChatMembers {
+		if user.IsBot {
+			continue
+		}
+		err := tg.db.SetChatMemberStatus(chatID, UserID(user.Id), MemberStatusMember, now)
+		if err != nil {
+			return WrapError("failed to record joined member", err)
+		}
+	}
+	if user := msg.LeftChatMember; user != nil && !user.IsBot {
+		err := tg.db.SetChatMemberStatus(chatID, UserID(user.Id), MemberStatusLeft, now)
+		if err != nil {
+			return WrapError("failed to record left member", err)
+		}
+	}
+	return nil
+}
+
+// syncChatMembers stores the member count of a group and records its administrators as members.
+func (tg *Telegram) syncChatMembers(chatID ChatID) error {
+	count, err := tg.bot.GetChatMemberCount(int64(chatID), nil)
+	if err != nil {
+		tg.handleAPIError(chatID, err)
+		return WrapError("failed to get chat member count", err)
+	}
+	err = tg.db.SetChatSetting(chatID, chatMemberCountSetting, strconv.FormatInt(count, 10))
+	if err != nil {
+		return WrapError("failed to store chat member count", err)
+	}
+
+	admins, err := tg.bot.GetChatAdministrators(int64(chatID), nil)
+	if err != nil {
+		tg.handleAPIError(chatID, err)
+		return WrapError("failed to get chat administrators", err)
+	}
+	now := time.Now()
+	for _, admin := range admins {
+		user := admin.GetUser()
+		if user.IsBot {
+			continue
+		}
+		err = tg.db.SetChatMemberStatus(chatID, UserID(user.Id), MemberStatusMember, now)
+		if err != nil {
+			return WrapError("failed to record administrator", err)
+		}
+	}
+	return nil
+}
+
+// syncMembership reconciles the membership of the groups with recent activity.
+func (tg *Telegram) syncMembership() {
+	chatIDs, err := tg.db.GetChatsWithActivity(time.Now().Add(-activeMemberWindow))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get chats to sync membership")
+		return
+	}
+	for _, chatID := range chatIDs {
+		// Private chats have positive IDs and no membership to track
+		if chatID > 0 || tg.isChatBlocked(chatID) {
+			continue
+		}
+		err = tg.syncChatMembers(chatID)
+		if err != nil {
+			log.Warn().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to sync chat membership")
+		}
+	}
+}
+
+// runMembershipSync periodically reconciles the membership of the groups.
+func (tg *Telegram) runMembershipSync() {
+	ticker := time.NewTicker(time.Duration(tg.config.MembershipSyncInterval * float64(time.Minute)))
+	defer ticker.Stop()
+	for {
+		tg.syncMembership()
+		select {
+		case <-tg.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// membershipSummary returns the number of members active in a chat recently, and its member count
+// when known.
+func (tg *Telegram) membershipSummary(chatID ChatID) (int64, string, error) {
+	active, err := tg.db.CountActiveMembers(chatID, time.Now().Add(-activeMemberWindow))
+	if err != nil {
+		return 0, "", WrapError("failed to count active members", err)
+	}
+	total, ok, err := tg.db.GetChatSetting(chatID, chatMemberCountSetting)
+	if err != nil {
+		return 0, "", WrapError("failed to get chat member count", err)
+	}
+	if !ok {
+		total = "unknown"
+	}
+	return active, total, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// Size limits of chat memory entries, in characters.
+const (
+	chatMemoryMaxKey   = 40
+	chatMemoryMaxValue = 200
+)
+
+// chatMemoryTools are the tools letting the model edit the memory of a chat.
+var chatMemoryTools = []Tool{
+	{
+		Name:        "remember",
+		Description: "Store a short fact worth remembering about this chat, such as a running joke or a preference of the group, replacing the fact with the same key.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"key":   map[string]interface{}{"type": "string", "description": fmt.Sprintf("Short name of the fact, at most %d characters", chatMemoryMaxKey)},
+				"value": map[string]interface{}{"type": "string", "description": fmt.Sprintf("The fact, at most %d characters", chatMemoryMaxValue)},
+			},
+			"required": []string{"key", "value"},
+		},
+	},
+	{
+		Name:        "forget",
+		Description: "Remove a fact that is no longer true from the memory of this chat.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"key": map[string]interface{}{"type": "string", "description": "Name of the fact"},
+			},
+			"required": []string{"key"},
+		},
+	},
+}
+
+// storeChatMemory stores a memory entry of a chat, enforcing the size limits. Limit violations are
+// returned as an ArgsError.
+func (tg *Telegram) storeChatMemory(chatID ChatID, key, value string) error {
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	switch {
+	case key == "" || value == "":
+		return &ArgsError{Message: "key and value must not be empty"}
+	case len([]rune(key)) > chatMemoryMaxKey:
+		return &ArgsError{Message: fmt.Sprintf("key is longer than %d characters", chatMemoryMaxKey)}
+	case len([]rune(value)) > chatMemoryMaxValue:
+		return &ArgsError{Message: fmt.Sprintf("value is longer than %d characters", chatMemoryMaxValue)}
+	}
+
+	entries, err := tg.db.GetChatMemory(chatID)
+	if err != nil {
+		return WrapError("failed to get chat memory", err)
+	}
+	exists := false
+	for _, entry := range entries {
+		exists = exists || entry.Key == key
+	}
+	if !exists && len(entries) >= tg.config.ChatMemoryMaxEntries {
+		return &ArgsError{Message: fmt.Sprintf("memory is full with %d entries, forget one first", len(entries))}
+	}
+	return tg.db.SetChatMemory(chatID, key, value)
+}
+
+// chatMemoryInstruction returns the memory of a chat as a system instruction block. Provider-side
+// threading does not offer tools, so the memory is then read-only.
+func (tg *Telegram) chatMemoryInstruction(chatID ChatID) (string, error) {
+	entries, err := tg.db.GetChatMemory(chatID)
+	if err != nil {
+		return "", WrapError("failed to get chat memory", err)
+	}
+	var lines []string
+	if !tg.config.OpenAIThreading {
+		lines = append(lines, fmt.Sprintf("Use the remember and forget tools to keep up to %d short facts about this chat that help continuity, not things already in the conversation.", tg.config.ChatMemoryMaxEntries))
+	}
+	if len(entries) > 0 {
+		lines = append(lines, "What you remember about this chat:")
+		for _, entry := range entries {
+			lines = append(lines, fmt.Sprintf("- %s: %s", entry.Key, entry.Value))
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// chatMemoryToolHandler returns the handler of the memory tool calls made while answering in a
+// chat. Its results are meant for the model.
+func (tg *Telegram) chatMemoryToolHandler(chatID ChatID) func(name, arguments string) string {
+	return func(name, arguments string) string {
+		var args struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		}
+		err := json.Unmarshal([]byte(arguments), &args)
+		if err != nil {
+			return "error: invalid arguments"
+		}
+		log.Info().Int64("chat_id", int64(chatID)).Str("tool", name).Str("key", args.Key).Msg("Model edited chat memory")
+
+		switch name {
+		case "remember":
+			err = tg.storeChatMemory(chatID, args.Key, args.Value)
+		case "forget":
+			_, err = tg.db.DeleteChatMemory(chatID, args.Key)
+		default:
+			return "error: unknown tool"
+		}
+		var argsErr *ArgsError
+		if errors.As(err, &argsErr) {
+			return "error: " + argsErr.Message
+		}
+		if err != nil {
+			log.Warn().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to edit chat memory")
+			return "error: the memory could not be changed"
+		}
+		return "ok"
+	}
+}
+
+// handleMrlChatMemoryRequest processes the /mrl_chatmemory command, which shows or edits the
+// memory the model keeps about the chat.
+func (tg *Telegram) handleMrlChatMemoryRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_CHATMEMORY request")
+
+	usage := `/mrl_chatmemory [set <key> "<value>"|delete <key>|clear]`
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 3)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	switch {
+	case args.Arg(0) == "":
+		entries, err := tg.db.GetChatMemory(chatID)
+		if err != nil {
+			return WrapError("failed to get chat memory", err)
+		}
+		if len(entries) == 0 {
+			return tg.sendTelegramMessage(ctx, "Chat memory is empty.\nUsage: "+usage)
+		}
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("Chat memory (%d of %d):", len(entries), tg.config.ChatMemoryMaxEntries))
+		for _, entry := range entries {
+			sb.WriteString(fmt.Sprintf("\n- %s: %s", entry.Key, entry.Value))
+		}
+		return tg.sendTelegramMessage(ctx, sb.String())
+	case args.Arg(0) == "set" && len(args.Positional) == 3:
+		err = tg.storeChatMemory(chatID, args.Arg(1), args.Arg(2))
+		if err != nil {
+			return tg.sendUsage(ctx, usage, err)
+		}
+		return tg.sendTelegramMessage(ctx, "Remembered "+args.Arg(1)+".")
+	case args.Arg(0) == "delete" && len(args.Positional) == 2:
+		deleted, err := tg.db.DeleteChatMemory(chatID, args.Arg(1))
+		if err != nil {
+			return WrapError("failed to delete chat memory", err)
+		}
+		if !deleted {
+			return tg.sendTelegramMessage(ctx, "No memory entry named "+args.Arg(1)+".")
+		}
+		return tg.sendTelegramMessage(ctx, "Forgot "+args.Arg(1)+".")
+	case args.Arg(0) == "clear" && len(args.Positional) == 1:
+		err = tg.db.ClearChatMemory(chatID)
+		if err != nil {
+			return WrapError("failed to clear chat memory", err)
+		}
+		return tg.sendTelegramMessage(ctx, "Chat memory cleared.")
+	default:
+		return tg.sendUsage(ctx, usage, nil)
+	}
+}
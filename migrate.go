@@ -0,0 +1,372 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// migrationFiles holds the schema migrations, named <version>_<name>.up.sql and
+// <version>_<name>.down.sql. The first one creates the baseline schema of the bot.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration represents a versioned change to the database schema.
+type Migration struct {
+	Version int    // Version of the migration, applied in ascending order
+	Name    string // Name describing the change
+	Up      string // SQL applying the change
+	Down    string // SQL reverting the change
+}
+
+// loadMigrations reads the embedded migrations sorted by version, checking that every version
+// is unique and can be both applied and reverted.
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, WrapError("failed to read migrations", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), ".")
+		versionText, name, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionText)
+		if !ok || !found || err != nil || version <= 0 || (direction != "up" && direction != "down") {
+			return nil, WrapError(fmt.Sprintf("invalid migration file name %q", entry.Name()))
+		}
+		content, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, WrapError("failed to read migration", err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+		if migration.Name != name {
+			return nil, WrapError(fmt.Sprintf("migration version %d is used by %q and %q", version, migration.Name, name))
+		}
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if strings.TrimSpace(migration.Up) == "" || strings.TrimSpace(migration.Down) == "" {
+			return nil, WrapError(fmt.Sprintf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name))
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// initMigrationName is the name of the first migration, whose record tells databases created by
+// migrations apart from those created before them.
+const initMigrationName = "init"
+
+// Patterns of the statements creating schema objects in migrations, used to find the migrations
+// an existing database already reflects.
+var (
+	createObjectPattern = regexp.MustCompile(`(?i)CREATE\s+(?:TABLE|INDEX)\s+(\w+)`)
+	addColumnPattern    = regexp.MustCompile(`(?i)ALTER\s+TABLE\s+(\w+)\s+ADD\s+COLUMN\s+(\w+)`)
+)
+
+// prepareMigrations creates the table recording the applied migrations. Databases created before
+// migrations existed are stamped once, recording as applied the migrations whose tables, indexes,
+// and columns they already have, so only the missing ones are applied.
+func (db *DB) prepareMigrations() error {
+	_, err := db.conn.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return WrapError("failed to create migrations table", err)
+	}
+
+	var stamped int
+	err = db.conn.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = 1 AND name = ?", initMigrationName).Scan(&stamped)
+	if err != nil {
+		return WrapError("failed to check migrations table", err)
+	}
+	if stamped > 0 {
+		return nil
+	}
+	return db.stampExistingSchema()
+}
+
+// stampExistingSchema records as applied the migrations whose schema objects all exist,
+// replacing the records of a database that was not created by the current migrations.
+func (db *DB) stampExistingSchema() error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return WrapError("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM schema_migrations")
+	if err != nil {
+		return WrapError("failed to clear migrations table", err)
+	}
+	count := 0
+	for _, migration := range migrations {
+		applied, err := migrationReflected(tx, migration)
+		if err != nil {
+			return err
+		}
+		if !applied {
+			continue
+		}
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", migration.Version, migration.Name, time.Now())
+		if err != nil {
+			return WrapError("failed to stamp migration", err)
+		}
+		count++
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return WrapError("failed to commit transaction", err)
+	}
+	if count > 0 {
+		log.Info().Int("stamped", count).Msg("Stamped migrations of existing database")
+	}
+	return nil
+}
+
+// migrationReflected reports whether the database already has every table, index, and column a
+// migration creates. Migrations creating none are not reflected.
+func migrationReflected(tx *sql.Tx, migration Migration) (bool, error) {
+	objects := createObjectPattern.FindAllStringSubmatch(migration.Up, -1)
+	columns := addColumnPattern.FindAllStringSubmatch(migration.Up, -1)
+	if len(objects) == 0 && len(columns) == 0 {
+		return false, nil
+	}
+
+	for _, object := range objects {
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = ?", object[1]).Scan(&count)
+		if err != nil {
+			return false, WrapError("failed to look up schema object", err)
+		}
+		if count == 0 {
+			return false, nil
+		}
+	}
+	for _, column := range columns {
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", column[1], column[2]).Scan(&count)
+		if err != nil {
+			return false, WrapError("failed to look up column", err)
+		}
+		if count == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// GetAppliedMigrations retrieves the versions of the applied migrations and when they were
+// applied.
+func (db *DB) GetAppliedMigrations() (map[int]time.Time, error) {
+	rows, err := db.conn.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, WrapError("failed to retrieve applied migrations", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err := rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, WrapError("failed to scan applied migration", err)
+		}
+		applied[version] = appliedAt
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, WrapError("rows iteration error", err)
+	}
+	return applied, nil
+}
+
+// applyMigration applies or reverts a migration and records it in schema_migrations, both in
+// the same transaction.
+func (db *DB) applyMigration(migration Migration, up bool) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return WrapError("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	if up {
+		_, err = tx.Exec(migration.Up)
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", migration.Version, migration.Name, time.Now())
+		}
+	} else {
+		_, err = tx.Exec(migration.Down)
+		if err == nil {
+			_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+		}
+	}
+	if err != nil {
+		return WrapError(fmt.Sprintf("failed to migrate %d_%s", migration.Version, migration.Name), err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return WrapError("failed to commit transaction", err)
+	}
+	return nil
+}
+
+// MigrateUp applies the pending migrations in ascending order, returning how many were applied.
+func (db *DB) MigrateUp() (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	applied, err := db.GetAppliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	known := make(map[int]bool, len(migrations))
+	count := 0
+	for _, migration := range migrations {
+		known[migration.Version] = true
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err = db.applyMigration(migration, true)
+		if err != nil {
+			return count, err
+		}
+		log.Info().Int("version", migration.Version).Str("name", migration.Name).Msg("Applied database migration")
+		count++
+	}
+	for version := range applied {
+		if !known[version] {
+			log.Warn().Int("version", version).Msg("Database has a migration unknown to this release")
+		}
+	}
+	return count, nil
+}
+
+// MigrateDown reverts the given number of applied migrations, newest first, returning how many
+// were reverted.
+func (db *DB) MigrateDown(steps int) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	applied, err := db.GetAppliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		migration := migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		err = db.applyMigration(migration, false)
+		if err != nil {
+			return count, err
+		}
+		log.Info().Int("version", migration.Version).Str("name", migration.Name).Msg("Reverted database migration")
+		count++
+	}
+	return count, nil
+}
+
+// runMigrate runs the migrate subcommand, which reports, applies, or reverts the schema
+// migrations. The bot applies pending migrations when it starts, so reverting them is meant for
+// going back to an older release.
+func runMigrate(args []string) error {
+	usage := "usage: murailobot migrate status|up|down [-db path] [-steps n]"
+	if len(args) == 0 {
+		return WrapError(usage)
+	}
+
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	dbName := flags.String("db", defaultDBName(), "database to migrate")
+	steps := flags.Int("steps", 1, "number of migrations to revert")
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return WrapError("failed to parse flags", err)
+	}
+
+	conn, err := sql.Open("sqlite3", *dbName)
+	if err != nil {
+		return WrapError("failed to connect to database", err)
+	}
+	db := &DB{conn: conn}
+	defer db.Close()
+	err = db.prepareMigrations()
+	if err != nil {
+		return WrapError("failed to prepare database migrations", err)
+	}
+
+	switch args[0] {
+	case "status":
+		migrations, err := loadMigrations()
+		if err != nil {
+			return err
+		}
+		applied, err := db.GetAppliedMigrations()
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			state := "pending"
+			if appliedAt, ok := applied[migration.Version]; ok {
+				state = "applied " + appliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %-40s %s\n", migration.Version, migration.Name, state)
+		}
+		return nil
+	case "up":
+		count, err := db.MigrateUp()
+		fmt.Fprintf(os.Stderr, "Applied %d migrations\n", count)
+		return err
+	case "down":
+		if *steps <= 0 {
+			return WrapError(usage)
+		}
+		count, err := db.MigrateDown(*steps)
+		fmt.Fprintf(os.Stderr, "Reverted %d migrations\n", count)
+		return err
+	default:
+		return WrapError(usage)
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE chat_history;
+DROP TABLE user;
+DROP TABLE message_ref;
@@ -0,0 +1,19 @@
+-- Baseline schema of the bot, before migrations existed
+CREATE TABLE message_ref (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	message_id INTEGER NOT NULL,
+	chat_id INTEGER NOT NULL,
+	last_used DATETIME
+);
+CREATE TABLE user (
+	user_id INTEGER PRIMARY KEY,
+	last_used DATETIME
+);
+CREATE TABLE chat_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	user_name TEXT NOT NULL,
+	user_msg TEXT NOT NULL,
+	bot_msg TEXT NOT NULL,
+	last_used DATETIME
+);
@@ -0,0 +1,2 @@
+DROP TABLE chat_migration;
+DROP TABLE blocked_chat;
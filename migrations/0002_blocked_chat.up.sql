@@ -0,0 +1,10 @@
+-- Chats that blocked the bot, and chats migrated to a supergroup
+CREATE TABLE blocked_chat (
+	chat_id INTEGER PRIMARY KEY,
+	blocked_at DATETIME
+);
+CREATE TABLE chat_migration (
+	old_chat_id INTEGER PRIMARY KEY,
+	new_chat_id INTEGER NOT NULL,
+	migrated_at DATETIME
+);
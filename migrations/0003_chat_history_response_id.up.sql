@@ -0,0 +1,2 @@
+-- Provider-side response ID of a reply, continued by threaded calls
+ALTER TABLE chat_history ADD COLUMN response_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Chat a message was sent in
+ALTER TABLE chat_history ADD COLUMN chat_id INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- Language of the user who sent a message
+ALTER TABLE chat_history ADD COLUMN language_code TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Time taken to reply to a message
+ALTER TABLE chat_history ADD COLUMN latency_ms INTEGER NOT NULL DEFAULT 0;
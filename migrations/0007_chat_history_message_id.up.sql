@@ -0,0 +1,2 @@
+-- Telegram ID of the user message
+ALTER TABLE chat_history ADD COLUMN message_id INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE chat_history DROP COLUMN reply_to_message_id;
@@ -0,0 +1,2 @@
+-- Message the user message replied to
+ALTER TABLE chat_history ADD COLUMN reply_to_message_id INTEGER NOT NULL DEFAULT 0;
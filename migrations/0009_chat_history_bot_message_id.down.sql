@@ -0,0 +1 @@
+ALTER TABLE chat_history DROP COLUMN bot_message_id;
@@ -0,0 +1,2 @@
+-- Telegram ID of the bot reply
+ALTER TABLE chat_history ADD COLUMN bot_message_id INTEGER NOT NULL DEFAULT 0;
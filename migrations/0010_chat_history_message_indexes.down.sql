@@ -0,0 +1,2 @@
+DROP INDEX idx_chat_history_bot_message;
+DROP INDEX idx_chat_history_message;
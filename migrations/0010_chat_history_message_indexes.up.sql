@@ -0,0 +1,3 @@
+-- Looks up the entry of a user message or a bot reply, as reply chains do
+CREATE INDEX idx_chat_history_message ON chat_history (chat_id, message_id);
+CREATE INDEX idx_chat_history_bot_message ON chat_history (chat_id, bot_message_id);
@@ -0,0 +1,2 @@
+-- Looks up the history of a chat in a time range
+CREATE INDEX idx_chat_history_chat_time ON chat_history (chat_id, last_used);
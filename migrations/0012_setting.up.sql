@@ -0,0 +1,5 @@
+-- Settings of the bot
+CREATE TABLE setting (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
@@ -0,0 +1,10 @@
+-- Replies the model refused or left empty
+CREATE TABLE ai_refusal (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	prompt_hash TEXT NOT NULL,
+	finish_reason TEXT NOT NULL,
+	refusal TEXT NOT NULL,
+	created_at DATETIME
+);
@@ -0,0 +1,7 @@
+-- Settings of each chat
+CREATE TABLE chat_setting (
+	chat_id INTEGER NOT NULL,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL,
+	PRIMARY KEY (chat_id, key)
+);
@@ -0,0 +1,9 @@
+-- Messages forwarded out of their chat
+CREATE TABLE export_audit (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	source_chat_id INTEGER NOT NULL,
+	message_id INTEGER NOT NULL,
+	dest_chat_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	exported_at DATETIME
+);
@@ -0,0 +1 @@
+DROP TABLE flood_event;
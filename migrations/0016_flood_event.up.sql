@@ -0,0 +1,8 @@
+-- Users throttled for flooding
+CREATE TABLE flood_event (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	reason TEXT NOT NULL,
+	created_at DATETIME
+);
@@ -0,0 +1,7 @@
+-- Users the bot ignores in a chat
+CREATE TABLE blocked_user (
+	chat_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	blocked_at DATETIME,
+	PRIMARY KEY (chat_id, user_id)
+);
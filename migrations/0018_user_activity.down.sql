@@ -0,0 +1 @@
+DROP TABLE user_activity;
@@ -0,0 +1,10 @@
+-- Messages of each user per chat and week
+CREATE TABLE user_activity (
+	chat_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	week TEXT NOT NULL,
+	messages INTEGER NOT NULL DEFAULT 0,
+	first_seen DATETIME,
+	last_seen DATETIME,
+	PRIMARY KEY (chat_id, user_id, week)
+);
@@ -0,0 +1,2 @@
+-- Whether a reply was retracted and is left out of the context
+ALTER TABLE chat_history ADD COLUMN retracted INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,8 @@
+-- Facts the model remembers about each chat
+CREATE TABLE chat_memory (
+	chat_id INTEGER NOT NULL,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL,
+	updated_at DATETIME,
+	PRIMARY KEY (chat_id, key)
+);
@@ -0,0 +1 @@
+ALTER TABLE chat_history DROP COLUMN regenerated;
@@ -0,0 +1,2 @@
+-- Whether a reply replaced a regenerated one
+ALTER TABLE chat_history ADD COLUMN regenerated INTEGER NOT NULL DEFAULT 0;
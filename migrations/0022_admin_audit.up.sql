@@ -0,0 +1,11 @@
+-- Admin command invocations
+CREATE TABLE admin_audit (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	user_name TEXT NOT NULL DEFAULT '',
+	chat_id INTEGER NOT NULL,
+	command TEXT NOT NULL,
+	args TEXT NOT NULL DEFAULT '',
+	result TEXT NOT NULL,
+	created_at DATETIME
+);
@@ -0,0 +1,12 @@
+-- Quotes saved in each chat
+CREATE TABLE quote (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id INTEGER NOT NULL,
+	message_id INTEGER NOT NULL,
+	author_id INTEGER NOT NULL,
+	author_name TEXT NOT NULL,
+	text TEXT NOT NULL,
+	saved_by INTEGER NOT NULL,
+	sent_at DATETIME,
+	UNIQUE (chat_id, message_id)
+);
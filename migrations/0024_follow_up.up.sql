@@ -0,0 +1,10 @@
+-- Follow-ups scheduled by the model
+CREATE TABLE follow_up (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	message_id INTEGER NOT NULL,
+	note TEXT NOT NULL,
+	due_at DATETIME,
+	created_at DATETIME
+);
@@ -0,0 +1,6 @@
+-- Telegram API errors by kind
+CREATE TABLE api_error_count (
+	kind TEXT PRIMARY KEY,
+	count INTEGER NOT NULL DEFAULT 0,
+	last_seen DATETIME
+);
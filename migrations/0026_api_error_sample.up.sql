@@ -0,0 +1,8 @@
+-- Recent Telegram API errors
+CREATE TABLE api_error_sample (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	chat_id INTEGER NOT NULL,
+	description TEXT NOT NULL,
+	created_at DATETIME
+);
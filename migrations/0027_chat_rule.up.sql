@@ -0,0 +1,9 @@
+-- Rules answering matching requests in each chat
+CREATE TABLE chat_rule (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id INTEGER NOT NULL,
+	pattern TEXT NOT NULL,
+	action TEXT NOT NULL,
+	response TEXT NOT NULL,
+	created_at DATETIME
+);
@@ -0,0 +1,8 @@
+-- Membership status of users in each chat
+CREATE TABLE chat_member (
+	chat_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	updated_at DATETIME,
+	PRIMARY KEY (chat_id, user_id)
+);
@@ -0,0 +1 @@
+DROP TABLE drift_baseline;
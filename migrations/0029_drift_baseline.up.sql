@@ -0,0 +1,8 @@
+-- Baseline answers drift is measured against
+CREATE TABLE drift_baseline (
+	prompt TEXT PRIMARY KEY,
+	model TEXT NOT NULL,
+	response TEXT NOT NULL,
+	embedding TEXT NOT NULL,
+	created_at DATETIME
+);
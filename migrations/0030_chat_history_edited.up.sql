@@ -0,0 +1,2 @@
+-- Whether the user message was edited
+ALTER TABLE chat_history ADD COLUMN edited INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- When the user message was last edited
+ALTER TABLE chat_history ADD COLUMN edited_at DATETIME;
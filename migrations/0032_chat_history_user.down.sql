@@ -0,0 +1 @@
+DROP INDEX idx_chat_history_user;
@@ -0,0 +1,2 @@
+-- Looks up the messages of a user across chats, as the data export does
+CREATE INDEX idx_chat_history_user ON chat_history (user_id, last_used);
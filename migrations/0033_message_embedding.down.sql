@@ -0,0 +1,2 @@
+DROP INDEX idx_message_embedding_chat;
+DROP TABLE message_embedding;
@@ -0,0 +1,9 @@
+-- Embeddings of chat history entries, recalled into prompts by similarity to new messages
+CREATE TABLE message_embedding (
+	history_id INTEGER PRIMARY KEY,
+	chat_id INTEGER NOT NULL,
+	model TEXT NOT NULL,
+	embedding TEXT NOT NULL,
+	created_at DATETIME NOT NULL
+);
+CREATE INDEX idx_message_embedding_chat ON message_embedding (chat_id, model);
@@ -0,0 +1,2 @@
+-- Note telling the model which message the user message replied to, as sent in its prompt
+ALTER TABLE chat_history ADD COLUMN reply_context TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// chatModelSetting is the chat setting holding the model chosen for the chat.
+const chatModelSetting = "model"
+
+// chatServedModelSetting is the chat setting holding the model that last answered in the chat.
+const chatServedModelSetting = "served_model"
+
+// allowedModels returns the models chats may use, the configured default first.
+func (tg *Telegram) allowedModels() []string {
+	models := []string{tg.config.OpenAIModel}
+	for _, model := range tg.config.OpenAIModels {
+		model = strings.TrimSpace(model)
+		if model != "" && !containsModel(models, model) {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// containsModel reports whether a model is in the given list.
+func containsModel(models []string, model string) bool {
+	for _, allowed := range models {
+		if model == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// chatModel returns the model answering in a chat. A chosen model that is no longer allowed
+// falls back to the configured default.
+func (tg *Telegram) chatModel(chatID ChatID) string {
+	model, ok, err := tg.db.GetChatSetting(chatID, chatModelSetting)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to get chat model")
+	}
+	if !ok {
+		return tg.config.OpenAIModel
+	}
+	if !containsModel(tg.allowedModels(), model) {
+		log.Warn().Int64("chat_id", int64(chatID)).Str("model", model).Msg("Chat model is no longer allowed, using default")
+		return tg.config.OpenAIModel
+	}
+	return model
+}
+
+// recordServedModel remembers the model that answered in a chat. When it differs from the model
+// that answered before, the change is recorded in the analytics and announced to the admin, so
+// changes in the bot's behavior can be traced back to it.
+func (tg *Telegram) recordServedModel(chatID ChatID, model string) {
+	previous, ok, err := tg.db.GetChatSetting(chatID, chatServedModelSetting)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to get served model")
+		return
+	}
+	if ok && previous == model {
+		return
+	}
+	err = tg.db.SetChatSetting(chatID, chatServedModelSetting, model)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to set served model")
+		return
+	}
+	if !ok {
+		return
+	}
+
+	log.Info().Int64("chat_id", int64(chatID)).Str("from", previous).Str("to", model).Msg("Serving model changed")
+	tg.analytics.Record(AnalyticsModelChanged, chatID, 0, map[string]interface{}{
+		"from": previous,
+		"to":   model,
+	})
+	err = tg.notifyAdmin(fmt.Sprintf("Chat %d is now answered by %s (was %s).", chatID, model, previous))
+	if err != nil {
+		log.Warn().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to announce model change")
+	}
+}
+
+// handleMrlModelRequest processes the /mrl_model command, which shows the model of the chat or
+// chooses one of the allowed models, with "default" restoring the configured one.
+func (tg *Telegram) handleMrlModelRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_MODEL request")
+
+	usage := "/mrl_model [<model>|default]"
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 1)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	models := tg.allowedModels()
+	arg := args.Arg(0)
+	switch {
+	case arg == "":
+		return tg.sendTelegramMessage(ctx, fmt.Sprintf("Model: %s\nAllowed models: %s", tg.chatModel(chatID), strings.Join(models, ", ")))
+	case arg == "default":
+		err = tg.db.DeleteChatSetting(chatID, chatModelSetting)
+		if err != nil {
+			return WrapError("failed to delete chat model", err)
+		}
+		return tg.sendTelegramMessage(ctx, "Model set to the default, "+tg.config.OpenAIModel+".")
+	case !containsModel(models, arg):
+		return tg.sendTelegramMessage(ctx, fmt.Sprintf("Model %q is not allowed. Allowed models: %s", arg, strings.Join(models, ", ")))
+	}
+
+	err = tg.db.SetChatSetting(chatID, chatModelSetting, arg)
+	if err != nil {
+		return WrapError("failed to set chat model", err)
+	}
+	return tg.sendTelegramMessage(ctx, "Model set to "+arg+".")
+}
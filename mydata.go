@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// myDataSettingPrefix prefixes the settings holding the day each user last exported their data.
+const myDataSettingPrefix = "mydata_exported."
+
+// UserDataExport is everything stored about a user, as sent by /mrl_mydata.
+type UserDataExport struct {
+	UserID     UserID               // User the data is about
+	ExportedAt time.Time            // When the export was compiled
+	Messages   []UserDataMessage    // Messages stored in the chat history
+	Activity   []UserDataActivity   // Activity in each chat
+	Quotes     []Quote              // Quotes the user wrote or saved
+	FollowUps  []FollowUp           // Follow-ups scheduled from the user's messages
+	Refusals   []Refusal            // Model refusals of the user's requests
+	Membership []UserDataMembership // Membership state in each chat
+}
+
+// UserDataMessage is a message of the user stored in the chat history, with the bot's reply.
+type UserDataMessage struct {
+	ChatID    ChatID    // Chat the message was sent in
+	MessageID MessageID // ID of the message
+	SentAt    time.Time // When the message was stored
+	Text      string    // Text of the message
+	Reply     string    // Reply of the bot, if any
+}
+
+// UserDataActivity is the activity of the user in a chat.
+type UserDataActivity struct {
+	ChatID    ChatID         // Chat the activity belongs to
+	FirstSeen time.Time      // First recorded message
+	LastSeen  time.Time      // Last recorded message
+	Messages  int64          // Number of recorded messages
+	Weeks     map[string]int // Number of messages by ISO week
+}
+
+// UserDataMembership is the membership state of the user in a chat.
+type UserDataMembership struct {
+	ChatID    ChatID    // Chat the state belongs to
+	Status    string    // member or left
+	UpdatedAt time.Time // When the state was recorded
+}
+
+// exportUserData compiles everything stored about a user.
+func exportUserData(db *DB, userID UserID) (*UserDataExport, error) {
+	export := &UserDataExport{UserID: userID, ExportedAt: time.Now()}
+
+	history, err := db.GetUserChatHistory(userID)
+	if err != nil {
+		return nil, WrapError("failed to get user chat history", err)
+	}
+	for _, entry := range history {
+		export.Messages = append(export.Messages, UserDataMessage{
+			ChatID:    entry.ChatID,
+			MessageID: entry.MessageID,
+			SentAt:    entry.LastUsed,
+			Text:      entry.UserMsg,
+			Reply:     entry.BotMsg,
+		})
+	}
+
+	chatIDs, err := db.GetUserChats(userID)
+	if err != nil {
+		return nil, WrapError("failed to get user chats", err)
+	}
+	for _, chatID := range chatIDs {
+		activity, ok, err := db.GetUserActivity(chatID, userID)
+		if err != nil {
+			return nil, WrapError("failed to get user activity", err)
+		}
+		if ok {
+			export.Activity = append(export.Activity, UserDataActivity{
+				ChatID:    chatID,
+				FirstSeen: activity.FirstSeen,
+				LastSeen:  activity.LastSeen,
+				Messages:  activity.Messages,
+				Weeks:     activity.Weeks,
+			})
+		}
+	}
+
+	export.Quotes, err = db.GetUserQuotes(userID)
+	if err != nil {
+		return nil, WrapError("failed to get user quotes", err)
+	}
+	export.FollowUps, err = db.GetUserFollowUps(userID)
+	if err != nil {
+		return nil, WrapError("failed to get user follow-ups", err)
+	}
+	export.Refusals, err = db.GetUserRefusals(userID)
+	if err != nil {
+		return nil, WrapError("failed to get user refusals", err)
+	}
+	export.Membership, err = db.GetUserMembership(userID)
+	if err != nil {
+		return nil, WrapError("failed to get user membership", err)
+	}
+	return export, nil
+}
+
+// handleMrlMyDataRequest processes the /mrl_mydata command, which sends the requesting user
+// everything stored about them as a JSON file in a private message, at most once a day.
+func (tg *Telegram) handleMrlMyDataRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_MYDATA request")
+
+	userID := UserID(ctx.EffectiveMessage.From.Id)
+	today := time.Now().Format(time.DateOnly)
+	key := fmt.Sprintf("%s%d", myDataSettingPrefix, userID)
+	exported, _, err := tg.db.GetSetting(key)
+	if err != nil {
+		return WrapError("failed to get last data export", err)
+	}
+	if exported == today && userID != tg.config.TelegramAdminUID {
+		return tg.sendTelegramMessage(ctx, "Você já pediu seus dados hoje. Tente novamente amanhã.")
+	}
+
+	export, err := exportUserData(tg.db, userID)
+	if err != nil {
+		return WrapError("failed to export user data", err)
+	}
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return WrapError("failed to encode user data", err)
+	}
+
+	name := fmt.Sprintf("mydata-%d-%s.json", userID, time.Now().Format("20060102"))
+	_, err = tg.bot.SendDocument(int64(userID), gotgbot.NamedFile{FileName: name, File: bytes.NewReader(data)}, &gotgbot.SendDocumentOpts{
+		Caption: fmt.Sprintf("Seus dados: %d mensagens, %d citações, %d lembretes.", len(export.Messages), len(export.Quotes), len(export.FollowUps)),
+	})
+	if err != nil {
+		log.Warn().Err(err).Int64("user_id", int64(userID)).Msg("Failed to send user data")
+		return tg.sendTelegramMessage(ctx, "Não consegui te enviar uma mensagem privada. Inicie uma conversa comigo e tente novamente.")
+	}
+
+	err = tg.db.SetSetting(key, today)
+	if err != nil {
+		return WrapError("failed to store data export day", err)
+	}
+	log.Info().Int64("user_id", int64(userID)).Int("messages", len(export.Messages)).Msg("Sent user data export")
+	if ctx.EffectiveMessage.Chat.Id != int64(userID) {
+		return tg.sendTelegramMessage(ctx, "Enviei seus dados no privado.")
+	}
+	return nil
+}
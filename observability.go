@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// aiLatencyBuckets are the upper bounds, in seconds, of the OpenAI request latency histogram.
+var aiLatencyBuckets = []float64{0.25, 0.5, 1, 2.5, 5, 10, 20, 40, 80}
+
+// healthCheckTimeout bounds each dependency check of the health endpoint.
+const healthCheckTimeout = 10 * time.Second
+
+// histogram counts observations in cumulative buckets, as Prometheus histograms do.
+type histogram struct {
+	counts []int64 // Observations at or below each bucket of aiLatencyBuckets
+	count  int64   // Number of observations
+	sum    float64 // Sum of the observations
+}
+
+// observe adds an observation to the histogram.
+func (h *histogram) observe(value float64) {
+	for i, bound := range aiLatencyBuckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// Metrics counts the activity of the bot since it started, exposed in the Prometheus text format.
+// Counters are keyed by their formatted label set. A nil *Metrics counts nothing.
+type Metrics struct {
+	mu        sync.Mutex
+	started   time.Time             // When the bot started
+	events    map[string]int64      // Analytics events by event
+	tokens    map[string]int64      // Estimated tokens by model and kind
+	jobs      map[string]int64      // Background job runs by job
+	errors    map[string]int64      // Errors by source and kind
+	aiLatency map[string]*histogram // Latency of OpenAI requests by endpoint
+}
+
+// NewMetrics creates empty metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		started:   time.Now(),
+		events:    make(map[string]int64),
+		tokens:    make(map[string]int64),
+		jobs:      make(map[string]int64),
+		errors:    make(map[string]int64),
+		aiLatency: make(map[string]*histogram),
+	}
+}
+
+// metricInt converts a number from analytics event data.
+func metricInt(value interface{}) int64 {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	default:
+		return 0
+	}
+}
+
+// observeEvent counts an analytics event, along with the tokens and job runs it reports.
+func (metrics *Metrics) observeEvent(event string, data map[string]interface{}) {
+	if metrics == nil {
+		return
+	}
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	metrics.events[promLabels("event", event)]++
+	switch event {
+	case AnalyticsTokensUsed:
+		model, _ := data["model"].(string)
+		metrics.tokens[promLabels("model", model, "kind", "prompt")] += metricInt(data["prompt_tokens_est"])
+		metrics.tokens[promLabels("model", model, "kind", "completion")] += metricInt(data["completion_tokens_est"])
+	case AnalyticsJobRun:
+		job, _ := data["job"].(string)
+		metrics.jobs[promLabels("job", job)]++
+	}
+}
+
+// observeAIRequest records the latency of an OpenAI request to a URL, counting it as an error
+// when it failed or was answered with an error status.
+func (metrics *Metrics) observeAIRequest(url string, latency time.Duration, status int, err error) {
+	if metrics == nil {
+		return
+	}
+	endpoint := strings.TrimPrefix(url, "https://api.openai.com/v1/")
+	metrics.mu.Lock()
+	h, ok := metrics.aiLatency[endpoint]
+	if !ok {
+		h = &histogram{counts: make([]int64, len(aiLatencyBuckets))}
+		metrics.aiLatency[endpoint] = h
+	}
+	h.observe(latency.Seconds())
+	metrics.mu.Unlock()
+
+	switch {
+	case err != nil:
+		metrics.recordError("openai", "transport")
+	case status >= http.StatusBadRequest:
+		metrics.recordError("openai", fmt.Sprintf("http_%d", status))
+	}
+}
+
+// recordError counts an error of the given source and kind.
+func (metrics *Metrics) recordError(source, kind string) {
+	if metrics == nil {
+		return
+	}
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	metrics.errors[promLabels("source", source, "kind", kind)]++
+}
+
+// promLabelEscaper escapes label values in the Prometheus text format.
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabels formats label names and values as a Prometheus label set.
+func promLabels(pairs ...string) string {
+	var labels []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, pairs[i], promLabelEscaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// writeCounter writes a counter with its values sorted by label set, so the output does not
+// shuffle between scrapes.
+func writeCounter(w io.Writer, name, help string, values map[string]int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	labels := make([]string, 0, len(values))
+	for label := range values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		fmt.Fprintf(w, "%s%s %d\n", name, label, values[label])
+	}
+}
+
+// write writes the metrics in the Prometheus text format.
+func (metrics *Metrics) write(w io.Writer) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP murailobot_build_info Version of the running bot.")
+	fmt.Fprintln(w, "# TYPE murailobot_build_info gauge")
+	fmt.Fprintf(w, "murailobot_build_info%s 1\n", promLabels("version", version))
+	fmt.Fprintln(w, "# HELP murailobot_uptime_seconds Seconds since the bot started.")
+	fmt.Fprintln(w, "# TYPE murailobot_uptime_seconds gauge")
+	fmt.Fprintf(w, "murailobot_uptime_seconds %g\n", time.Since(metrics.started).Seconds())
+
+	writeCounter(w, "murailobot_events_total", "Processed events, such as answered mentions and saved messages.", metrics.events)
+	writeCounter(w, "murailobot_tokens_total", "Estimated tokens of model calls.", metrics.tokens)
+	writeCounter(w, "murailobot_job_runs_total", "Finished runs of background jobs.", metrics.jobs)
+	writeCounter(w, "murailobot_errors_total", "Errors by source and kind.", metrics.errors)
+
+	fmt.Fprintln(w, "# HELP murailobot_openai_request_duration_seconds Latency of OpenAI requests until their response headers.")
+	fmt.Fprintln(w, "# TYPE murailobot_openai_request_duration_seconds histogram")
+	endpoints := make([]string, 0, len(metrics.aiLatency))
+	for endpoint := range metrics.aiLatency {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		h := metrics.aiLatency[endpoint]
+		for i, bound := range aiLatencyBuckets {
+			fmt.Fprintf(w, "murailobot_openai_request_duration_seconds_bucket%s %d\n", promLabels("endpoint", endpoint, "le", fmt.Sprintf("%g", bound)), h.counts[i])
+		}
+		fmt.Fprintf(w, "murailobot_openai_request_duration_seconds_bucket%s %d\n", promLabels("endpoint", endpoint, "le", "+Inf"), h.count)
+		fmt.Fprintf(w, "murailobot_openai_request_duration_seconds_sum%s %g\n", promLabels("endpoint", endpoint), h.sum)
+		fmt.Fprintf(w, "murailobot_openai_request_duration_seconds_count%s %d\n", promLabels("endpoint", endpoint), h.count)
+	}
+}
+
+// ObservabilityServer serves the health check and the metrics of the bot over HTTP.
+type ObservabilityServer struct {
+	server  *http.Server
+	db      *DB
+	bot     *gotgbot.Bot
+	oai     *OpenAI
+	metrics *Metrics
+}
+
+// NewObservabilityServer creates a server listening on the given address with /healthz, which
+// checks the database, Telegram, and OpenAI, and /metrics.
+func NewObservabilityServer(addr string, db *DB, tg *Telegram, oai *OpenAI, metrics *Metrics) *ObservabilityServer {
+	observability := &ObservabilityServer{db: db, bot: tg.bot, oai: oai, metrics: metrics}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", observability.handleHealthz)
+	mux.HandleFunc("/metrics", observability.handleMetrics)
+	observability.server = &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return observability
+}
+
+// Start listens on the configured address and serves requests in the background.
+func (observability *ObservabilityServer) Start() error {
+	listener, err := net.Listen("tcp", observability.server.Addr)
+	if err != nil {
+		return WrapError("failed to listen for observability requests", err)
+	}
+	go func() {
+		err := observability.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Error().Err(err).Msg("Observability server failed")
+		}
+	}()
+	log.Info().Str("addr", listener.Addr().String()).Msg("Started observability server")
+	return nil
+}
+
+// Stop stops the server, waiting for the requests being served.
+func (observability *ObservabilityServer) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	err := observability.server.Shutdown(ctx)
+	if err != nil {
+		return WrapError("failed to stop observability server", err)
+	}
+	return nil
+}
+
+// handleHealthz checks the dependencies of the bot, answering 200 when all of them are reachable
+// and 503 otherwise, with the outcome of each check.
+func (observability *ObservabilityServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func() error{
+		"database": observability.db.Ping,
+		"telegram": func() error {
+			_, err := observability.bot.GetMe(&gotgbot.GetMeOpts{RequestOpts: &gotgbot.RequestOpts{Timeout: healthCheckTimeout}})
+			return err
+		},
+		"openai": observability.oai.Ping,
+	}
+
+	status := http.StatusOK
+	results := make(map[string]string, len(checks))
+	for name, check := range checks {
+		err := check()
+		if err != nil {
+			log.Warn().Err(err).Str("check", name).Msg("Health check failed")
+			results[name] = err.Error()
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		results[name] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(results)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to write health check response")
+	}
+}
+
+// handleMetrics writes the metrics in the Prometheus text format.
+func (observability *ObservabilityServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	observability.metrics.write(w)
+}
@@ -1,20 +1,27 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"strings"
+	"time"
 )
 
 // OpenAI encapsulates the logic for interacting with the OpenAI API.
 type OpenAI struct {
-	Token       string  // OpenAI API token
-	Instruction string  // Instruction for OpenAI
-	Model       string  // Model name for OpenAI
-	Temperature float32 // Temperature setting for OpenAI
-	TopP        float32 // TopP setting for OpenAI
+	Token              string   // OpenAI API token
+	Instruction        string   // Instruction for OpenAI
+	Model              string   // Model name for OpenAI
+	EmbeddingModel     string   // Embedding model name for OpenAI
+	TranscriptionModel string   // Transcription model name for OpenAI
+	Temperature        float32  // Temperature setting for OpenAI
+	TopP               float32  // TopP setting for OpenAI
+	Metrics            *Metrics // Metrics of the requests, none if nil
 }
 
 // NewOpenAI creates a new OpenAI client.
@@ -23,16 +30,19 @@ func NewOpenAI(config *Config) (*OpenAI, error) {
 		return nil, WrapError("invalid OpenAI configuration")
 	}
 	return &OpenAI{
-		Token:       config.OpenAIToken,
-		Instruction: config.OpenAIInstruction,
-		Model:       config.OpenAIModel,
-		Temperature: config.OpenAITemperature,
-		TopP:        config.OpenAITopP,
+		Token:              config.OpenAIToken,
+		Instruction:        config.OpenAIInstruction,
+		Model:              config.OpenAIModel,
+		EmbeddingModel:     config.OpenAIEmbeddingModel,
+		TranscriptionModel: config.OpenAITranscriptionModel,
+		Temperature:        config.OpenAITemperature,
+		TopP:               config.OpenAITopP,
 	}, nil
 }
 
-// sendRequest sends a request to the OpenAI API and returns the response body.
-func (client *OpenAI) sendRequest(body map[string]interface{}) ([]byte, error) {
+// postRequest sends a request to an OpenAI API endpoint and returns the response, whose body the
+// caller must close.
+func (client *OpenAI) postRequest(url string, body map[string]interface{}) (*http.Response, error) {
 	// Marshal the request body to JSON
 	reqBody, err := json.Marshal(body)
 	if err != nil {
@@ -40,7 +50,7 @@ func (client *OpenAI) sendRequest(body map[string]interface{}) ([]byte, error) {
 	}
 
 	// Create a new HTTP request
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(reqBody))
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return nil, WrapError("failed to create request", err)
 	}
@@ -49,10 +59,42 @@ func (client *OpenAI) sendRequest(body map[string]interface{}) ([]byte, error) {
 
 	// Send the HTTP request
 	httpClient := &http.Client{}
+	start := time.Now()
 	resp, err := httpClient.Do(req)
 	if err != nil {
+		client.Metrics.observeAIRequest(url, time.Since(start), 0, err)
 		return nil, WrapError("failed to send request", err)
 	}
+	client.Metrics.observeAIRequest(url, time.Since(start), resp.StatusCode, nil)
+	return resp, nil
+}
+
+// Ping checks that the OpenAI API is reachable and accepts the token, by retrieving the
+// configured model.
+func (client *OpenAI) Ping() error {
+	req, err := http.NewRequest("GET", "https://api.openai.com/v1/models/"+client.Model, nil)
+	if err != nil {
+		return WrapError("failed to create request", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", client.Token))
+	httpClient := &http.Client{Timeout: healthCheckTimeout}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return WrapError("failed to reach OpenAI", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return WrapError(fmt.Sprintf("OpenAI answered with status %d", resp.StatusCode))
+	}
+	return nil
+}
+
+// sendRequest sends a request to an OpenAI API endpoint and returns the response body.
+func (client *OpenAI) sendRequest(url string, body map[string]interface{}) ([]byte, error) {
+	resp, err := client.postRequest(url, body)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close()
 
 	// Read the response body
@@ -64,39 +106,421 @@ func (client *OpenAI) sendRequest(body map[string]interface{}) ([]byte, error) {
 	return respBody, nil
 }
 
+// RefusalError is returned when the model refuses to answer or returns no content.
+type RefusalError struct {
+	FinishReason string // Reason the model stopped generating
+	Refusal      string // Refusal message of the model, if any
+}
+
+// Error implements the error interface for RefusalError.
+func (re *RefusalError) Error() string {
+	if re.Refusal != "" {
+		return fmt.Sprintf("model refused to answer (finish reason %q): %s", re.FinishReason, re.Refusal)
+	}
+	return fmt.Sprintf("model returned an empty response (finish reason %q)", re.FinishReason)
+}
+
+// CallOptions overrides the client defaults for a single request.
+type CallOptions struct {
+	MaxTokens   int                                 // Maximum number of tokens to generate, unlimited when zero
+	Temperature *float32                            // Temperature setting, the client default when nil
+	Model       string                              // Model name, the client default when empty
+	Tools       []Tool                              // Functions the model may call, only offered by CallWithOptions
+	HandleTool  func(name, arguments string) string // Runs a tool call and returns its result
+}
+
+// Tool describes a function the model may call.
+type Tool struct {
+	Name        string                 // Name of the function
+	Description string                 // What the function does and when to call it
+	Parameters  map[string]interface{} // JSON schema of the arguments
+}
+
+// maxToolRounds is the number of times the model may call tools before it has to answer.
+const maxToolRounds = 3
+
+// toolCall is a function call requested by the model.
+type toolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// model returns the model to use with the given options.
+func (client *OpenAI) model(opts CallOptions) string {
+	if opts.Model != "" {
+		return opts.Model
+	}
+	return client.Model
+}
+
+// temperature returns the temperature to use with the given options.
+func (client *OpenAI) temperature(opts CallOptions) float32 {
+	if opts.Temperature != nil {
+		return *opts.Temperature
+	}
+	return client.Temperature
+}
+
 // Call sends a request to the OpenAI API and returns the response.
 func (client *OpenAI) Call(messages []map[string]string) (string, error) {
+	return client.CallWithOptions(messages, CallOptions{})
+}
+
+// CallWithOptions sends a request to the OpenAI API using the given options and returns the response.
+// When tools are given, the tool calls of the model are run with HandleTool and their results sent
+// back, until the model answers or the tool rounds are used up.
+func (client *OpenAI) CallWithOptions(messages []map[string]string, opts CallOptions) (string, error) {
+	input := make([]interface{}, 0, len(messages))
+	for _, message := range messages {
+		input = append(input, message)
+	}
+
+	for round := 0; ; round++ {
+		// Prepare the request body
+		requestBody := map[string]interface{}{
+			"model":       client.model(opts),
+			"temperature": client.temperature(opts),
+			"top_p":       client.TopP,
+			"messages":    input,
+		}
+		if opts.MaxTokens > 0 {
+			requestBody["max_tokens"] = opts.MaxTokens
+		}
+		if len(opts.Tools) > 0 && opts.HandleTool != nil {
+			requestBody["tools"] = toolDefinitions(opts.Tools)
+			if round >= maxToolRounds {
+				requestBody["tool_choice"] = "none"
+			}
+		}
+
+		// Send the request
+		respBody, err := client.sendRequest("https://api.openai.com/v1/chat/completions", requestBody)
+		if err != nil {
+			return "", WrapError("call to OpenAI API failed", err)
+		}
+
+		// Parse the response
+		var response struct {
+			Choices []struct {
+				Message struct {
+					Content   string     `json:"content"`
+					Refusal   string     `json:"refusal"`
+					ToolCalls []toolCall `json:"tool_calls"`
+				} `json:"message"`
+				FinishReason string `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		err = json.Unmarshal(respBody, &response)
+		if err != nil {
+			return "", WrapError("failed to unmarshal response", err)
+		}
+		if len(response.Choices) == 0 {
+			return "", WrapError("unexpected message format: no choices in response")
+		}
+
+		// Run the requested tools and send their results back
+		choice := response.Choices[0]
+		if len(choice.Message.ToolCalls) > 0 && opts.HandleTool != nil {
+			input = append(input, map[string]interface{}{
+				"role":       "assistant",
+				"content":    choice.Message.Content,
+				"tool_calls": choice.Message.ToolCalls,
+			})
+			for _, call := range choice.Message.ToolCalls {
+				input = append(input, map[string]interface{}{
+					"role":         "tool",
+					"tool_call_id": call.ID,
+					"content":      opts.HandleTool(call.Function.Name, call.Function.Arguments),
+				})
+			}
+			continue
+		}
+
+		// Extract the message content
+		if choice.Message.Refusal != "" || strings.TrimSpace(choice.Message.Content) == "" {
+			return "", &RefusalError{FinishReason: choice.FinishReason, Refusal: choice.Message.Refusal}
+		}
+		return choice.Message.Content, nil
+	}
+}
+
+// toolDefinitions returns the tools in the format of the Chat Completions API.
+func toolDefinitions(tools []Tool) []map[string]interface{} {
+	definitions := make([]map[string]interface{}, 0, len(tools))
+	for _, tool := range tools {
+		definitions = append(definitions, map[string]interface{}{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        tool.Name,
+				"description": tool.Description,
+				"parameters":  tool.Parameters,
+			},
+		})
+	}
+	return definitions
+}
+
+// CallStream sends a request to the OpenAI API using the given options and streams the response,
+// calling onDelta with the content received so far as it arrives. Tools are not offered. It
+// returns the complete content.
+func (client *OpenAI) CallStream(messages []map[string]string, opts CallOptions, onDelta func(content string)) (string, error) {
 	// Prepare the request body
 	requestBody := map[string]interface{}{
-		"model":       client.Model,
-		"temperature": client.Temperature,
+		"model":       client.model(opts),
+		"temperature": client.temperature(opts),
 		"top_p":       client.TopP,
 		"messages":    messages,
+		"stream":      true,
+	}
+	if opts.MaxTokens > 0 {
+		requestBody["max_tokens"] = opts.MaxTokens
 	}
 
 	// Send the request
-	respBody, err := client.sendRequest(requestBody)
+	resp, err := client.postRequest("https://api.openai.com/v1/chat/completions", requestBody)
 	if err != nil {
 		return "", WrapError("call to OpenAI API failed", err)
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return "", WrapError(fmt.Sprintf("OpenAI API returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody))))
+	}
+
+	// Read the server-sent events until the stream is done
+	var content, refusal strings.Builder
+	var finishReason string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+					Refusal string `json:"refusal"`
+				} `json:"delta"`
+				FinishReason string `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		err = json.Unmarshal([]byte(data), &chunk)
+		if err != nil {
+			return "", WrapError("failed to unmarshal stream chunk", err)
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		choice := chunk.Choices[0]
+		if choice.FinishReason != "" {
+			finishReason = choice.FinishReason
+		}
+		refusal.WriteString(choice.Delta.Refusal)
+		if choice.Delta.Content != "" {
+			content.WriteString(choice.Delta.Content)
+			onDelta(content.String())
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return "", WrapError("failed to read response stream", err)
+	}
+
+	// Check the message content
+	if refusal.Len() > 0 || strings.TrimSpace(content.String()) == "" {
+		return "", &RefusalError{FinishReason: finishReason, Refusal: refusal.String()}
+	}
+	return content.String(), nil
+}
+
+// Embed returns the embeddings of the given texts, in the same order, computed in a single request.
+func (client *OpenAI) Embed(texts []string) ([][]float64, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	// Send the request
+	respBody, err := client.sendRequest("https://api.openai.com/v1/embeddings", map[string]interface{}{
+		"model": client.EmbeddingModel,
+		"input": texts,
+	})
+	if err != nil {
+		return nil, WrapError("call to OpenAI Embeddings API failed", err)
+	}
+
+	// Parse the response
+	var response struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	err = json.Unmarshal(respBody, &response)
+	if err != nil {
+		return nil, WrapError("failed to unmarshal response", err)
+	}
+	if response.Error != nil {
+		return nil, WrapError(fmt.Sprintf("OpenAI Embeddings API error: %s", response.Error.Message))
+	}
+	if len(response.Data) != len(texts) {
+		return nil, WrapError(fmt.Sprintf("unexpected number of embeddings: got %d, want %d", len(response.Data), len(texts)))
+	}
+
+	embeddings := make([][]float64, len(texts))
+	for _, data := range response.Data {
+		if data.Index < 0 || data.Index >= len(texts) {
+			return nil, WrapError(fmt.Sprintf("unexpected embedding index %d", data.Index))
+		}
+		embeddings[data.Index] = data.Embedding
+	}
+	return embeddings, nil
+}
+
+// Transcribe sends an audio file to the OpenAI transcription API and returns its text.
+func (client *OpenAI) Transcribe(fileName string, audio []byte) (string, error) {
+	// Build the multipart form with the model and the file
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	err := form.WriteField("model", client.TranscriptionModel)
+	if err != nil {
+		return "", WrapError("failed to write model field", err)
+	}
+	part, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		return "", WrapError("failed to create file field", err)
+	}
+	_, err = part.Write(audio)
+	if err != nil {
+		return "", WrapError("failed to write file field", err)
+	}
+	err = form.Close()
+	if err != nil {
+		return "", WrapError("failed to close form", err)
+	}
+
+	// Send the request
+	req, err := http.NewRequest("POST", "https://api.openai.com/v1/audio/transcriptions", &body)
+	if err != nil {
+		return "", WrapError("failed to create request", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", client.Token))
+	httpClient := &http.Client{}
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		client.Metrics.observeAIRequest(req.URL.String(), time.Since(start), 0, err)
+		return "", WrapError("call to OpenAI transcription API failed", err)
+	}
+	client.Metrics.observeAIRequest(req.URL.String(), time.Since(start), resp.StatusCode, nil)
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", WrapError("failed to read response body", err)
+	}
 
 	// Parse the response
 	var response struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
+		Text  string `json:"text"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
 	}
 	err = json.Unmarshal(respBody, &response)
 	if err != nil {
 		return "", WrapError("failed to unmarshal response", err)
 	}
+	if response.Error != nil {
+		return "", WrapError(fmt.Sprintf("OpenAI transcription API error: %s", response.Error.Message))
+	}
+	return strings.TrimSpace(response.Text), nil
+}
+
+// CallThreaded sends a request to the OpenAI Responses API, continuing the conversation stored
+// on the provider side under previousResponseID when it is set. When previousResponseID is set
+// only the new messages need to be sent. Tools are not offered. It returns the response content
+// and its ID.
+func (client *OpenAI) CallThreaded(instruction string, messages []map[string]string, previousResponseID string, opts CallOptions) (string, string, error) {
+	// Prepare the request body
+	requestBody := map[string]interface{}{
+		"model":        client.model(opts),
+		"temperature":  client.temperature(opts),
+		"top_p":        client.TopP,
+		"instructions": instruction,
+		"input":        messages,
+		"store":        true,
+	}
+	if previousResponseID != "" {
+		requestBody["previous_response_id"] = previousResponseID
+	}
+	if opts.MaxTokens > 0 {
+		requestBody["max_output_tokens"] = opts.MaxTokens
+	}
+
+	// Send the request
+	respBody, err := client.sendRequest("https://api.openai.com/v1/responses", requestBody)
+	if err != nil {
+		return "", "", WrapError("call to OpenAI Responses API failed", err)
+	}
+
+	// Parse the response
+	var response struct {
+		ID                string `json:"id"`
+		Status            string `json:"status"`
+		IncompleteDetails *struct {
+			Reason string `json:"reason"`
+		} `json:"incomplete_details"`
+		Output []struct {
+			Type    string `json:"type"`
+			Content []struct {
+				Type    string `json:"type"`
+				Text    string `json:"text"`
+				Refusal string `json:"refusal"`
+			} `json:"content"`
+		} `json:"output"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	err = json.Unmarshal(respBody, &response)
+	if err != nil {
+		return "", "", WrapError("failed to unmarshal response", err)
+	}
+	if response.Error != nil {
+		return "", "", WrapError(fmt.Sprintf("OpenAI Responses API error: %s", response.Error.Message))
+	}
 
 	// Extract the message content
-	if len(response.Choices) > 0 {
-		return response.Choices[0].Message.Content, nil
+	for _, output := range response.Output {
+		if output.Type != "message" {
+			continue
+		}
+		for _, content := range output.Content {
+			if content.Type == "refusal" {
+				return "", "", &RefusalError{FinishReason: "refusal", Refusal: content.Refusal}
+			}
+			if content.Type == "output_text" && strings.TrimSpace(content.Text) != "" {
+				return content.Text, response.ID, nil
+			}
+		}
 	}
 
-	return "", WrapError("unexpected message format: no choices in response")
+	finishReason := response.Status
+	if response.IncompleteDetails != nil {
+		finishReason = response.IncompleteDetails.Reason
+	}
+	return "", "", &RefusalError{FinishReason: finishReason}
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// Chat settings holding the defaults of outgoing messages.
+const (
+	chatParseModeSetting      = "parse_mode"      // Parse mode of the text: HTML or MarkdownV2, plain text if unset
+	chatLinkPreviewSetting    = "link_preview"    // "off" disables link previews
+	chatProtectContentSetting = "protect_content" // "on" keeps messages from being forwarded and saved
+	chatSilentSetting         = "silent"          // "on" sends messages without notification
+	chatReplyModeSetting      = "reply_mode"      // Overrides the configured reply mode
+)
+
+// replyQuoteMaxLength is the maximum number of characters quoted from the triggering message.
+const replyQuoteMaxLength = 200
+
+// OutgoingMessage is a text message sent by the bot, built with the defaults of its chat.
+type OutgoingMessage struct {
+	tg        *Telegram
+	chatID    ChatID
+	text      string
+	replyMode string
+	opts      gotgbot.SendMessageOpts
+}
+
+// newMessage starts a message to a chat with the defaults stored for it.
+func (tg *Telegram) newMessage(chatID ChatID, text string) *OutgoingMessage {
+	message := &OutgoingMessage{tg: tg, chatID: chatID, text: text, replyMode: tg.config.TelegramReplyMode}
+	setting := func(key string) string {
+		value, _, err := tg.db.GetChatSetting(chatID, key)
+		if err != nil {
+			log.Error().Err(err).Int64("chat_id", int64(chatID)).Str("key", key).Msg("Failed to get message default")
+		}
+		return value
+	}
+	message.opts.ParseMode = setting(chatParseModeSetting)
+	if setting(chatLinkPreviewSetting) == "off" {
+		message.opts.LinkPreviewOptions = &gotgbot.LinkPreviewOptions{IsDisabled: true}
+	}
+	message.opts.ProtectContent = setting(chatProtectContentSetting) == "on"
+	message.opts.DisableNotification = setting(chatSilentSetting) == "on"
+	if mode := setting(chatReplyModeSetting); mode != "" {
+		message.replyMode = mode
+	}
+	return message
+}
+
+// ReplyTo makes the message answer msg according to the reply mode of the chat: as a reply, as a
+// reply quoting the text after the command, or as a plain message in the same topic.
+func (message *OutgoingMessage) ReplyTo(msg *gotgbot.Message) *OutgoingMessage {
+	if msg.IsTopicMessage {
+		message.opts.MessageThreadId = msg.MessageThreadId
+	}
+	if message.replyMode == "plain" {
+		return message
+	}
+
+	message.opts.ReplyParameters = &gotgbot.ReplyParameters{MessageId: msg.MessageId, AllowSendingWithoutReply: true}
+	if message.replyMode == "quote" {
+		quote := msg.Text
+		if strings.HasPrefix(quote, "/") {
+			_, quote, _ = strings.Cut(quote, " ")
+		}
+		quote = strings.TrimSpace(quote)
+		if runes := []rune(quote); len(runes) > replyQuoteMaxLength {
+			quote = strings.TrimSpace(string(runes[:replyQuoteMaxLength]))
+		}
+		message.opts.ReplyParameters.Quote = quote
+	}
+	return message
+}
+
+// ReplyToID makes the message a reply to the message with the given ID, unless the reply mode of
+// the chat is plain.
+func (message *OutgoingMessage) ReplyToID(messageID MessageID) *OutgoingMessage {
+	if message.replyMode != "plain" && messageID != 0 {
+		message.opts.ReplyParameters = &gotgbot.ReplyParameters{MessageId: int64(messageID), AllowSendingWithoutReply: true}
+	}
+	return message
+}
+
+// PlainText sends the message as plain text, whatever the parse mode of the chat.
+func (message *OutgoingMessage) PlainText() *OutgoingMessage {
+	message.opts.ParseMode = ""
+	return message
+}
+
+// WithMarkup attaches a reply markup, such as an inline keyboard, to the message.
+func (message *OutgoingMessage) WithMarkup(markup gotgbot.ReplyMarkup) *OutgoingMessage {
+	message.opts.ReplyMarkup = markup
+	return message
+}
+
+// isParseError reports whether Telegram rejected a message because its text could not be parsed
+// in the requested parse mode.
+func isParseError(err error) bool {
+	var tgErr *gotgbot.TelegramError
+	return errors.As(err, &tgErr) && tgErr.Code == http.StatusBadRequest && strings.Contains(tgErr.Description, "can't parse entities")
+}
+
+// Send sends the message and returns the sent message, which is nil when the chat blocked the bot.
+// Text that does not parse in the chat's parse mode is resent as plain text, and messages to chats
+// migrated to a supergroup are resent there, without the reply.
+func (message *OutgoingMessage) Send() (*gotgbot.Message, error) {
+	tg, chatID := message.tg, message.chatID
+	if tg.isChatBlocked(chatID) {
+		log.Debug().Int64("chat_id", int64(chatID)).Msg("Skipping message to blocked chat")
+		return nil, nil
+	}
+	sent, err := tg.bot.SendMessage(int64(chatID), message.text, &message.opts)
+	if err != nil && message.opts.ParseMode != "" && isParseError(err) {
+		log.Warn().Err(err).Int64("chat_id", int64(chatID)).Str("parse_mode", message.opts.ParseMode).Msg("Resending message as plain text")
+		message.opts.ParseMode = ""
+		sent, err = tg.bot.SendMessage(int64(chatID), message.text, &message.opts)
+	}
+	if err != nil {
+		newChatID := tg.handleAPIError(chatID, err)
+		if newChatID == 0 {
+			return nil, WrapError("failed to send telegram message", err)
+		}
+		message.opts.ReplyParameters = nil
+		message.opts.MessageThreadId = 0
+		sent, err = tg.bot.SendMessage(int64(newChatID), message.text, &message.opts)
+		if err != nil {
+			return nil, WrapError("failed to send telegram message to migrated chat", err)
+		}
+	}
+	return sent, nil
+}
+
+// messageDefaults lists the settings of outgoing messages and their accepted values.
+var messageDefaults = []struct {
+	key    string
+	values []string
+}{
+	{chatParseModeSetting, []string{"HTML", "MarkdownV2"}},
+	{chatLinkPreviewSetting, []string{"on", "off"}},
+	{chatProtectContentSetting, []string{"on", "off"}},
+	{chatSilentSetting, []string{"on", "off"}},
+	{chatReplyModeSetting, []string{"reply", "quote", "plain"}},
+}
+
+// handleMrlDefaultsRequest processes the /mrl_defaults command, which shows the defaults of the
+// messages the bot sends in the chat, sets one of them, or with "default" restores it.
+func (tg *Telegram) handleMrlDefaultsRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_DEFAULTS request")
+
+	usage := "/mrl_defaults [<parse_mode|link_preview|protect_content|silent|reply_mode> <value|default>]"
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 2)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	if len(args.Positional) == 0 {
+		var lines []string
+		for _, setting := range messageDefaults {
+			value, ok, err := tg.db.GetChatSetting(chatID, setting.key)
+			if err != nil {
+				return WrapError("failed to get message default", err)
+			}
+			if !ok {
+				value = "default"
+			}
+			lines = append(lines, fmt.Sprintf("%s: %s (%s)", setting.key, value, strings.Join(setting.values, ", ")))
+		}
+		return tg.sendTelegramMessage(ctx, strings.Join(lines, "\n"))
+	}
+	if len(args.Positional) != 2 {
+		return tg.sendUsage(ctx, usage, nil)
+	}
+
+	key, value := args.Arg(0), args.Arg(1)
+	for _, setting := range messageDefaults {
+		if setting.key != key {
+			continue
+		}
+		if value == "default" {
+			err = tg.db.DeleteChatSetting(chatID, key)
+			if err != nil {
+				return WrapError("failed to delete message default", err)
+			}
+			return tg.sendTelegramMessage(ctx, key+" restored to the default.")
+		}
+		if err := oneOf(setting.values...)(value); err != nil {
+			return tg.sendTelegramMessage(ctx, fmt.Sprintf("%s must be one of: %s, default.", key, strings.Join(setting.values, ", ")))
+		}
+		err = tg.db.SetChatSetting(chatID, key, value)
+		if err != nil {
+			return WrapError("failed to set message default", err)
+		}
+		return tg.sendTelegramMessage(ctx, key+" set to "+value+".")
+	}
+	return tg.sendUsage(ctx, usage, nil)
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// promptHistoryLimit is the default number of recent chat history entries included in prompts.
+const promptHistoryLimit = 30
+
+// formatUserMessage formats a user message for inclusion in the prompt.
+func formatUserMessage(userID UserID, userName string, sentAt time.Time, text string) string {
+	if userName == "" {
+		userName = "Unknown User"
+	}
+	return fmt.Sprintf("[UID: %d] %s [%s]: %s", userID, userName, sentAt.Format(time.RFC3339), text)
+}
+
+// historyMessages returns a chat history entry as a user and assistant message pair, or as a
+// single user message for entries the bot did not answer. Edited user messages are marked, since
+// the reply answered their earlier version.
+func historyMessages(history ChatHistory) []map[string]string {
+	userMsg := history.UserMsg
+	if history.Edited {
+		userMsg += " [edited " + history.EditedAt.Format(time.RFC3339) + "]"
+	}
+	if history.BotMsg == "" {
+		return []map[string]string{{"role": "user", "content": formatUserMessage(history.UserID, history.UserName, history.LastUsed, userMsg)}}
+	}
+	return []map[string]string{
+		{"role": "user", "content": formatUserMessage(history.UserID, history.UserName, history.LastUsed, userMsg)},
+		{"role": "assistant", "content": history.BotMsg},
+	}
+}
+
+// replyExcerptMaxTokens caps the excerpt of the replied message marking a reply in the prompt.
+const replyExcerptMaxTokens = 200
+
+// messageContent returns the text of a message carried in a Telegram update: its text, its
+// caption, or a description of its structured content.
+func messageContent(msg *gotgbot.Message) string {
+	if msg.Text != "" {
+		return msg.Text
+	}
+	if msg.Caption != "" {
+		return msg.Caption
+	}
+	return describeStructured(msg)
+}
+
+// replyContext returns a note telling the model which message a message replies to, with the part
+// the user quoted or an excerpt of the replied message, or an empty string when it is no reply.
+// The reply chain carries the full replied message, but it may be left out of the prompt or be
+// far from the current message in the history.
+func (tg *Telegram) replyContext(msg *gotgbot.Message) string {
+	parent := msg.ReplyToMessage
+	if parent != nil && msg.IsTopicMessage && parent.MessageId == msg.MessageThreadId {
+		// Messages in forum topics reply to the topic's first message unless they reply to another
+		parent = nil
+	}
+
+	var author, text string
+	switch {
+	case parent != nil && parent.From != nil && parent.From.Id == tg.bot.Id:
+		author = "your message"
+		text = messageContent(parent)
+	case parent != nil && parent.From != nil:
+		author = fmt.Sprintf("the message of [UID: %d] %s", parent.From.Id, parent.From.Username)
+		text = messageContent(parent)
+	case parent != nil:
+		author = "a message"
+		text = messageContent(parent)
+	case msg.Quote != nil:
+		author = "a message from another chat"
+	default:
+		return ""
+	}
+
+	if msg.Quote != nil && msg.Quote.Text != "" {
+		return fmt.Sprintf("[In reply to %s, quoting: %q]\n", author, msg.Quote.Text)
+	}
+	if text == "" {
+		return fmt.Sprintf("[In reply to %s]\n", author)
+	}
+	return fmt.Sprintf("[In reply to %s: %q]\n", author, truncateMiddle(text, replyExcerptMaxTokens))
+}
+
+// payloadMessages returns a message carried in a Telegram update as prompt context.
+func (tg *Telegram) payloadMessages(msg *gotgbot.Message) []map[string]string {
+	text := messageContent(msg)
+	if text == "" {
+		return nil
+	}
+
+	if msg.From != nil && msg.From.Id == tg.bot.Id {
+		return []map[string]string{{"role": "assistant", "content": text}}
+	}
+	var userID UserID
+	var userName string
+	if msg.From != nil {
+		userID = UserID(msg.From.Id)
+		userName = msg.From.Username
+	}
+	return []map[string]string{{"role": "user", "content": formatUserMessage(userID, userName, time.Unix(msg.Date, 0), text)}}
+}
+
+// replyChainMessages walks up the reply ancestry of a message and returns up to the configured
+// number of ancestors as prompt context, oldest first. Ancestors are looked up in the stored chat
+// history; the direct parent falls back to the copy carried in the update when it is not stored.
+// Entries already present in seen are skipped, and the included ones are added to it.
+func (tg *Telegram) replyChainMessages(msg *gotgbot.Message, seen map[uint]bool) []map[string]string {
+	if msg.ReplyToMessage == nil || tg.config.TelegramReplyChainDepth <= 0 {
+		return nil
+	}
+	if tg.config.Stateless {
+		return tg.payloadMessages(msg.ReplyToMessage)
+	}
+
+	messages, found := tg.storedReplyChain(ChatID(msg.Chat.Id), MessageID(msg.ReplyToMessage.MessageId), seen)
+	if !found {
+		return tg.payloadMessages(msg.ReplyToMessage)
+	}
+	return messages
+}
+
+// storedReplyChain returns up to the configured number of stored chat history entries in the reply
+// ancestry starting at the given message, oldest first, and whether that message itself is stored.
+// Entries already present in seen are skipped, and the included ones are added to it.
+func (tg *Telegram) storedReplyChain(chatID ChatID, messageID MessageID, seen map[uint]bool) ([]map[string]string, bool) {
+	var ancestors [][]map[string]string
+	found := false
+	for depth := 0; depth < tg.config.TelegramReplyChainDepth && messageID != 0; depth++ {
+		entry, ok, err := tg.db.GetChatHistoryByMessage(chatID, messageID)
+		if err != nil {
+			log.Error().Err(err).Int64("chat_id", int64(chatID)).Int64("message_id", int64(messageID)).Msg("Failed to look up reply ancestor")
+			break
+		}
+		if !ok {
+			break
+		}
+		found = true
+		if !seen[entry.ID] {
+			seen[entry.ID] = true
+			ancestors = append(ancestors, historyMessages(entry))
+		}
+		messageID = entry.ReplyToMessageID
+	}
+
+	var messages []map[string]string
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		messages = append(messages, ancestors[i]...)
+	}
+	return messages, found
+}
+
+// systemInstruction builds the system instruction for a chat, from the chat's own instruction when
+// set, hinting the chat's language, or else the language most of its users have set in Telegram,
+// as the default reply language, or the fallback language when unknown, and the chat's verbosity.
+func (tg *Telegram) systemInstruction(chatID ChatID, fallbackLanguage string) (string, error) {
+	instruction, ok, err := tg.db.GetChatSetting(chatID, chatInstructionSetting)
+	if err != nil {
+		return "", WrapError("failed to get chat instruction", err)
+	}
+	if !ok {
+		instruction = tg.config.OpenAIInstruction
+	}
+
+	languageCode, chosen, err := tg.db.GetChatSetting(chatID, chatLanguageSetting)
+	if err != nil {
+		return "", WrapError("failed to get chat language", err)
+	}
+	if chosen {
+		instruction += fmt.Sprintf("\n\nUnless asked otherwise, reply in the language with IETF code %q, the one chosen for this chat.", languageCode)
+	} else {
+		languageCode, err = tg.db.GetDominantLanguage(chatID)
+		if err != nil {
+			return "", WrapError("failed to get dominant language", err)
+		}
+		if languageCode == "" {
+			languageCode = fallbackLanguage
+		}
+		if languageCode != "" {
+			instruction += fmt.Sprintf("\n\nUnless asked otherwise, reply in the language with IETF code %q, the one most users in this chat use.", languageCode)
+		}
+	}
+	if verbosity, ok := verbosityInstructions[tg.chatVerbosity(chatID)]; ok {
+		instruction += "\n\n" + verbosity
+	}
+	if tg.config.TelegramStyleLearning {
+		hint, _, err := tg.db.GetChatSetting(chatID, chatStyleHintSetting)
+		if err != nil {
+			return "", WrapError("failed to get style hint", err)
+		}
+		if hint != "" {
+			instruction += "\n\n" + hint
+		}
+	}
+	if tg.config.ChatMemory {
+		memory, err := tg.chatMemoryInstruction(chatID)
+		if err != nil {
+			return "", WrapError("failed to build chat memory instruction", err)
+		}
+		if memory != "" {
+			instruction += "\n\n" + memory
+		}
+	}
+	return instruction, nil
+}
+
+// chatTools returns the tools offered to the model while answering a message, and the handler
+// running their calls, or nil when no tools are enabled.
+func (tg *Telegram) chatTools(msg *gotgbot.Message) ([]Tool, func(name, arguments string) string) {
+	var tools []Tool
+	handlers := make(map[string]func(name, arguments string) string)
+	add := func(toolset []Tool, handler func(name, arguments string) string) {
+		for _, tool := range toolset {
+			tools = append(tools, tool)
+			handlers[tool.Name] = handler
+		}
+	}
+	if tg.config.ChatMemory {
+		add(chatMemoryTools, tg.chatMemoryToolHandler(ChatID(msg.Chat.Id)))
+	}
+	if tg.config.FollowUps {
+		add(followUpTools, tg.followUpToolHandler(msg))
+	}
+	if len(tools) == 0 {
+		return nil, nil
+	}
+	return tools, func(name, arguments string) string {
+		handler, ok := handlers[name]
+		if !ok {
+			return "error: unknown tool"
+		}
+		return handler(name, arguments)
+	}
+}
+
+// buildPrompt assembles the messages sent to the model: the system instruction, the chat history
+// fitted to the token budget, the reply chain, and the current message. The reply chain is built
+// after the history is known, so entries already in the history are not repeated.
+func (tg *Telegram) buildPrompt(instruction string, history []ChatHistory, replyChain func(seen map[uint]bool) []map[string]string, current map[string]string) []map[string]string {
+	messages := []map[string]string{{"role": "system", "content": instruction}}
+
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].LastUsed.Before(history[j].LastUsed)
+	})
+
+	seen := make(map[uint]bool)
+	for _, entry := range history {
+		seen[entry.ID] = true
+	}
+	chain := replyChain(seen)
+
+	reserved := messagesTokens(messages) + messagesTokens(chain) + estimateTokens(current["content"])
+	messages = append(messages, tg.fitHistory(history, reserved)...)
+	messages = append(messages, chain...)
+	return append(messages, current)
+}
+
+// truncateMiddle shortens a text to roughly the given number of tokens, keeping its beginning and
+// end, which usually carry the question and the most relevant part of a pasted log.
+func truncateMiddle(text string, maxTokens int) string {
+	runes := []rune(text)
+	keep := maxTokens * 4
+	if len(runes) <= keep {
+		return text
+	}
+	head := keep / 2
+	tail := keep - head
+	return string(runes[:head]) + "\n[…]\n" + string(runes[len(runes)-tail:])
+}
+
+// promptInput returns the text of a user message as included in the prompt. Messages over the
+// configured token cap are shortened in the middle or, with the summarize strategy, summarized.
+// The stored chat history keeps the original text.
+func (tg *Telegram) promptInput(text string) string {
+	maxTokens := tg.config.OpenAIMaxInputTokens
+	if maxTokens <= 0 || estimateTokens(text) <= maxTokens {
+		return text
+	}
+
+	log.Debug().Int("tokens", estimateTokens(text)).Int("max_tokens", maxTokens).Msg("User message exceeds input token cap")
+	if tg.config.OpenAIInputStrategy == "summarize" && tg.oai != nil {
+		messages := []map[string]string{
+			{"role": "system", "content": "Summarize the following message, keeping any question it asks, names, numbers, and error messages verbatim."},
+			{"role": "user", "content": truncateMiddle(text, tg.config.OpenAISummaryMaxTokens)},
+		}
+		summary, err := tg.oai.CallWithOptions(messages, CallOptions{MaxTokens: maxTokens})
+		if err == nil {
+			return "[Summary of a long message] " + summary
+		}
+		log.Warn().Err(err).Msg("Failed to summarize long user message, truncating instead")
+	}
+	return truncateMiddle(text, maxTokens)
+}
+
+// summaryMaxOutputTokens caps the length of history summaries.
+const summaryMaxOutputTokens = 256
+
+// estimateTokens roughly estimates the number of tokens of a text, assuming four characters per token.
+func estimateTokens(text string) int {
+	return utf8.RuneCountInString(text)/4 + 1
+}
+
+// messagesTokens estimates the number of tokens of prompt messages.
+func messagesTokens(messages []map[string]string) int {
+	tokens := 0
+	for _, message := range messages {
+		tokens += estimateTokens(message["content"])
+	}
+	return tokens
+}
+
+// fitHistory converts chat history, sorted oldest first, into prompt messages that fit the
+// configured token budget minus the reserved tokens. The oldest entries over budget are either
+// dropped or, with the summarize strategy, replaced by a summary.
+func (tg *Telegram) fitHistory(history []ChatHistory, reserved int) []map[string]string {
+	if tg.config.OpenAIMaxContextTokens <= 0 {
+		var messages []map[string]string
+		for _, entry := range history {
+			messages = append(messages, historyMessages(entry)...)
+		}
+		return messages
+	}
+
+	available := tg.config.OpenAIMaxContextTokens - reserved
+	split := len(history)
+	for split > 0 {
+		tokens := messagesTokens(historyMessages(history[split-1]))
+		if tokens > available {
+			break
+		}
+		available -= tokens
+		split--
+	}
+
+	var messages []map[string]string
+	overflow := history[:split]
+	if len(overflow) > 0 {
+		log.Debug().Int("overflow", len(overflow)).Int("kept", len(history)-split).Msg("Chat history exceeds token budget")
+		if tg.config.OpenAIContextStrategy == "summarize" {
+			summary, err := tg.summarizeHistory(overflow)
+			if err != nil {
+				log.Warn().Err(err).Msg("Failed to summarize chat history, truncating instead")
+			} else {
+				messages = append(messages, map[string]string{"role": "system", "content": "Summary of the earlier conversation: " + summary})
+			}
+		}
+	}
+	for _, entry := range history[split:] {
+		messages = append(messages, historyMessages(entry)...)
+	}
+	return messages
+}
+
+// summarizeHistory summarizes chat history, sorted oldest first. Only the newest entries that fit
+// the configured summary token cap are sent to the model.
+func (tg *Telegram) summarizeHistory(history []ChatHistory) (string, error) {
+	var transcript []string
+	available := tg.config.OpenAISummaryMaxTokens
+	for i := len(history) - 1; i >= 0; i-- {
+		entry := historyMessages(history[i])
+		text := entry[0]["content"]
+		if len(entry) > 1 {
+			text += "\nassistant: " + entry[1]["content"]
+		}
+		tokens := estimateTokens(text)
+		if tokens > available {
+			break
+		}
+		available -= tokens
+		transcript = append([]string{text}, transcript...)
+	}
+	if len(transcript) == 0 {
+		return "", WrapError("no history fits the summary token cap")
+	}
+	if tg.oai == nil {
+		return "", WrapError("no model client available")
+	}
+
+	messages := []map[string]string{
+		{"role": "system", "content": "Summarize the following chat transcript in a few sentences, keeping names, facts, and open questions."},
+		{"role": "user", "content": strings.Join(transcript, "\n\n")},
+	}
+	summary, err := tg.oai.CallWithOptions(messages, CallOptions{MaxTokens: summaryMaxOutputTokens})
+	if err != nil {
+		return "", WrapError("failed to call OpenAI for summary", err)
+	}
+	return summary, nil
+}
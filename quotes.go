@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// maxQuoteLength is the maximum number of characters of a saved quote.
+const maxQuoteLength = 1000
+
+// formatQuote renders a saved quote with its author and date.
+func formatQuote(quote Quote) string {
+	return fmt.Sprintf("“%s”\n— %s, %s", quote.Text, quote.AuthorName, quote.SentAt.Format("2006-01-02"))
+}
+
+// handleMrlQuoteRequest processes the /mrl_quote command. Sent as a reply it saves the replied
+// message as a quote of the chat, and with "random" it shows a random saved quote.
+func (tg *Telegram) handleMrlQuoteRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_QUOTE request")
+
+	usage := "/mrl_quote [random], as a reply to save the message"
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 1)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	switch {
+	case args.Arg(0) == "random":
+		quote, found, err := tg.db.GetRandomQuote(chatID)
+		if err != nil {
+			return WrapError("failed to get random quote", err)
+		}
+		if !found {
+			return tg.sendTelegramMessage(ctx, "Nenhuma citação salva neste chat. Responda a uma mensagem com /mrl_quote para salvá-la.")
+		}
+		return tg.sendTelegramMessage(ctx, formatQuote(quote))
+	case args.Arg(0) != "":
+		return tg.sendUsage(ctx, usage, nil)
+	}
+
+	reply := ctx.EffectiveMessage.ReplyToMessage
+	if reply == nil || reply.From == nil {
+		return tg.sendUsage(ctx, usage, nil)
+	}
+	text := strings.TrimSpace(reply.Text)
+	if text == "" {
+		text = strings.TrimSpace(reply.Caption)
+	}
+	if text == "" {
+		return tg.sendTelegramMessage(ctx, "Só é possível salvar mensagens com texto.")
+	}
+	if runes := []rune(text); len(runes) > maxQuoteLength {
+		return tg.sendTelegramMessage(ctx, fmt.Sprintf("A mensagem é longa demais para uma citação (máximo de %d caracteres).", maxQuoteLength))
+	}
+
+	author := reply.From.FirstName
+	if reply.From.Username != "" {
+		author = "@" + reply.From.Username
+	}
+	quote := Quote{
+		ChatID:     chatID,
+		MessageID:  MessageID(reply.MessageId),
+		AuthorID:   UserID(reply.From.Id),
+		AuthorName: author,
+		Text:       text,
+		SavedBy:    UserID(ctx.EffectiveMessage.From.Id),
+		SentAt:     time.Unix(reply.Date, 0),
+	}
+	added, err := tg.db.AddQuote(&quote)
+	if err != nil {
+		return WrapError("failed to add quote", err)
+	}
+	if !added {
+		return tg.sendTelegramMessage(ctx, "Essa mensagem já está salva.")
+	}
+	return tg.sendTelegramMessage(ctx, "Citação salva.")
+}
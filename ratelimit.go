@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// tokenBucket holds the requests a chat may still make.
+type tokenBucket struct {
+	tokens   float64   // Requests available
+	updated  time.Time // When the tokens were last refilled
+	notified bool      // Whether the chat was told it ran out since its last allowed request
+}
+
+// RateLimiter limits the requests of each chat with a token bucket, so a busy group cannot spend
+// the API credits of every other chat.
+type RateLimiter struct {
+	mu      sync.Mutex
+	buckets map[ChatID]*tokenBucket
+	pruned  time.Time // When refilled buckets were last dropped
+}
+
+// NewRateLimiter creates a new rate limiter.
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: make(map[ChatID]*tokenBucket)}
+}
+
+// Take takes a request from the bucket of a chat, refilled at rate requests per minute up to
+// burst, and reports whether one was available. When it was not, notify reports whether this is
+// the first refused request since the last allowed one.
+func (limiter *RateLimiter) Take(chatID ChatID, now time.Time, rate float64, burst int) (allowed, notify bool) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	limiter.prune(now, rate, burst)
+	bucket, ok := limiter.buckets[chatID]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(burst), updated: now}
+		limiter.buckets[chatID] = bucket
+	}
+	bucket.tokens = min(float64(burst), bucket.tokens+now.Sub(bucket.updated).Minutes()*rate)
+	bucket.updated = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		bucket.notified = false
+		return true, false
+	}
+	notify = !bucket.notified
+	bucket.notified = true
+	return false, notify
+}
+
+// prune drops the buckets refilled up to burst since their last request, which a new bucket
+// replaces exactly, at most once per throttlePruneInterval. The caller holds the lock.
+func (limiter *RateLimiter) prune(now time.Time, rate float64, burst int) {
+	if now.Sub(limiter.pruned) < throttlePruneInterval {
+		return
+	}
+	limiter.pruned = now
+	for chatID, bucket := range limiter.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Minutes()*rate >= float64(burst) {
+			delete(limiter.buckets, chatID)
+		}
+	}
+}
+
+// checkChatRate reports whether a request must be skipped because its chat ran out of requests.
+// The chat is told once each time it runs out. The admin is never limited.
+func (tg *Telegram) checkChatRate(ctx *ext.Context) bool {
+	if tg.config.TelegramChatRate <= 0 || UserID(ctx.EffectiveMessage.From.Id) == tg.config.TelegramAdminUID {
+		return false
+	}
+
+	userID, chatID := UserID(ctx.EffectiveMessage.From.Id), ChatID(ctx.EffectiveMessage.Chat.Id)
+	allowed, notify := tg.chatRate.Take(chatID, time.Now(), tg.config.TelegramChatRate, max(tg.config.TelegramChatBurst, 1))
+	if allowed {
+		return false
+	}
+	if !notify {
+		componentLog(LogFlood).Debug().Int64("chat_id", int64(chatID)).Msg("Ignoring request over the chat rate")
+		return true
+	}
+
+	log.Warn().Int64("chat_id", int64(chatID)).Int64("user_id", int64(userID)).Msg("Chat rate limited")
+	err := tg.db.AddFloodEvent(chatID, userID, "chat_rate")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to record flood event")
+	}
+	tg.webhooks.Notify(EventModeration, map[string]interface{}{"chat_id": chatID, "user_id": userID, "action": "throttled", "reason": "chat_rate"})
+	err = tg.sendTelegramMessage(ctx, "Estou recebendo muitas mensagens neste chat. Vamos com calma, tente novamente em instantes.")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to send rate limit notice")
+	}
+	return true
+}
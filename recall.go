@@ -0,0 +1,173 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// semanticIndexBatch is the number of chat history entries embedded in one request.
+const semanticIndexBatch = 100
+
+// semanticRecallCandidates is the number of the newest embedded entries of a chat compared to a
+// new message when recalling related ones.
+const semanticRecallCandidates = 2000
+
+// embeddingMaxTokens caps the text embedded for a message, below the input limit of embedding
+// models, since long pastes would otherwise fail the whole batch.
+const embeddingMaxTokens = 4000
+
+// MessageEmbedding is the embedding of a chat history entry.
+type MessageEmbedding struct {
+	HistoryID uint      // ID of the chat history entry
+	ChatID    ChatID    // Chat of the entry
+	Model     string    // Model that computed the embedding
+	Embedding []float64 // Embedding of the user message and the reply
+}
+
+// historyEmbeddingText returns the text of a chat history entry that is embedded.
+func historyEmbeddingText(entry ChatHistory) string {
+	return truncateMiddle(strings.TrimSpace(entry.UserMsg+"\n"+entry.BotMsg), embeddingMaxTokens)
+}
+
+// indexHistory embeds the chat history entries without an embedding from the configured model,
+// the newest first, and deletes the embeddings of deleted entries. It returns the number of
+// entries embedded.
+func (tg *Telegram) indexHistory() (int, error) {
+	_, err := tg.db.DeleteOrphanedEmbeddings()
+	if err != nil {
+		return 0, err
+	}
+
+	indexed := 0
+	for {
+		entries, err := tg.db.GetUnembeddedChatHistory(tg.oai.EmbeddingModel, semanticIndexBatch)
+		if err != nil {
+			return indexed, err
+		}
+		if len(entries) == 0 {
+			return indexed, nil
+		}
+
+		texts := make([]string, len(entries))
+		for i, entry := range entries {
+			texts[i] = historyEmbeddingText(entry)
+		}
+		embeddings, err := tg.oai.Embed(texts)
+		if err != nil {
+			return indexed, WrapError("failed to embed chat history", err)
+		}
+		for i, entry := range entries {
+			err = tg.db.SetMessageEmbedding(MessageEmbedding{HistoryID: entry.ID, ChatID: entry.ChatID, Model: tg.oai.EmbeddingModel, Embedding: embeddings[i]})
+			if err != nil {
+				return indexed, err
+			}
+			indexed++
+		}
+
+		if len(entries) < semanticIndexBatch {
+			return indexed, nil
+		}
+		select {
+		case <-tg.stop:
+			return indexed, nil
+		default:
+		}
+	}
+}
+
+// runSemanticIndex periodically embeds new chat history so it can be recalled into prompts.
+func (tg *Telegram) runSemanticIndex() {
+	ticker := time.NewTicker(time.Duration(tg.config.SemanticIndexInterval * float64(time.Minute)))
+	defer ticker.Stop()
+	for {
+		indexed, err := tg.indexHistory()
+		if err != nil {
+			log.Error().Err(err).Int("indexed", indexed).Msg("Failed to index chat history")
+		} else if indexed > 0 {
+			log.Info().Int("indexed", indexed).Msg("Indexed chat history")
+			tg.analytics.Record(AnalyticsJobRun, 0, 0, map[string]interface{}{"job": "semantic_index", "indexed": indexed})
+		}
+		select {
+		case <-tg.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recallHistory returns up to the configured number of chat history entries of a chat, stored
+// since one time and before another, whose embeddings are most similar to a message, oldest
+// first. Entries in the given history are skipped, since they are already part of the prompt.
+func (tg *Telegram) recallHistory(chatID ChatID, message string, history []ChatHistory, since, before time.Time) ([]ChatHistory, error) {
+	candidates, err := tg.db.GetMessageEmbeddings(chatID, tg.oai.EmbeddingModel, since, before, semanticRecallCandidates)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	embeddings, err := tg.oai.Embed([]string{truncateMiddle(message, embeddingMaxTokens)})
+	if err != nil {
+		return nil, WrapError("failed to embed message", err)
+	}
+
+	included := make(map[uint]bool, len(history))
+	for _, entry := range history {
+		included[entry.ID] = true
+	}
+	type match struct {
+		id         uint
+		similarity float64
+	}
+	var matches []match
+	for _, candidate := range candidates {
+		if included[candidate.HistoryID] {
+			continue
+		}
+		similarity := cosineSimilarity(embeddings[0], candidate.Embedding)
+		if similarity >= tg.config.SemanticRecallThreshold {
+			matches = append(matches, match{candidate.HistoryID, similarity})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].similarity > matches[j].similarity
+	})
+
+	var ids []uint
+	for i := 0; i < len(matches) && i < tg.config.SemanticRecall; i++ {
+		ids = append(ids, matches[i].id)
+	}
+	return tg.db.GetChatHistoryByIDs(ids)
+}
+
+// recalledContext returns the messages of a chat stored since one time and before another that
+// are related to a message, to be appended to the system instruction, or an empty string when
+// recall is disabled or finds none.
+func (tg *Telegram) recalledContext(chatID ChatID, message string, history []ChatHistory, since, before time.Time) string {
+	if tg.config.SemanticRecall <= 0 || tg.config.Stateless || tg.oai == nil {
+		return ""
+	}
+	recalled, err := tg.recallHistory(chatID, message, history, since, before)
+	if err != nil {
+		log.Warn().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to recall related chat history")
+		return ""
+	}
+	if len(recalled) == 0 {
+		return ""
+	}
+	log.Debug().Int64("chat_id", int64(chatID)).Int("recalled", len(recalled)).Msg("Recalled related chat history")
+
+	var sb strings.Builder
+	sb.WriteString("\n\nEarlier messages of this chat related to the current one:")
+	for _, entry := range recalled {
+		messages := historyMessages(entry)
+		sb.WriteString("\n" + messages[0]["content"])
+		if len(messages) > 1 {
+			sb.WriteString("\nassistant: " + messages[1]["content"])
+		}
+	}
+	return sb.String()
+}
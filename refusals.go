@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// recentRefusalsLimit is the number of refusals listed by /mrl_refusals.
+const recentRefusalsLimit = 10
+
+// promptHash returns a short hash identifying a prompt.
+func promptHash(messages []map[string]string) string {
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// recordRefusal stores a model refusal so the admin can review it later.
+func (tg *Telegram) recordRefusal(ctx *ext.Context, messages []map[string]string, refusalErr *RefusalError) {
+	refusal := Refusal{
+		ChatID:       ChatID(ctx.EffectiveMessage.Chat.Id),
+		UserID:       UserID(ctx.EffectiveMessage.From.Id),
+		PromptHash:   promptHash(messages),
+		FinishReason: refusalErr.FinishReason,
+		Refusal:      refusalErr.Refusal,
+		CreatedAt:    time.Now(),
+	}
+	log.Warn().Int64("chat_id", int64(refusal.ChatID)).Str("prompt_hash", refusal.PromptHash).Str("finish_reason", refusal.FinishReason).Msg("Model refused or returned an empty response")
+
+	err := tg.db.AddRefusal(&refusal)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to record refusal")
+	}
+}
+
+// handleMrlRefusalsRequest processes the /mrl_refusals command.
+func (tg *Telegram) handleMrlRefusalsRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_REFUSALS request")
+
+	refusals, err := tg.db.GetRecentRefusals(recentRefusalsLimit)
+	if err != nil {
+		return WrapError("failed to get recent refusals", err)
+	}
+	if len(refusals) == 0 {
+		return tg.sendTelegramMessage(ctx, "No refusals recorded.")
+	}
+
+	var sb strings.Builder
+	for _, refusal := range refusals {
+		fmt.Fprintf(&sb, "%s chat %d user %d prompt %s: %s", refusal.CreatedAt.Format(time.RFC3339), refusal.ChatID, refusal.UserID, refusal.PromptHash, refusal.FinishReason)
+		if refusal.Refusal != "" {
+			fmt.Fprintf(&sb, " (%s)", refusal.Refusal)
+		}
+		sb.WriteString("\n")
+	}
+	err = tg.sendTelegramMessage(ctx, strings.TrimSpace(sb.String()))
+	if err != nil {
+		return WrapError("failed to send refusals message", err)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// regenerateEmoji is the reaction that asks for a new version of a bot reply.
+const regenerateEmoji = "🔄"
+
+// regenerateTemperatureStep is how much the temperature is raised for a regenerated reply.
+const regenerateTemperatureStep = 0.3
+
+// replyKey identifies a bot reply in a chat.
+type replyKey struct {
+	chatID    ChatID
+	messageID MessageID
+}
+
+// RegenerationClaims tracks the replies being regenerated, so concurrent reactions do not
+// regenerate the same reply twice.
+type RegenerationClaims struct {
+	mu      sync.Mutex
+	claimed map[replyKey]bool
+}
+
+// NewRegenerationClaims creates a new regeneration tracker.
+func NewRegenerationClaims() *RegenerationClaims {
+	return &RegenerationClaims{claimed: make(map[replyKey]bool)}
+}
+
+// claim reports whether a reply may be regenerated, marking it as being regenerated when so.
+func (claims *RegenerationClaims) claim(key replyKey) bool {
+	claims.mu.Lock()
+	defer claims.mu.Unlock()
+
+	if claims.claimed[key] {
+		return false
+	}
+	claims.claimed[key] = true
+	return true
+}
+
+// release marks a reply as no longer being regenerated.
+func (claims *RegenerationClaims) release(key replyKey) {
+	claims.mu.Lock()
+	defer claims.mu.Unlock()
+	delete(claims.claimed, key)
+}
+
+// hasReaction reports whether the reactions contain the given emoji.
+func hasReaction(reactions []gotgbot.ReactionType, emoji string) bool {
+	for _, reaction := range reactions {
+		if r, ok := reaction.(gotgbot.ReactionTypeEmoji); ok && r.Emoji == emoji {
+			return true
+		}
+	}
+	return false
+}
+
+// isRegenerateReaction reports whether a reaction update adds the regenerate emoji.
+func isRegenerateReaction(reaction *gotgbot.MessageReactionUpdated) bool {
+	return reaction.User != nil && hasReaction(reaction.NewReaction, regenerateEmoji) && !hasReaction(reaction.OldReaction, regenerateEmoji)
+}
+
+// regenerateTemperature returns the temperature for regenerating a reply, raised above the one
+// used for the original reply.
+func (tg *Telegram) regenerateTemperature(entry ChatHistory) *float32 {
+	temperature := tg.config.OpenAITemperature
+	if t := tg.responseTemperature(entry.ChatID, entry.UserMsg); t != nil {
+		temperature = *t
+	}
+	temperature += regenerateTemperatureStep
+	if temperature > 2 {
+		temperature = 2
+	}
+	return &temperature
+}
+
+// handleRegenerateReaction processes a regenerate reaction to a bot reply. The prompt of the
+// reply is rebuilt from the stored history and answered again with a higher temperature, the
+// new reply replaces the old one in the history once stored, and each reply is regenerated at
+// most once.
+func (tg *Telegram) handleRegenerateReaction(b *gotgbot.Bot, ctx *ext.Context) error {
+	reaction := ctx.MessageReaction
+	chatID, userID := ChatID(reaction.Chat.Id), UserID(reaction.User.Id)
+
+	// Claiming the reply before looking it up keeps concurrent reactions from regenerating it
+	// twice, as the original is only retracted once the new reply is stored
+	key := replyKey{chatID: chatID, messageID: MessageID(reaction.MessageId)}
+	if !tg.regenerating.claim(key) {
+		return nil
+	}
+	defer tg.regenerating.release(key)
+
+	entry, ok, err := tg.db.GetChatHistoryByMessage(chatID, MessageID(reaction.MessageId))
+	if err != nil {
+		return WrapError("failed to get reacted chat history", err)
+	}
+	if !ok || entry.BotMessageID != MessageID(reaction.MessageId) || entry.Regenerated {
+		return nil
+	}
+	if userID != entry.UserID && userID != tg.config.TelegramAdminUID {
+		return nil
+	}
+	if tg.isChatBlocked(chatID) || tg.oai == nil || !tg.flags.Enabled(FlagRegenerate, chatID) {
+		return nil
+	}
+	if !tg.regenerations.allow(chatID, time.Duration(tg.config.TelegramRegenerateCooldown*float64(time.Second))) {
+		log.Info().Int64("chat_id", int64(chatID)).Int64("message_id", reaction.MessageId).Msg("Ignoring regenerate reaction during cooldown")
+		return nil
+	}
+	log.Info().Int64("chat_id", int64(chatID)).Int64("user_id", int64(userID)).Int64("message_id", reaction.MessageId).Msg("Received regenerate reaction")
+
+	messages, err := tg.entryPrompt(entry)
+	if err != nil {
+		return WrapError("failed to rebuild prompt", err)
+	}
+	messages[0]["content"] += "\n\nThe user asked for another answer to the last message. Try again differently than this previous answer:\n" + entry.BotMsg
+
+	receivedAt := time.Now()
+	model := tg.chatModel(chatID)
+	content, err := tg.oai.CallWithOptions(messages, CallOptions{Temperature: tg.regenerateTemperature(entry), Model: model, MaxTokens: tg.verbosityMaxTokens(chatID)})
+	if err != nil {
+		return WrapError("failed to regenerate reply", err)
+	}
+	sent, err := tg.newMessage(chatID, content).ReplyToID(entry.MessageID).Send()
+	if err != nil {
+		return WrapError("failed to send regenerated reply", err)
+	}
+	if sent == nil {
+		return nil
+	}
+	tg.analytics.Record(AnalyticsTokensUsed, chatID, userID, map[string]interface{}{
+		"model":                 model,
+		"prompt_tokens_est":     messagesTokens(messages),
+		"completion_tokens_est": estimateTokens(content),
+		"regenerated":           true,
+	})
+	tg.recordServedModel(chatID, model)
+
+	if tg.config.Stateless || !tg.learnsFrom(chatID) {
+		return nil
+	}
+	originalID := entry.ID
+	entry.BotMsg = content
+	entry.ResponseID = ""
+	entry.Latency = time.Since(receivedAt)
+	entry.BotMessageID = MessageID(sent.MessageId)
+	entry.Regenerated = true
+	err = tg.db.AddChatHistory(&entry)
+	if err != nil {
+		return WrapError("failed to add regenerated chat history", err)
+	}
+	err = tg.db.RetractChatHistory(originalID)
+	if err != nil {
+		return WrapError("failed to retract regenerated chat history", err)
+	}
+	return nil
+}
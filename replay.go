@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ReplayResult is the outcome of replaying a stored chat history entry.
+type ReplayResult struct {
+	HistoryID     uint                `json:"history_id"`               // ID of the replayed chat history entry
+	ChatID        ChatID              `json:"chat_id"`                  // ID of the chat
+	SentAt        time.Time           `json:"sent_at"`                  // Time the original reply was stored
+	Temperature   *float32            `json:"temperature,omitempty"`    // Sampling temperature the live path would use
+	Messages      []map[string]string `json:"messages"`                 // Reconstructed prompt
+	OriginalReply string              `json:"original_reply"`           // Reply stored at the time
+	ReplayedReply string              `json:"replayed_reply,omitempty"` // Reply of the model to the reconstructed prompt
+	Error         string              `json:"error,omitempty"`          // Error of the model call, if any
+}
+
+// runReplay runs the replay subcommand, which reconstructs the prompts of stored chat history
+// and optionally sends them to the model again, for offline evaluation of prompt changes.
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	chatID := flags.Int64("chat-id", 0, "chat to replay")
+	from := flags.String("from", "", "start of the time range, as RFC 3339 or YYYY-MM-DD")
+	to := flags.String("to", "", "end of the time range, as RFC 3339 or YYYY-MM-DD (default the longest allowed range, up to now)")
+	run := flags.Bool("run", false, "send the prompts to the configured model instead of only printing them")
+	output := flags.String("o", "", "directory to write one JSON file per entry to (default standard output)")
+	err := flags.Parse(args)
+	if err != nil {
+		return WrapError("failed to parse flags", err)
+	}
+	if *chatID == 0 || *from == "" {
+		return WrapError("usage: murailobot replay -chat-id id -from time [-to time] [-run] [-o dir]")
+	}
+
+	start, err := parseReplayTime(*from)
+	if err != nil {
+		return WrapError("invalid start time", err)
+	}
+	end := start.Add(maxHistoryTimeRange)
+	if end.After(time.Now()) {
+		end = time.Now()
+	}
+	if *to != "" {
+		end, err = parseReplayTime(*to)
+		if err != nil {
+			return WrapError("invalid end time", err)
+		}
+	}
+
+	config, err := NewConfig()
+	if err != nil {
+		return WrapError("failed to load config", err)
+	}
+	db, err := NewDB(config)
+	if err != nil {
+		return WrapError("failed to init database", err)
+	}
+	tg := &Telegram{db: db, config: config}
+	if *run {
+		tg.oai, err = NewOpenAI(config)
+		if err != nil {
+			return WrapError("failed to init OpenAI", err)
+		}
+	}
+
+	entries, err := db.GetChatHistoryInTimeRange(ChatID(*chatID), start, end)
+	if err != nil {
+		return WrapError("failed to get chat history", err)
+	}
+	if *output != "" {
+		err = os.MkdirAll(*output, 0o755)
+		if err != nil {
+			return WrapError("failed to create output directory", err)
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.BotMsg == "" {
+			continue
+		}
+		result, err := tg.replayEntry(entry)
+		if err != nil {
+			return WrapError(fmt.Sprintf("failed to replay chat history entry %d", entry.ID), err)
+		}
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return WrapError("failed to marshal replay result", err)
+		}
+		if *output == "" {
+			fmt.Println(string(data))
+			continue
+		}
+		err = os.WriteFile(filepath.Join(*output, fmt.Sprintf("%d.json", entry.ID)), append(data, '\n'), 0o644)
+		if err != nil {
+			return WrapError("failed to write replay result", err)
+		}
+	}
+	log.Info().Int("entries", len(entries)).Bool("run", *run).Msg("Replay finished")
+	return nil
+}
+
+// parseReplayTime parses a time given on the command line.
+func parseReplayTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// entryPrompt reconstructs the prompt of a stored chat history entry the way the live path built
+// it, from the history stored before the entry, including the related messages recalled from it.
+func (tg *Telegram) entryPrompt(entry ChatHistory) ([]map[string]string, error) {
+	var since time.Time
+	if boundary := tg.contextBoundary(entry.ChatID); boundary.Before(entry.LastUsed) {
+		since = boundary
+	}
+	history, err := tg.db.GetChatHistoryBefore(entry.ChatID, since, entry.LastUsed, tg.historyLimit(entry.ChatID))
+	if err != nil {
+		return nil, WrapError("failed to get earlier chat history", err)
+	}
+	instruction, err := tg.systemInstruction(entry.ChatID, entry.LanguageCode)
+	if err != nil {
+		return nil, WrapError("failed to build system instruction", err)
+	}
+	instruction += tg.recalledContext(entry.ChatID, entry.UserMsg, history, since, entry.LastUsed)
+	current := map[string]string{
+		"role": "user", "content": formatUserMessage(entry.UserID, entry.UserName, entry.LastUsed, entry.ReplyContext+tg.promptInput(entry.UserMsg)),
+	}
+	return tg.buildPrompt(instruction, history, func(seen map[uint]bool) []map[string]string {
+		if entry.ReplyToMessageID == 0 || tg.config.TelegramReplyChainDepth <= 0 {
+			return nil
+		}
+		chain, _ := tg.storedReplyChain(entry.ChatID, entry.ReplyToMessageID, seen)
+		return chain
+	}, current), nil
+}
+
+// replayEntry reconstructs the prompt of a stored chat history entry the way the live path built
+// it, from the history stored before the entry. The dominant chat language is taken from the
+// current data, and replies to messages that were never stored are missing from the reply chain.
+// When a model client is set, the prompt is sent to it without provider-side threading.
+func (tg *Telegram) replayEntry(entry ChatHistory) (ReplayResult, error) {
+	result := ReplayResult{
+		HistoryID:     entry.ID,
+		ChatID:        entry.ChatID,
+		SentAt:        entry.LastUsed,
+		OriginalReply: entry.BotMsg,
+	}
+
+	var err error
+	result.Messages, err = tg.entryPrompt(entry)
+	if err != nil {
+		return result, err
+	}
+	result.Temperature = tg.responseTemperature(entry.ChatID, entry.UserMsg)
+
+	if tg.oai == nil {
+		return result, nil
+	}
+	result.ReplayedReply, err = tg.oai.CallWithOptions(result.Messages, CallOptions{Temperature: result.Temperature, Model: tg.chatModel(entry.ChatID), MaxTokens: tg.verbosityMaxTokens(entry.ChatID)})
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result, nil
+}
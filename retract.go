@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// handleMrlRetractRequest processes the /mrl_retract command, sent as a reply to a bot message. It
+// deletes the message from the chat and excludes it from future context.
+func (tg *Telegram) handleMrlRetractRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_RETRACT request")
+
+	target := ctx.EffectiveMessage.ReplyToMessage
+	if target == nil || target.From == nil || target.From.Id != tg.bot.Id {
+		return tg.sendTelegramMessage(ctx, "Reply to a bot message with /mrl_retract to retract it.")
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	retracted, err := tg.db.RetractBotMessage(chatID, MessageID(target.MessageId))
+	if err != nil {
+		return WrapError("failed to retract bot message", err)
+	}
+
+	// Telegram only lets bots delete messages up to 48 hours old, so the retraction is kept even
+	// when the deletion fails
+	_, err = tg.bot.DeleteMessage(int64(chatID), target.MessageId, nil)
+	if err != nil {
+		log.Warn().Err(err).Int64("chat_id", int64(chatID)).Int64("message_id", target.MessageId).Msg("Failed to delete retracted message")
+		if !retracted {
+			return tg.sendTelegramMessage(ctx, "Could not delete the message, and it is not part of the stored history.")
+		}
+		return tg.sendTelegramMessage(ctx, "Could not delete the message, but it will no longer be used as context.")
+	}
+	_, err = tg.bot.DeleteMessage(int64(chatID), ctx.EffectiveMessage.MessageId, nil)
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to delete retract command")
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// Actions of chat rules.
+const (
+	RuleActionReply = "reply" // Answer with the rule response
+	RuleActionReact = "react" // React with the rule response, an emoji
+)
+
+// Chat rule limits.
+const (
+	maxChatRules       = 50   // Maximum number of rules per chat
+	maxRuleResponseLen = 1000 // Maximum number of characters of a rule response
+)
+
+// matchRule returns the first rule of the chat whose pattern matches the message.
+func (tg *Telegram) matchRule(chatID ChatID, message string) (ChatRule, bool) {
+	rules, err := tg.db.GetChatRules(chatID)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to get chat rules")
+		return ChatRule{}, false
+	}
+	for _, rule := range rules {
+		pattern, err := compileTriggers(rule.Pattern)
+		if err != nil {
+			log.Error().Err(err).Int64("chat_id", int64(chatID)).Uint("rule_id", rule.ID).Msg("Invalid chat rule pattern")
+			continue
+		}
+		if pattern.MatchString(message) {
+			return rule, true
+		}
+	}
+	return ChatRule{}, false
+}
+
+// applyRules answers a request with the first matching rule of the chat, without calling the
+// model. It reports whether a rule handled the request.
+func (tg *Telegram) applyRules(ctx *ext.Context, message string) (bool, error) {
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	rule, ok := tg.matchRule(chatID, message)
+	if !ok {
+		return false, nil
+	}
+	log.Info().Int64("chat_id", int64(chatID)).Uint("rule_id", rule.ID).Str("action", rule.Action).Msg("Answered request with chat rule")
+
+	switch rule.Action {
+	case RuleActionReact:
+		_, err := tg.bot.SetMessageReaction(int64(chatID), ctx.EffectiveMessage.MessageId, &gotgbot.SetMessageReactionOpts{
+			Reaction: []gotgbot.ReactionType{gotgbot.ReactionTypeEmoji{Emoji: rule.Response}},
+		})
+		if err != nil {
+			return true, WrapError("failed to react with chat rule", err)
+		}
+	default:
+		err := tg.sendTelegramMessage(ctx, rule.Response)
+		if err != nil {
+			return true, WrapError("failed to answer with chat rule", err)
+		}
+	}
+	return true, nil
+}
+
+// handleMrlRuleRequest processes the /mrl_rule command, which lists, adds or removes the rules
+// answering matching requests of the chat without calling the model.
+func (tg *Telegram) handleMrlRuleRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_RULE request")
+
+	usage := `/mrl_rule [list|add "<word, word, /regex/>" "<response>" [action=reply|react]|remove <id>]`
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 3, "action")
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	switch args.Arg(0) {
+	case "", "list":
+		rules, err := tg.db.GetChatRules(chatID)
+		if err != nil {
+			return WrapError("failed to get chat rules", err)
+		}
+		if len(rules) == 0 {
+			return tg.sendTelegramMessage(ctx, "No rules in this chat.")
+		}
+		var sb strings.Builder
+		for _, rule := range rules {
+			line := fmt.Sprintf("%d. %s -> %s: %s\n", rule.ID, rule.Pattern, rule.Action, rule.Response)
+			if sb.Len()+len(line) > listMessageLength {
+				break
+			}
+			sb.WriteString(line)
+		}
+		return tg.sendTelegramMessage(ctx, strings.TrimSpace(sb.String()))
+
+	case "add":
+		pattern, response := strings.TrimSpace(args.Arg(1)), strings.TrimSpace(args.Arg(2))
+		action := args.Named["action"]
+		if action == "" {
+			action = RuleActionReply
+		}
+		if pattern == "" || response == "" || (action != RuleActionReply && action != RuleActionReact) {
+			return tg.sendUsage(ctx, usage, nil)
+		}
+		_, err := compileTriggers(pattern)
+		if err != nil {
+			return tg.sendTelegramMessage(ctx, "Invalid rule pattern.")
+		}
+		if len([]rune(response)) > maxRuleResponseLen {
+			return tg.sendTelegramMessage(ctx, fmt.Sprintf("Response is longer than %d characters.", maxRuleResponseLen))
+		}
+		rules, err := tg.db.GetChatRules(chatID)
+		if err != nil {
+			return WrapError("failed to get chat rules", err)
+		}
+		if len(rules) >= maxChatRules {
+			return tg.sendTelegramMessage(ctx, fmt.Sprintf("This chat already has %d rules, remove one first.", len(rules)))
+		}
+		rule := ChatRule{ChatID: chatID, Pattern: pattern, Action: action, Response: response, CreatedAt: time.Now()}
+		err = tg.db.AddChatRule(&rule)
+		if err != nil {
+			return WrapError("failed to add chat rule", err)
+		}
+		return tg.sendTelegramMessage(ctx, fmt.Sprintf("Rule %d added.", rule.ID))
+
+	case "remove":
+		id, err := strconv.ParseUint(args.Arg(1), 10, 64)
+		if err != nil {
+			return tg.sendUsage(ctx, usage, nil)
+		}
+		removed, err := tg.db.DeleteChatRule(chatID, uint(id))
+		if err != nil {
+			return WrapError("failed to delete chat rule", err)
+		}
+		if !removed {
+			return tg.sendTelegramMessage(ctx, fmt.Sprintf("No rule with ID %d in this chat.", id))
+		}
+		return tg.sendTelegramMessage(ctx, fmt.Sprintf("Rule %d removed.", id))
+	}
+	return tg.sendUsage(ctx, usage, nil)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// SchemaColumn describes a column of a database table.
+type SchemaColumn struct {
+	Name       string // Name of the column
+	Type       string // Declared type of the column
+	NotNull    bool   // Whether the column is NOT NULL
+	PrimaryKey bool   // Whether the column is part of the primary key
+}
+
+// SchemaForeignKey describes a foreign key of a database table.
+type SchemaForeignKey struct {
+	Column   string // Column holding the reference
+	Table    string // Referenced table
+	ToColumn string // Referenced column
+}
+
+// SchemaTable describes a database table and what it stores.
+type SchemaTable struct {
+	Name        string             // Name of the table
+	Columns     []SchemaColumn     // Columns of the table
+	Indexes     []string           // Names of the indexes on the table
+	ForeignKeys []SchemaForeignKey // Foreign keys of the table
+	Rows        int64              // Number of rows
+	Bytes       int64              // Size on disk including indexes, or -1 when unknown
+}
+
+// runSchema runs the schema subcommand, which reports the tables of the database or exports them
+// as an entity relationship diagram.
+func runSchema(args []string) error {
+	flags := flag.NewFlagSet("schema", flag.ContinueOnError)
+	dbName := flags.String("db", defaultDBName(), "database to inspect")
+	format := flags.String("format", "text", "output format: text, mermaid, or dot")
+	err := flags.Parse(args)
+	if err != nil {
+		return WrapError("failed to parse flags", err)
+	}
+
+	_, err = os.Stat(*dbName)
+	if err != nil {
+		return WrapError("failed to find database", err)
+	}
+	conn, err := sql.Open("sqlite3", "file:"+*dbName+"?mode=ro")
+	if err != nil {
+		return WrapError("failed to open database", err)
+	}
+	defer conn.Close()
+
+	tables, err := inspectSchema(conn)
+	if err != nil {
+		return WrapError("failed to inspect schema", err)
+	}
+
+	switch *format {
+	case "text":
+		var pageCount, pageSize int64
+		err = conn.QueryRow("SELECT page_count, page_size FROM pragma_page_count(), pragma_page_size()").Scan(&pageCount, &pageSize)
+		if err != nil {
+			return WrapError("failed to get database size", err)
+		}
+		fmt.Printf("Database %s (%d bytes)\n\n", *dbName, pageCount*pageSize)
+		writeSchemaReport(os.Stdout, tables)
+	case "mermaid":
+		writeSchemaMermaid(os.Stdout, tables)
+	case "dot":
+		writeSchemaDot(os.Stdout, tables)
+	default:
+		return WrapError(fmt.Sprintf("unknown format %q", *format))
+	}
+	return nil
+}
+
+// inspectSchema reads the tables of a database with their columns, indexes, foreign keys, row
+// counts, and sizes. Sizes are only known when SQLite is built with the dbstat virtual table.
+func inspectSchema(conn *sql.DB) ([]SchemaTable, error) {
+	names, err := queryStrings(conn, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, WrapError("failed to list tables", err)
+	}
+	_, dbstatErr := conn.Exec("SELECT 1 FROM dbstat LIMIT 1")
+
+	var tables []SchemaTable
+	for _, name := range names {
+		table := SchemaTable{Name: name, Bytes: -1}
+
+		rows, err := conn.Query(`SELECT name, type, "notnull", pk FROM pragma_table_info(?)`, name)
+		if err != nil {
+			return nil, WrapError("failed to read columns", err)
+		}
+		for rows.Next() {
+			var column SchemaColumn
+			var primaryKey int
+			err = rows.Scan(&column.Name, &column.Type, &column.NotNull, &primaryKey)
+			if err != nil {
+				rows.Close()
+				return nil, WrapError("failed to scan column", err)
+			}
+			column.PrimaryKey = primaryKey > 0
+			table.Columns = append(table.Columns, column)
+		}
+		rows.Close()
+
+		rows, err = conn.Query(`SELECT "table", "from", "to" FROM pragma_foreign_key_list(?)`, name)
+		if err != nil {
+			return nil, WrapError("failed to read foreign keys", err)
+		}
+		for rows.Next() {
+			var foreignKey SchemaForeignKey
+			var toColumn sql.NullString
+			err = rows.Scan(&foreignKey.Table, &foreignKey.Column, &toColumn)
+			if err != nil {
+				rows.Close()
+				return nil, WrapError("failed to scan foreign key", err)
+			}
+			foreignKey.ToColumn = toColumn.String
+			table.ForeignKeys = append(table.ForeignKeys, foreignKey)
+		}
+		rows.Close()
+
+		table.Indexes, err = queryStrings(conn, "SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = ? ORDER BY name", name)
+		if err != nil {
+			return nil, WrapError("failed to list indexes", err)
+		}
+
+		err = conn.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %q", name)).Scan(&table.Rows)
+		if err != nil {
+			return nil, WrapError("failed to count rows", err)
+		}
+		if dbstatErr == nil {
+			objects := append([]string{name}, table.Indexes...)
+			query := "SELECT COALESCE(SUM(pgsize), 0) FROM dbstat WHERE name IN (?" + strings.Repeat(", ?", len(objects)-1) + ")"
+			params := make([]interface{}, len(objects))
+			for i, object := range objects {
+				params[i] = object
+			}
+			err = conn.QueryRow(query, params...).Scan(&table.Bytes)
+			if err != nil {
+				return nil, WrapError("failed to get table size", err)
+			}
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// queryStrings runs a query returning a single text column.
+func queryStrings(conn *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		err := rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// writeSchemaReport writes a human readable report of the tables.
+func writeSchemaReport(w io.Writer, tables []SchemaTable) {
+	for _, table := range tables {
+		size := "unknown size"
+		if table.Bytes >= 0 {
+			size = fmt.Sprintf("%d bytes", table.Bytes)
+		}
+		fmt.Fprintf(w, "%s (%d rows, %s)\n", table.Name, table.Rows, size)
+		for _, column := range table.Columns {
+			var flags []string
+			if column.PrimaryKey {
+				flags = append(flags, "primary key")
+			}
+			if column.NotNull {
+				flags = append(flags, "not null")
+			}
+			line := fmt.Sprintf("  %s %s", column.Name, column.Type)
+			if len(flags) > 0 {
+				line += " (" + strings.Join(flags, ", ") + ")"
+			}
+			fmt.Fprintln(w, line)
+		}
+		for _, foreignKey := range table.ForeignKeys {
+			fmt.Fprintf(w, "  foreign key %s -> %s.%s\n", foreignKey.Column, foreignKey.Table, foreignKey.ToColumn)
+		}
+		for _, index := range table.Indexes {
+			fmt.Fprintf(w, "  index %s\n", index)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// mermaidTypePattern matches characters not allowed in Mermaid attribute types.
+var mermaidTypePattern = regexp.MustCompile(`\W+`)
+
+// writeSchemaMermaid writes the tables as a Mermaid entity relationship diagram.
+func writeSchemaMermaid(w io.Writer, tables []SchemaTable) {
+	fmt.Fprintln(w, "erDiagram")
+	for _, table := range tables {
+		fmt.Fprintf(w, "    %s {\n", table.Name)
+		for _, column := range table.Columns {
+			columnType := mermaidTypePattern.ReplaceAllString(column.Type, "_")
+			if columnType == "" {
+				columnType = "ANY"
+			}
+			key := ""
+			if column.PrimaryKey {
+				key = " PK"
+			}
+			fmt.Fprintf(w, "        %s %s%s\n", columnType, column.Name, key)
+		}
+		fmt.Fprintln(w, "    }")
+	}
+	for _, table := range tables {
+		for _, foreignKey := range table.ForeignKeys {
+			fmt.Fprintf(w, "    %s ||--o{ %s : %q\n", foreignKey.Table, table.Name, foreignKey.Column)
+		}
+	}
+}
+
+// writeSchemaDot writes the tables as a Graphviz DOT graph.
+func writeSchemaDot(w io.Writer, tables []SchemaTable) {
+	fmt.Fprintln(w, "digraph schema {")
+	fmt.Fprintln(w, "  node [shape=record];")
+	for _, table := range tables {
+		var columns []string
+		for _, column := range table.Columns {
+			columns = append(columns, strings.TrimSpace(column.Name+" "+column.Type))
+		}
+		fmt.Fprintf(w, "  %q [label=\"{%s|%s\\l}\"];\n", table.Name, table.Name, strings.Join(columns, "\\l"))
+	}
+	for _, table := range tables {
+		for _, foreignKey := range table.ForeignKeys {
+			fmt.Fprintf(w, "  %q -> %q [label=%q];\n", table.Name, foreignKey.Table, foreignKey.Column)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
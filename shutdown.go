@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// shutdownStage is a step of the shutdown, bounded by its timeout.
+type shutdownStage struct {
+	name    string        // Name used in the logs
+	timeout time.Duration // Time the stage may take before the next one starts
+	run     func() error  // Stops a component
+}
+
+// ShutdownManager runs the shutdown stages of the components in the order they were added.
+type ShutdownManager struct {
+	stages []shutdownStage
+}
+
+// Add appends a stage to the shutdown.
+func (manager *ShutdownManager) Add(name string, timeout time.Duration, run func() error) {
+	manager.stages = append(manager.stages, shutdownStage{name: name, timeout: timeout, run: run})
+}
+
+// Run runs the stages in order. A stage that fails or times out is logged, and the shutdown goes
+// on with the next one while the timed out stage keeps running in the background.
+func (manager *ShutdownManager) Run() {
+	for _, stage := range manager.stages {
+		start := time.Now()
+		done := make(chan error, 1)
+		go func(stage shutdownStage) {
+			done <- stage.run()
+		}(stage)
+
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Error().Err(err).Str("stage", stage.name).Msg("Shutdown stage failed")
+			} else {
+				log.Info().Str("stage", stage.name).Dur("duration", time.Since(start)).Msg("Shutdown stage finished")
+			}
+		case <-time.After(stage.timeout):
+			log.Warn().Str("stage", stage.name).Dur("timeout", stage.timeout).Msg("Shutdown stage timed out")
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rs/zerolog/log"
+)
+
+// snapshotFormatVersion is the version of the snapshot layout written by this binary.
+const snapshotFormatVersion = 1
+
+// Files stored in a snapshot archive.
+const (
+	snapshotManifestFile = "manifest.json"
+	snapshotDBFile       = "storage.db"
+	snapshotConfigFile   = "config.env"
+)
+
+// SnapshotManifest describes the content of a snapshot archive.
+type SnapshotManifest struct {
+	FormatVersion int               `json:"format_version"` // Version of the snapshot layout
+	BotVersion    string            `json:"bot_version"`    // Version of the bot that created the snapshot
+	CreatedAt     time.Time         `json:"created_at"`     // Time the snapshot was created
+	Files         map[string]string `json:"files"`          // SHA-256 checksums by file name
+}
+
+// runSnapshot runs the snapshot subcommand.
+func runSnapshot(args []string) error {
+	usage := "usage: murailobot snapshot create [-db path] [-o file] | restore [-db path] [-force] file"
+	if len(args) == 0 {
+		return WrapError(usage)
+	}
+
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("snapshot create", flag.ContinueOnError)
+		dbName := flags.String("db", defaultDBName(), "database to snapshot")
+		output := flags.String("o", fmt.Sprintf("murailobot-%s.tar.zst", time.Now().Format("20060102-150405")), "snapshot file to write")
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return WrapError("failed to parse flags", err)
+		}
+		return createSnapshot(*dbName, *output)
+	case "restore":
+		flags := flag.NewFlagSet("snapshot restore", flag.ContinueOnError)
+		dbName := flags.String("db", defaultDBName(), "database to restore into")
+		force := flags.Bool("force", false, "overwrite an existing database")
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return WrapError("failed to parse flags", err)
+		}
+		if flags.NArg() != 1 {
+			return WrapError(usage)
+		}
+		return restoreSnapshot(flags.Arg(0), *dbName, *force)
+	default:
+		return WrapError(usage)
+	}
+}
+
+// snapshotConfig returns the bot configuration from the environment as shell exports, with
+// tokens and secrets redacted.
+func snapshotConfig() []byte {
+	var lines []string
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(key, "MURAILOBOT_") {
+			continue
+		}
+		if strings.Contains(key, "TOKEN") || strings.Contains(key, "SECRET") {
+			value = "REDACTED"
+		}
+		lines = append(lines, fmt.Sprintf("export %s=%q", key, value))
+	}
+	sort.Strings(lines)
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// createSnapshot writes a consistent copy of the database and the configuration into a
+// zstd-compressed tar archive.
+func createSnapshot(dbName, output string) error {
+	_, err := os.Stat(dbName)
+	if err != nil {
+		return WrapError("failed to find database", err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "murailobot-snapshot")
+	if err != nil {
+		return WrapError("failed to create temporary directory", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Copy the database with VACUUM INTO, which is consistent even while the bot is running
+	dbCopy := filepath.Join(tmpDir, snapshotDBFile)
+	conn, err := sql.Open("sqlite3", dbName)
+	if err != nil {
+		return WrapError("failed to open database", err)
+	}
+	_, err = conn.Exec("VACUUM INTO ?", dbCopy)
+	conn.Close()
+	if err != nil {
+		return WrapError("failed to copy database", err)
+	}
+	dbData, err := os.ReadFile(dbCopy)
+	if err != nil {
+		return WrapError("failed to read database copy", err)
+	}
+
+	files := map[string][]byte{
+		snapshotDBFile:     dbData,
+		snapshotConfigFile: snapshotConfig(),
+	}
+	manifest := SnapshotManifest{
+		FormatVersion: snapshotFormatVersion,
+		BotVersion:    version,
+		CreatedAt:     time.Now().UTC(),
+		Files:         make(map[string]string),
+	}
+	for name, data := range files {
+		sum := sha256.Sum256(data)
+		manifest.Files[name] = hex.EncodeToString(sum[:])
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return WrapError("failed to marshal manifest", err)
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return WrapError("failed to create snapshot file", err)
+	}
+	defer out.Close()
+	zw, err := zstd.NewWriter(out)
+	if err != nil {
+		return WrapError("failed to create zstd writer", err)
+	}
+	tw := tar.NewWriter(zw)
+
+	// The manifest goes first so restores can check it before extracting anything
+	entries := []struct {
+		name string
+		data []byte
+	}{
+		{snapshotManifestFile, manifestData},
+		{snapshotDBFile, files[snapshotDBFile]},
+		{snapshotConfigFile, files[snapshotConfigFile]},
+	}
+	for _, entry := range entries {
+		err = tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0o600, Size: int64(len(entry.data)), ModTime: manifest.CreatedAt})
+		if err != nil {
+			return WrapError("failed to write tar header", err)
+		}
+		_, err = tw.Write(entry.data)
+		if err != nil {
+			return WrapError("failed to write tar entry", err)
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return WrapError("failed to close tar writer", err)
+	}
+	err = zw.Close()
+	if err != nil {
+		return WrapError("failed to close zstd writer", err)
+	}
+	err = out.Close()
+	if err != nil {
+		return WrapError("failed to close snapshot file", err)
+	}
+
+	log.Info().Str("file", output).Str("bot_version", version).Msg("Created snapshot")
+	return nil
+}
+
+// restoreSnapshot restores the database of a snapshot archive after checking its manifest and
+// checksums, and prints the snapshot configuration to stdout. The bot must be stopped while
+// restoring: SQLite would replay a write-ahead log left by the running bot onto the restored
+// database, so restores are refused while one exists, and -force discards it.
+func restoreSnapshot(input, dbName string, force bool) error {
+	sidecars := []string{dbName + "-wal", dbName + "-shm"}
+	if !force {
+		_, err := os.Stat(dbName)
+		if err == nil {
+			return WrapError(fmt.Sprintf("database %s already exists, use -force to overwrite it", dbName))
+		}
+		for _, sidecar := range sidecars {
+			_, err = os.Stat(sidecar)
+			if err == nil {
+				return WrapError(fmt.Sprintf("%s exists, stop the bot before restoring or use -force to discard it", sidecar))
+			}
+		}
+	}
+
+	in, err := os.Open(input)
+	if err != nil {
+		return WrapError("failed to open snapshot file", err)
+	}
+	defer in.Close()
+	zr, err := zstd.NewReader(in)
+	if err != nil {
+		return WrapError("failed to create zstd reader", err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	var manifest *SnapshotManifest
+	files := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return WrapError("failed to read snapshot", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return WrapError("failed to read snapshot entry", err)
+		}
+
+		if header.Name == snapshotManifestFile {
+			manifest = &SnapshotManifest{}
+			err = json.Unmarshal(data, manifest)
+			if err != nil {
+				return WrapError("failed to parse manifest", err)
+			}
+			if manifest.FormatVersion != snapshotFormatVersion {
+				return WrapError(fmt.Sprintf("unsupported snapshot format version %d, expected %d", manifest.FormatVersion, snapshotFormatVersion))
+			}
+			continue
+		}
+		if manifest == nil {
+			return WrapError("snapshot manifest missing or out of order")
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != manifest.Files[header.Name] {
+			return WrapError(fmt.Sprintf("checksum mismatch for %s", header.Name))
+		}
+		files[header.Name] = data
+	}
+	if manifest == nil {
+		return WrapError("snapshot manifest missing")
+	}
+	if _, ok := manifest.Files[snapshotDBFile]; !ok {
+		return WrapError(fmt.Sprintf("snapshot has no %s", snapshotDBFile))
+	}
+	for name := range manifest.Files {
+		if _, ok := files[name]; !ok {
+			return WrapError(fmt.Sprintf("snapshot file %s missing", name))
+		}
+	}
+	if manifest.BotVersion != version {
+		log.Warn().Str("snapshot_version", manifest.BotVersion).Str("bot_version", version).Msg("Snapshot was created by a different bot version")
+	}
+
+	// Write to a temporary file first so a failed restore leaves the old database untouched
+	tmpName := dbName + ".restore"
+	err = os.WriteFile(tmpName, files[snapshotDBFile], 0o600)
+	if err != nil {
+		return WrapError("failed to write database", err)
+	}
+	for _, sidecar := range sidecars {
+		err = os.Remove(sidecar)
+		if err != nil && !os.IsNotExist(err) {
+			os.Remove(tmpName)
+			return WrapError("failed to remove write-ahead log", err)
+		}
+	}
+	err = os.Rename(tmpName, dbName)
+	if err != nil {
+		os.Remove(tmpName)
+		return WrapError("failed to move database into place", err)
+	}
+
+	_, err = io.Copy(os.Stdout, bytes.NewReader(files[snapshotConfigFile]))
+	if err != nil {
+		return WrapError("failed to print configuration", err)
+	}
+	log.Info().Str("file", input).Str("db", dbName).Time("created_at", manifest.CreatedAt).Msg("Restored snapshot")
+	return nil
+}
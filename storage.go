@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// historyRetentionSetting is the settings key holding the chat history retention in days, set when
+// the retention was tightened automatically.
+const historyRetentionSetting = "history_retention_days"
+
+// storageWarnRatio is the fraction of a storage limit at which the admin is warned.
+const storageWarnRatio = 0.8
+
+// Storage levels, ordered by severity.
+const (
+	storageOK       = iota // Below the warning thresholds
+	storageWarning         // Above the warning ratio of a limit
+	storageCritical        // Above a limit
+)
+
+// storageState holds what the storage monitor remembers between checks.
+type storageState struct {
+	size  int64     // Database size at the last check
+	at    time.Time // Time of the last check
+	level int       // Storage level at the last check
+}
+
+// historyRetention returns the number of days chat history is kept, zero meaning forever.
+func (tg *Telegram) historyRetention() int {
+	value, ok, err := tg.db.GetSetting(historyRetentionSetting)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get history retention")
+	}
+	if !ok {
+		return tg.config.StorageHistoryRetention
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil {
+		log.Error().Err(err).Str("value", value).Msg("Invalid history retention setting")
+		return tg.config.StorageHistoryRetention
+	}
+	return days
+}
+
+// pruneHistory deletes the chat history older than the given retention in days.
+func (tg *Telegram) pruneHistory(days int) (int64, error) {
+	if days <= 0 {
+		return 0, nil
+	}
+	deleted, err := tg.db.DeleteChatHistoryBefore(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return 0, WrapError("failed to prune chat history", err)
+	}
+	if deleted > 0 {
+		log.Info().Int64("deleted", deleted).Int("retention_days", days).Msg("Pruned old chat history")
+	}
+	return deleted, nil
+}
+
+// storageLevel returns the level of a usage against a limit, unlimited when zero.
+func storageLevel(used, limit int64) int {
+	switch {
+	case limit <= 0:
+		return storageOK
+	case used >= limit:
+		return storageCritical
+	case float64(used) >= float64(limit)*storageWarnRatio:
+		return storageWarning
+	}
+	return storageOK
+}
+
+// storageSummary describes the database size, its growth and the largest tables.
+func storageSummary(size int64, limit int64, growth float64, counts map[string]int64) string {
+	const mb = 1 << 20
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Database size: %.1f MB", float64(size)/mb)
+	if limit > 0 {
+		fmt.Fprintf(&sb, " of %d MB", limit/mb)
+	}
+	fmt.Fprintf(&sb, " (%+.1f MB/day)\n", growth/mb)
+
+	tables := make([]string, 0, len(counts))
+	for table := range counts {
+		tables = append(tables, table)
+	}
+	sort.Slice(tables, func(i, j int) bool { return counts[tables[i]] > counts[tables[j]] })
+	if len(tables) > 5 {
+		tables = tables[:5]
+	}
+	sb.WriteString("Largest tables:")
+	for _, table := range tables {
+		fmt.Fprintf(&sb, "\n- %s: %d rows", table, counts[table])
+	}
+	return sb.String()
+}
+
+// checkStorage applies the history retention, measures the database, and warns the admin when a
+// storage threshold is crossed. Over a limit, the retention is halved when automatic retention is
+// enabled.
+func (tg *Telegram) checkStorage(state *storageState) error {
+	retention := tg.historyRetention()
+	_, err := tg.pruneHistory(retention)
+	if err != nil {
+		return err
+	}
+
+	size, err := tg.db.GetDatabaseSize()
+	if err != nil {
+		return WrapError("failed to get database size", err)
+	}
+	counts, err := tg.db.GetTableRowCounts()
+	if err != nil {
+		return WrapError("failed to get table row counts", err)
+	}
+	now := time.Now()
+	var growth float64
+	if !state.at.IsZero() {
+		growth = float64(size-state.size) / now.Sub(state.at).Hours() * 24
+	}
+	state.size, state.at = size, now
+
+	sizeLimit := int64(tg.config.StorageMaxSize) << 20
+	level := max(storageLevel(size, sizeLimit), storageLevel(counts["chat_history"], int64(tg.config.StorageMaxHistoryRows)))
+	log.Info().Int64("size", size).Float64("growth_per_day", growth).Int64("chat_history", counts["chat_history"]).Int("level", level).Msg("Checked storage")
+	tg.analytics.Record(AnalyticsJobRun, 0, 0, map[string]interface{}{"job": "storage_check", "size": size, "level": level})
+
+	previous := state.level
+	state.level = level
+	if level <= previous {
+		return nil
+	}
+
+	summary := storageSummary(size, sizeLimit, growth, counts)
+	if level == storageWarning {
+		return tg.notifyAdmin("Storage is approaching its limits.\n" + summary)
+	}
+	if !tg.config.StorageAutoRetention {
+		return tg.notifyAdmin("Storage limit exceeded.\n" + summary)
+	}
+	return tg.tightenRetention(retention, summary)
+}
+
+// tightenRetention halves the chat history retention, down to the configured minimum, prunes the
+// history and compacts the database, notifying the admin of the change.
+func (tg *Telegram) tightenRetention(retention int, summary string) error {
+	if retention <= 0 {
+		oldest, err := tg.db.GetOldestChatHistoryTime()
+		if err != nil {
+			return WrapError("failed to get oldest chat history entry", err)
+		}
+		if !oldest.IsZero() {
+			retention = int(time.Since(oldest).Hours() / 24)
+		}
+	}
+	tightened := max(retention/2, tg.config.StorageMinRetention)
+	if retention > 0 && tightened >= retention {
+		return tg.notifyAdmin(fmt.Sprintf("Storage limit exceeded, and the history retention is already at the minimum of %d days.\n%s", tg.config.StorageMinRetention, summary))
+	}
+
+	err := tg.db.SetSetting(historyRetentionSetting, strconv.Itoa(tightened))
+	if err != nil {
+		return WrapError("failed to store history retention", err)
+	}
+	deleted, err := tg.pruneHistory(tightened)
+	if err != nil {
+		return err
+	}
+	err = tg.db.Vacuum()
+	if err != nil {
+		return WrapError("failed to compact database", err)
+	}
+	log.Warn().Int("retention_days", tightened).Int64("deleted", deleted).Msg("Tightened history retention")
+	return tg.notifyAdmin(fmt.Sprintf("Storage limit exceeded. History retention tightened to %d days, deleting %d entries.\n%s", tightened, deleted, summary))
+}
+
+// runStorageMonitor checks the storage at startup and then once per check interval, until the
+// jobs are stopped.
+func (tg *Telegram) runStorageMonitor() {
+	ticker := time.NewTicker(time.Duration(tg.config.StorageCheckInterval * float64(time.Minute)))
+	defer ticker.Stop()
+	state := &storageState{}
+	for {
+		err := tg.checkStorage(state)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to check storage")
+		}
+		select {
+		case <-tg.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// streamingSuffix marks a reply that is still being generated.
+const streamingSuffix = " …"
+
+// maxMessageLength is Telegram's limit on the length of a message. Longer streamed replies are
+// cut at it, since editing the message to a longer text fails.
+const maxMessageLength = 4096
+
+// StreamingReply is a reply shown while it is being generated. It is sent once the first part
+// arrives and then edited at most once per stream interval. Partial text is sent as plain text,
+// since incomplete markup would not parse.
+type StreamingReply struct {
+	tg       *Telegram
+	replyTo  *gotgbot.Message
+	sent     *gotgbot.Message // Message showing the reply, nil until the first part is sent
+	shown    string           // Text currently shown
+	lastEdit time.Time        // When the shown text last changed
+	failed   bool             // Whether showing the partial reply failed, which stops the updates
+}
+
+// canStream reports whether the reply to a message in a chat can be streamed. Replies waiting
+// for approval or review, threaded replies and replies that may call tools are only sent
+// complete.
+func (tg *Telegram) canStream(chatID ChatID, opts CallOptions) bool {
+	if !tg.flags.Enabled(FlagStreaming, chatID) {
+		return false
+	}
+	if len(opts.Tools) > 0 || (tg.config.OpenAIThreading && !tg.config.Stateless) {
+		return false
+	}
+	return !tg.requiresApproval(chatID) && !tg.critiqueEnabled(chatID)
+}
+
+// newStreamingReply starts a streamed reply to a message.
+func (tg *Telegram) newStreamingReply(replyTo *gotgbot.Message) *StreamingReply {
+	return &StreamingReply{tg: tg, replyTo: replyTo, lastEdit: time.Now()}
+}
+
+// update shows the content generated so far, unless it was shown less than a stream interval ago.
+func (stream *StreamingReply) update(content string) {
+	tg := stream.tg
+	if stream.failed || time.Since(stream.lastEdit) < time.Duration(tg.config.TelegramStreamInterval*float64(time.Second)) {
+		return
+	}
+	text := strings.TrimSpace(content)
+	if runes := []rune(text); len(runes) > listMessageLength {
+		text = string(runes[:listMessageLength])
+	}
+	text += streamingSuffix
+	if text == stream.shown {
+		return
+	}
+
+	chatID := ChatID(stream.replyTo.Chat.Id)
+	if stream.sent == nil {
+		sent, err := tg.newMessage(chatID, text).ReplyTo(stream.replyTo).PlainText().Send()
+		if err != nil || sent == nil {
+			log.Warn().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to send streamed reply, sending it when complete")
+			stream.failed = true
+			return
+		}
+		stream.sent = sent
+	} else {
+		_, _, err := tg.bot.EditMessageText(text, &gotgbot.EditMessageTextOpts{ChatId: stream.sent.Chat.Id, MessageId: stream.sent.MessageId})
+		if err != nil {
+			log.Warn().Err(err).Int64("chat_id", int64(chatID)).Int64("message_id", stream.sent.MessageId).Msg("Failed to update streamed reply")
+			stream.failed = true
+			return
+		}
+	}
+	stream.shown = text
+	stream.lastEdit = time.Now()
+}
+
+// finish shows the complete reply in the chat's parse mode and returns the message showing it.
+// Replies generated within the first stream interval are simply sent, as are replies whose
+// partial message could not be completed.
+func (stream *StreamingReply) finish(content string) (*gotgbot.Message, error) {
+	tg := stream.tg
+	if runes := []rune(content); len(runes) > maxMessageLength {
+		content = string(runes[:maxMessageLength-len([]rune(streamingSuffix))]) + streamingSuffix
+	}
+	if stream.sent == nil {
+		return tg.newMessage(ChatID(stream.replyTo.Chat.Id), content).ReplyTo(stream.replyTo).Send()
+	}
+
+	parseMode, _, err := tg.db.GetChatSetting(ChatID(stream.sent.Chat.Id), chatParseModeSetting)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", stream.sent.Chat.Id).Msg("Failed to get parse mode, showing streamed reply as plain text")
+	}
+	opts := &gotgbot.EditMessageTextOpts{ChatId: stream.sent.Chat.Id, MessageId: stream.sent.MessageId, ParseMode: parseMode}
+	_, _, err = tg.bot.EditMessageText(content, opts)
+	if err != nil && opts.ParseMode != "" && isParseError(err) {
+		log.Warn().Err(err).Int64("chat_id", opts.ChatId).Str("parse_mode", opts.ParseMode).Msg("Showing streamed reply as plain text")
+		opts.ParseMode = ""
+		_, _, err = tg.bot.EditMessageText(content, opts)
+	}
+	if err != nil {
+		log.Warn().Err(err).Int64("chat_id", opts.ChatId).Int64("message_id", opts.MessageId).Msg("Failed to complete streamed reply, sending it again")
+		stream.discard()
+		return tg.newMessage(ChatID(stream.replyTo.Chat.Id), content).ReplyTo(stream.replyTo).Send()
+	}
+	return stream.sent, nil
+}
+
+// discard deletes the partial reply after the generation failed.
+func (stream *StreamingReply) discard() {
+	if stream.sent == nil {
+		return
+	}
+	_, err := stream.tg.bot.DeleteMessage(stream.sent.Chat.Id, stream.sent.MessageId, nil)
+	if err != nil {
+		log.Warn().Err(err).Int64("chat_id", stream.sent.Chat.Id).Int64("message_id", stream.sent.MessageId).Msg("Failed to delete partial streamed reply")
+	}
+	stream.sent = nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// describeStructured returns a text representation of a poll, contact or location message, or an
+// empty string for other messages. Phone numbers of shared contacts are left out.
+func describeStructured(msg *gotgbot.Message) string {
+	switch {
+	case msg.Poll != nil:
+		options := make([]string, 0, len(msg.Poll.Options))
+		for _, option := range msg.Poll.Options {
+			options = append(options, fmt.Sprintf("%q (%d)", option.Text, option.VoterCount))
+		}
+		state := "open"
+		if msg.Poll.IsClosed {
+			state = "closed"
+		}
+		return fmt.Sprintf("[poll, %s: %q options: %s]", state, msg.Poll.Question, strings.Join(options, ", "))
+	case msg.Contact != nil:
+		return fmt.Sprintf("[contact: %s]", strings.TrimSpace(msg.Contact.FirstName+" "+msg.Contact.LastName))
+	case msg.Location != nil:
+		return fmt.Sprintf("[location: %.5f, %.5f]", msg.Location.Latitude, msg.Location.Longitude)
+	}
+	return ""
+}
+
+// isStructuredMessage reports whether a message is a poll, contact or location.
+func isStructuredMessage(msg *gotgbot.Message) bool {
+	return describeStructured(msg) != ""
+}
+
+// handleStructuredMessage stores polls, contacts and locations as unanswered chat history entries,
+// so they are part of the context of later requests.
+func (tg *Telegram) handleStructuredMessage(b *gotgbot.Bot, ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil || ctx.EffectiveMessage.From == nil {
+		return nil
+	}
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	tg.recordActivity(ctx)
+	if tg.config.Stateless || !tg.learnsFrom(chatID) || !tg.flags.Enabled(FlagStructuredContext, chatID) {
+		return nil
+	}
+	blocked, err := tg.db.IsUserBlocked(chatID, UserID(ctx.EffectiveMessage.From.Id))
+	if err != nil {
+		return WrapError("failed to check blocked user", err)
+	}
+	if blocked {
+		return nil
+	}
+	componentLog(LogIncoming).Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received structured message")
+
+	historyRecord := ChatHistory{
+		ChatID:       chatID,
+		UserID:       UserID(ctx.EffectiveMessage.From.Id),
+		UserName:     ctx.EffectiveMessage.From.Username,
+		UserMsg:      describeStructured(ctx.EffectiveMessage),
+		LastUsed:     time.Now(),
+		LanguageCode: ctx.EffectiveMessage.From.LanguageCode,
+		MessageID:    MessageID(ctx.EffectiveMessage.MessageId),
+	}
+	if ctx.EffectiveMessage.ReplyToMessage != nil {
+		historyRecord.ReplyToMessageID = MessageID(ctx.EffectiveMessage.ReplyToMessage.MessageId)
+	}
+	err = tg.db.AddChatHistory(&historyRecord)
+	if err != nil {
+		return WrapError("failed to add structured message to chat history", err)
+	}
+	log.Debug().Int64("chat_id", int64(chatID)).Str("content", historyRecord.UserMsg).Msg("Stored structured message")
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+)
+
+// chatStyleHintSetting is the chat settings key holding the writing style hint of a chat.
+const chatStyleHintSetting = "style_hint"
+
+const (
+	styleRefreshInterval = 7 * 24 * time.Hour // How often style hints are refreshed
+	styleSampleSize      = 500                // Maximum number of messages analyzed per chat
+	styleMinSamples      = 20                 // Minimum number of messages needed for a hint
+)
+
+// laughterMarkers are common ways of writing laughter, which set the tone of a chat.
+var laughterMarkers = []string{"kkk", "haha", "hehe", "rsrs", "lol", "lmao"}
+
+// StyleProfile summarizes how the users of a chat write.
+type StyleProfile struct {
+	Messages     int     // Number of analyzed messages
+	AvgLength    float64 // Average message length in characters
+	EmojiPerMsg  float64 // Average number of emoji per message
+	Lowercase    float64 // Share of messages that start in lowercase
+	Laughter     string  // Most used laughter marker, if any
+	LaughterRate float64 // Share of messages containing the laughter marker
+}
+
+// isEmoji reports whether a rune is in one of the main emoji blocks.
+func isEmoji(r rune) bool {
+	return (r >= 0x1F300 && r <= 0x1FAFF) || (r >= 0x2600 && r <= 0x27BF)
+}
+
+// analyzeStyle builds the style profile of a set of messages.
+func analyzeStyle(messages []string) StyleProfile {
+	profile := StyleProfile{Messages: len(messages)}
+	if len(messages) == 0 {
+		return profile
+	}
+
+	var length, emoji, lowercase int
+	laughter := make(map[string]int)
+	for _, message := range messages {
+		length += utf8.RuneCountInString(message)
+		for _, r := range message {
+			if isEmoji(r) {
+				emoji++
+			}
+		}
+		first, _ := utf8.DecodeRuneInString(message)
+		if unicode.IsLower(first) {
+			lowercase++
+		}
+		lower := strings.ToLower(message)
+		for _, marker := range laughterMarkers {
+			if strings.Contains(lower, marker) {
+				laughter[marker]++
+			}
+		}
+	}
+
+	count := float64(len(messages))
+	profile.AvgLength = float64(length) / count
+	profile.EmojiPerMsg = float64(emoji) / count
+	profile.Lowercase = float64(lowercase) / count
+	for _, marker := range laughterMarkers {
+		if laughter[marker] > laughter[profile.Laughter] {
+			profile.Laughter = marker
+		}
+	}
+	profile.LaughterRate = float64(laughter[profile.Laughter]) / count
+	return profile
+}
+
+// Hint returns a compact system prompt hint describing the style, or an empty string when there
+// are too few messages to tell.
+func (profile StyleProfile) Hint() string {
+	if profile.Messages < styleMinSamples {
+		return ""
+	}
+
+	var traits []string
+	switch {
+	case profile.AvgLength < 40:
+		traits = append(traits, "short messages")
+	case profile.AvgLength > 150:
+		traits = append(traits, "long, detailed messages")
+	}
+	switch {
+	case profile.EmojiPerMsg >= 0.5:
+		traits = append(traits, "frequent emoji")
+	case profile.EmojiPerMsg < 0.05:
+		traits = append(traits, "almost no emoji")
+	}
+	if profile.Lowercase >= 0.6 {
+		traits = append(traits, "casual, mostly lowercase writing")
+	}
+	if profile.LaughterRate >= 0.1 {
+		traits = append(traits, fmt.Sprintf("laughter written as %q", profile.Laughter))
+	}
+	if len(traits) == 0 {
+		return ""
+	}
+	return "The users of this chat write with " + strings.Join(traits, ", ") + ". Match their style naturally without overdoing it."
+}
+
+// refreshStyleHints recomputes the style hints of the chats active in the last refresh interval.
+func (tg *Telegram) refreshStyleHints() error {
+	since := time.Now().Add(-styleRefreshInterval)
+	chatIDs, err := tg.db.GetActiveChats(since)
+	if err != nil {
+		return WrapError("failed to get active chats", err)
+	}
+
+	for _, chatID := range chatIDs {
+		messages, err := tg.db.GetUserMessages(chatID, since, styleSampleSize)
+		if err != nil {
+			return WrapError("failed to get user messages", err)
+		}
+		hint := analyzeStyle(messages).Hint()
+		if hint == "" {
+			err = tg.db.DeleteChatSetting(chatID, chatStyleHintSetting)
+		} else {
+			err = tg.db.SetChatSetting(chatID, chatStyleHintSetting, hint)
+		}
+		if err != nil {
+			return WrapError("failed to store style hint", err)
+		}
+	}
+	log.Info().Int("chats", len(chatIDs)).Msg("Refreshed chat style hints")
+	tg.analytics.Record(AnalyticsJobRun, 0, 0, map[string]interface{}{"job": "style_refresh", "chats": len(chatIDs)})
+	return nil
+}
+
+// runStyleRefresh refreshes the style hints at startup and then once per refresh interval, until
+// the jobs are stopped.
+func (tg *Telegram) runStyleRefresh() {
+	ticker := time.NewTicker(styleRefreshInterval)
+	defer ticker.Stop()
+	for {
+		err := tg.refreshStyleHints()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to refresh chat style hints")
+		}
+		select {
+		case <-tg.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// suggestedCommandPrefix prefixes the commands whose typos are answered with suggestions, so
+// commands meant for other bots in a group are left alone.
+const suggestedCommandPrefix = "mrl_"
+
+// maxCommandSuggestions is the most commands suggested for an unknown command.
+const maxCommandSuggestions = 3
+
+// maxSuggestionDistance is the largest edit distance between an unknown command and a
+// suggested one.
+const maxSuggestionDistance = 3
+
+// levenshtein returns the number of single character insertions, deletions, and substitutions
+// turning one string into another.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// commandName returns the command a message starts with, without the slash and the mention of
+// the bot. Commands mentioning another bot are not returned.
+func commandName(text, botUsername string) (string, bool) {
+	if !strings.HasPrefix(text, "/") {
+		return "", false
+	}
+	fields := strings.Fields(text[1:])
+	if len(fields) == 0 {
+		return "", false
+	}
+	name, mention, mentioned := strings.Cut(fields[0], "@")
+	if mentioned && !strings.EqualFold(mention, botUsername) {
+		return "", false
+	}
+	return strings.ToLower(name), name != ""
+}
+
+// Suggest returns the registered commands closest to an unknown name, at most limit of them
+// within maxDistance edits, that the filter accepts.
+func (registry *CommandRegistry) Suggest(name string, limit, maxDistance int, filter func(Command) bool) []Command {
+	type candidate struct {
+		cmd      Command
+		distance int
+	}
+	var candidates []candidate
+	for _, cmd := range registry.commands {
+		distance := levenshtein(name, cmd.Name())
+		if distance <= maxDistance && filter(cmd) {
+			candidates = append(candidates, candidate{cmd, distance})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	var suggestions []Command
+	for i := 0; i < len(candidates) && i < limit; i++ {
+		suggestions = append(suggestions, candidates[i].cmd)
+	}
+	return suggestions
+}
+
+// isUnknownCommand reports whether a message starts with a command of the bot that is not
+// registered.
+func (tg *Telegram) isUnknownCommand(msg *gotgbot.Message) bool {
+	name, ok := commandName(msg.Text, tg.bot.User.Username)
+	if !ok || msg.From == nil || !strings.HasPrefix(name, suggestedCommandPrefix) {
+		return false
+	}
+	_, known := tg.commands.Get(name)
+	return !known
+}
+
+// handleUnknownCommand answers an unknown command with the closest commands the user may run,
+// at most once per cooldown in each chat.
+func (tg *Telegram) handleUnknownCommand(b *gotgbot.Bot, ctx *ext.Context) error {
+	msg := ctx.EffectiveMessage
+	chatID := ChatID(msg.Chat.Id)
+	tg.clearChatBlocked(chatID)
+	tg.recordActivity(ctx)
+
+	name, _ := commandName(msg.Text, tg.bot.User.Username)
+	log.Info().Int64("user_id", msg.From.Id).Str("username", msg.From.Username).Str("command", name).Msg("Received unknown command")
+	if !tg.suggestions.allow(chatID, time.Duration(tg.config.TelegramSuggestCooldown*float64(time.Second))) {
+		log.Info().Int64("chat_id", int64(chatID)).Msg("Ignoring unknown command during cooldown")
+		return nil
+	}
+
+	suggestions := tg.commands.Suggest(name, maxCommandSuggestions, maxSuggestionDistance, func(cmd Command) bool {
+		return cmd.Authorize(tg, ctx)
+	})
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Comando /%s desconhecido.", name)
+	if len(suggestions) > 0 {
+		sb.WriteString(" Você quis dizer:\n")
+		for _, cmd := range suggestions {
+			fmt.Fprintf(&sb, "/%s - %s\n", cmd.Name(), commandDescription(cmd, msg.From.LanguageCode))
+		}
+	} else {
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\nUse /help para ver todos os comandos.")
+	return tg.sendTelegramMessage(ctx, sb.String())
+}
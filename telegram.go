@@ -1,29 +1,50 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/PaulSonOfLars/gotgbot/v2"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers/filters/callbackquery"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers/filters/message"
 	"github.com/rs/zerolog/log"
 )
 
 // Telegram encapsulates the bot's logic and dependencies.
 type Telegram struct {
-	bot     *gotgbot.Bot
-	updater *ext.Updater
-	db      *DB
-	oai     *OpenAI
-	config  *Config
+	bot           *gotgbot.Bot
+	updater       *ext.Updater
+	db            *DB
+	oai           *OpenAI
+	webhooks      *Webhooks
+	analytics     *Analytics
+	metrics       *Metrics
+	flags         Flags
+	config        *Config
+	commands      *CommandRegistry
+	slo           *SLOTracker
+	flood         *FloodGuard
+	chatRate      *RateLimiter
+	triggers      *ChatCooldown
+	critique      *TokenBudget
+	approvals     *ApprovalQueue
+	inFlight      *InFlightTracker
+	regenerations *ChatCooldown
+	regenerating  *RegenerationClaims
+	suggestions   *ChatCooldown
+	apiErrors     *APIErrorCounter
+	stop          chan struct{}  // Closed to stop the background jobs
+	jobs          sync.WaitGroup // Running background jobs
+	adminLink     *AdminLink
 }
 
 // NewTelegram creates a new Telegram bot instance.
-func NewTelegram(config *Config, db *DB, oai *OpenAI) (*Telegram, error) {
+func NewTelegram(config *Config, db *DB, oai *OpenAI, webhooks *Webhooks, analytics *Analytics, metrics *Metrics, flags Flags) (*Telegram, error) {
 	if config.TelegramToken == "" || config.TelegramAdminUID == 0 {
 		return nil, WrapError("invalid Telegram configuration")
 	}
@@ -33,22 +54,38 @@ func NewTelegram(config *Config, db *DB, oai *OpenAI) (*Telegram, error) {
 		return nil, WrapError("failed to create new bot", err)
 	}
 
+	commands, err := NewCommandRegistry(append(builtinCommands(), pluginCommands...)...)
+	if err != nil {
+		return nil, WrapError("failed to build command registry", err)
+	}
+
 	tg := &Telegram{
-		bot:    bot,
-		db:     db,
-		oai:    oai,
-		config: config,
+		bot:           bot,
+		db:            db,
+		oai:           oai,
+		webhooks:      webhooks,
+		analytics:     analytics,
+		metrics:       metrics,
+		flags:         flags,
+		config:        config,
+		commands:      commands,
+		slo:           NewSLOTracker(),
+		flood:         NewFloodGuard(),
+		chatRate:      NewRateLimiter(),
+		triggers:      NewChatCooldown(),
+		critique:      NewTokenBudget(config.OpenAICritiqueDailyTokens),
+		approvals:     NewApprovalQueue(),
+		inFlight:      NewInFlightTracker(),
+		regenerations: NewChatCooldown(),
+		regenerating:  NewRegenerationClaims(),
+		suggestions:   NewChatCooldown(),
+		apiErrors:     NewAPIErrorCounter(),
+		stop:          make(chan struct{}),
+		adminLink:     &AdminLink{},
 	}
 	tg.updater = ext.NewUpdater(tg.setupDispatcher(), nil)
 
-	// Set the bot commands
-	commands := []gotgbot.BotCommand{
-		{Command: "start", Description: "Iniciar conversa o bot"},
-		{Command: "piu", Description: "Enviar forward de uma mensagem antiga"},
-		{Command: "mrl", Description: "Gerar uma resposta usando OpenAI"},
-		{Command: "mrl_reset", Description: "Limpar histórico de mensagens (apenas admin)"},
-	}
-	_, err = bot.SetMyCommands(commands, nil)
+	err = tg.setupCommands()
 	if err != nil {
 		return nil, WrapError("failed to set bot commands", err)
 	}
@@ -56,12 +93,46 @@ func NewTelegram(config *Config, db *DB, oai *OpenAI) (*Telegram, error) {
 	return tg, nil
 }
 
-// Start starts the Telegram bot.
+// setupCommands registers the command menus of private chats, group chats, and the admin's
+// private chat, for the default language and every language a description is translated to.
+func (tg *Telegram) setupCommands() error {
+	menus := []struct {
+		scope    CommandScope
+		botScope gotgbot.BotCommandScope
+	}{
+		{ScopePrivate, gotgbot.BotCommandScopeAllPrivateChats{}},
+		{ScopeGroup, gotgbot.BotCommandScopeAllGroupChats{}},
+		{ScopeAdmin, gotgbot.BotCommandScopeChat{ChatId: int64(tg.config.TelegramAdminUID)}},
+	}
+	languages := append([]string{""}, tg.commands.Languages()...)
+
+	for _, menu := range menus {
+		for _, languageCode := range languages {
+			_, err := tg.bot.SetMyCommands(tg.commands.BotCommands(menu.scope, languageCode), &gotgbot.SetMyCommandsOpts{
+				Scope:        menu.botScope,
+				LanguageCode: languageCode,
+			})
+			if err != nil && menu.scope == ScopeAdmin {
+				// The admin chat is unknown until the admin starts a conversation with the bot
+				log.Warn().Err(err).Str("language_code", languageCode).Msg("Failed to set admin commands")
+				break
+			}
+			if err != nil {
+				return WrapError(fmt.Sprintf("failed to set commands for scope %d and language %q", menu.scope, languageCode), err)
+			}
+		}
+	}
+	return nil
+}
+
+// Start starts polling for updates and the background jobs of the Telegram bot.
 func (tg *Telegram) Start() error {
 	err := tg.updater.StartPolling(tg.bot, &ext.PollingOpts{
 		DropPendingUpdates: false,
 		GetUpdatesOpts: &gotgbot.GetUpdatesOpts{
 			Timeout: 9,
+			// Reactions are only delivered when requested explicitly
+			AllowedUpdates: []string{"message", "edited_message", "callback_query", "message_reaction"},
 			RequestOpts: &gotgbot.RequestOpts{
 				Timeout: time.Second * 10,
 			},
@@ -72,43 +143,162 @@ func (tg *Telegram) Start() error {
 	}
 
 	log.Info().Str("username", tg.bot.User.Username).Msg("Started Telegram Bot")
-	tg.updater.Idle()
+	tg.logAdminLink()
+	if tg.config.TelegramStyleLearning {
+		tg.startJob(tg.runStyleRefresh)
+	}
+	if tg.config.StorageCheckInterval > 0 {
+		tg.startJob(tg.runStorageMonitor)
+	}
+	tg.startJob(tg.runFocusExpiry)
+	tg.startJob(tg.runAPIErrorFlush)
+	if tg.config.MembershipSyncInterval > 0 {
+		tg.startJob(tg.runMembershipSync)
+	}
+	if tg.config.FollowUps {
+		tg.startJob(tg.runFollowUps)
+	}
+	if tg.config.AdminDigest {
+		tg.startJob(tg.runAdminDigest)
+	}
+	if tg.config.DriftPromptsFile != "" && tg.config.DriftCheckInterval > 0 && tg.oai != nil {
+		tg.startJob(tg.runDriftCheck)
+	}
+	if tg.config.SemanticRecall > 0 && tg.config.SemanticIndexInterval > 0 && !tg.config.Stateless && tg.oai != nil {
+		tg.startJob(tg.runSemanticIndex)
+	}
+	return nil
+}
+
+// startJob runs a background job, tracking it so StopJobs can wait for it.
+func (tg *Telegram) startJob(job func()) {
+	tg.jobs.Add(1)
+	go func() {
+		defer tg.jobs.Done()
+		job()
+	}()
+}
+
+// StopIntake stops polling for new updates.
+func (tg *Telegram) StopIntake() error {
+	if !tg.updater.StopBot(tg.bot.Token) {
+		return WrapError("bot was not polling")
+	}
+	return nil
+}
+
+// Drain waits for the updates being handled to finish and cancels the replies waiting for
+// approval, which can no longer be posted.
+func (tg *Telegram) Drain() error {
+	err := tg.updater.Stop()
+	if err != nil {
+		return WrapError("failed to stop dispatcher", err)
+	}
+	for _, approval := range tg.approvals.takeAll() {
+		tg.closeApproval(approval, "Cancelled by shutdown.")
+	}
+	return nil
+}
+
+// StopJobs stops the background jobs and waits for the running ones to finish.
+func (tg *Telegram) StopJobs() error {
+	close(tg.stop)
+	tg.jobs.Wait()
 	return nil
 }
 
 // setupDispatcher sets up the dispatcher with command and message handlers.
 func (tg *Telegram) setupDispatcher() *ext.Dispatcher {
 	dispatcher := ext.NewDispatcher(&ext.DispatcherOpts{
-		Error: func(bot *gotgbot.Bot, ctx *ext.Context, err error) ext.DispatcherAction {
-			log.Error().Err(err).Msg("Error occurred while handling update")
-			return ext.DispatcherActionNoop
-		},
+		Error:       tg.handleDispatchError,
 		MaxRoutines: ext.DefaultMaxRoutines,
 	})
-	dispatcher.AddHandler(handlers.NewCommand("start", tg.handleStartRequest))
-	dispatcher.AddHandler(handlers.NewCommand("piu", tg.handlePiuRequest))
-	dispatcher.AddHandler(handlers.NewCommand("mrl", tg.handleMrlRequest))
-	dispatcher.AddHandler(handlers.NewCommand("mrl_reset", tg.handleMrlResetRequest))
+	for _, cmd := range tg.commands.Commands() {
+		dispatcher.AddHandler(handlers.NewCommand(cmd.Name(), tg.commandHandler(cmd)))
+	}
+	dispatcher.AddHandler(handlers.NewCallback(callbackquery.Prefix(approvalCallbackPrefix), tg.handleApprovalCallback))
+	dispatcher.AddHandler(handlers.NewMessage(tg.isApprovalEdit, tg.handleApprovalEdit))
+	dispatcher.AddHandler(handlers.NewMessage(tg.isUnknownCommand, tg.handleUnknownCommand))
 	dispatcher.AddHandler(handlers.NewMessage(message.Text, tg.handleIncomingMessage))
+	dispatcher.AddHandler(handlers.NewMessage(message.Migrate, tg.handleMigrateMessage))
+	dispatcher.AddHandler(handlers.NewMessage(isMembershipMessage, tg.handleMembershipMessage))
+	dispatcher.AddHandler(handlers.NewMessage(isVoiceMessage, tg.handleVoiceMessage))
+	dispatcher.AddHandler(handlers.NewMessage(isEditedMessage, tg.handleEditedMessage).SetAllowEdited(true))
+	dispatcher.AddHandler(handlers.NewMessage(isStructuredMessage, tg.handleStructuredMessage))
+	dispatcher.AddHandler(handlers.NewReaction(isRegenerateReaction, tg.handleRegenerateReaction))
 	return dispatcher
 }
 
+// commandHandler adapts a Command to a dispatcher handler, enforcing its authorization.
+func (tg *Telegram) commandHandler(cmd Command) handlers.Response {
+	return func(b *gotgbot.Bot, ctx *ext.Context) error {
+		if ctx.EffectiveMessage == nil {
+			return WrapError("effective message is nil")
+		}
+		tg.clearChatBlocked(ChatID(ctx.EffectiveMessage.Chat.Id))
+		tg.recordActivity(ctx)
+		if !cmd.Authorize(tg, ctx) {
+			log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Str("command", cmd.Name()).Msg("Unauthorized command request")
+			if isAdminCommand(cmd) {
+				tg.auditCommand(ctx, cmd, AuditResultDenied)
+			}
+			err := tg.sendTelegramMessage(ctx, "You are not authorized to use this command.")
+			if err != nil {
+				return WrapError("failed to send unauthorized message", err)
+			}
+			return nil
+		}
+		err := cmd.Handle(tg, ctx)
+		if isAdminCommand(cmd) {
+			result := AuditResultOK
+			if err != nil {
+				result = err.Error()
+			}
+			tg.auditCommand(ctx, cmd, result)
+		}
+		if err != nil {
+			return WrapError(fmt.Sprintf("failed to handle /%s command", cmd.Name()), err)
+		}
+		return nil
+	}
+}
+
 // handleIncomingMessage processes incoming messages.
 func (tg *Telegram) handleIncomingMessage(b *gotgbot.Bot, ctx *ext.Context) error {
 	if ctx.EffectiveMessage == nil {
 		return WrapError("effective message is nil")
 	}
+	tg.clearChatBlocked(ChatID(ctx.EffectiveMessage.Chat.Id))
+	tg.recordActivity(ctx)
 	if ctx.EffectiveMessage.ForwardOrigin == nil {
-		log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received non-forward message, ignoring")
+		if tg.matchesTrigger(ctx) {
+			log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received message with trigger word")
+			return tg.answerMessage(ctx, strings.TrimSpace(ctx.EffectiveMessage.Text))
+		}
+		if tg.inFocus(ctx) {
+			log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received message in focus mode")
+			return tg.answerMessage(ctx, strings.TrimSpace(ctx.EffectiveMessage.Text))
+		}
+		componentLog(LogIncoming).Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received non-forward message, ignoring")
 		return nil
 	}
 	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received forward message")
 
-	msgRef := MessageRef{MessageID: ctx.EffectiveMessage.MessageId, ChatID: ctx.EffectiveMessage.Chat.Id, LastUsed: time.Now()}
+	if ctx.EffectiveMessage.HasProtectedContent {
+		log.Info().Int64("chat_id", ctx.EffectiveMessage.Chat.Id).Int64("message_id", ctx.EffectiveMessage.MessageId).Msg("Refusing to store protected message")
+		err := tg.sendTelegramMessage(ctx, "Esta mensagem é protegida e não pode ser guardada.")
+		if err != nil {
+			return WrapError("failed to send telegram message", err)
+		}
+		return nil
+	}
+
+	msgRef := MessageRef{MessageID: MessageID(ctx.EffectiveMessage.MessageId), ChatID: ChatID(ctx.EffectiveMessage.Chat.Id), LastUsed: time.Now()}
 	err := tg.db.AddMessageRef(&msgRef)
 	if err != nil {
 		return WrapError("failed to add message reference to database", err)
 	}
+	tg.analytics.Record(AnalyticsMessageSaved, msgRef.ChatID, UserID(ctx.EffectiveMessage.From.Id), map[string]interface{}{"message_id": msgRef.MessageID})
 
 	err = tg.sendTelegramMessage(ctx, "Mensagem adicionada ao banco de dados!")
 	if err != nil {
@@ -118,13 +308,41 @@ func (tg *Telegram) handleIncomingMessage(b *gotgbot.Bot, ctx *ext.Context) erro
 	return nil
 }
 
+// handleMigrateMessage processes the service messages sent when a group is migrated to a
+// supergroup, which carry the new chat ID in the old chat and the old chat ID in the new one.
+func (tg *Telegram) handleMigrateMessage(b *gotgbot.Bot, ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+
+	oldChatID, newChatID := ChatID(ctx.EffectiveMessage.Chat.Id), ChatID(ctx.EffectiveMessage.MigrateToChatId)
+	if ctx.EffectiveMessage.MigrateFromChatId != 0 {
+		oldChatID, newChatID = ChatID(ctx.EffectiveMessage.MigrateFromChatId), ChatID(ctx.EffectiveMessage.Chat.Id)
+	}
+	err := tg.migrateChat(oldChatID, newChatID)
+	if err != nil {
+		return WrapError("failed to handle chat migration", err)
+	}
+	return nil
+}
+
 // handleStartRequest processes the /start command.
-func (tg *Telegram) handleStartRequest(b *gotgbot.Bot, ctx *ext.Context) error {
+func (tg *Telegram) handleStartRequest(ctx *ext.Context) error {
 	if ctx.EffectiveMessage == nil {
 		return WrapError("effective message is nil")
 	}
 	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received START request")
-	err := tg.sendTelegramMessage(ctx, "Olá! Me encaminhe uma mensagem para guardar.")
+
+	payload := commandText(ctx.EffectiveMessage.Text)
+	if strings.HasPrefix(payload, adminLinkPrefix) {
+		err := tg.bindAdminChat(ctx, payload)
+		if err != nil {
+			return WrapError("failed to bind admin chat", err)
+		}
+		return nil
+	}
+
+	err := tg.sendTelegramMessage(ctx, "Olá! Me encaminhe uma mensagem para guardar.\n\n"+tg.commands.HelpText(ScopePrivate, ""))
 	if err != nil {
 		return WrapError("failed to send start message", err)
 	}
@@ -132,19 +350,19 @@ func (tg *Telegram) handleStartRequest(b *gotgbot.Bot, ctx *ext.Context) error {
 }
 
 // handlePiuRequest processes the /piu command.
-func (tg *Telegram) handlePiuRequest(b *gotgbot.Bot, ctx *ext.Context) error {
+func (tg *Telegram) handlePiuRequest(ctx *ext.Context) error {
 	if ctx.EffectiveMessage == nil {
 		return WrapError("effective message is nil")
 	}
 	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received PIU request")
 
-	user, err := tg.db.GetOrCreateUser(ctx.EffectiveMessage.From.Id, tg.config.TelegramUserTimeout)
+	user, err := tg.db.GetOrCreateUser(UserID(ctx.EffectiveMessage.From.Id), tg.config.TelegramUserTimeout)
 	if err != nil {
 		return WrapError("failed to get or create user", err)
 	}
 
 	if time.Since(user.LastUsed).Minutes() <= tg.config.TelegramUserTimeout {
-		log.Info().Int64("user_id", user.UserID).Str("username", ctx.EffectiveMessage.From.Username).Time("last_used", user.LastUsed).Msg("User on timeout")
+		log.Info().Int64("user_id", int64(user.UserID)).Str("username", ctx.EffectiveMessage.From.Username).Time("last_used", user.LastUsed).Msg("User on timeout")
 		return nil
 	}
 
@@ -153,7 +371,7 @@ func (tg *Telegram) handlePiuRequest(b *gotgbot.Bot, ctx *ext.Context) error {
 		return WrapError("failed to update user's last used time", err)
 	}
 
-	msgRef, err := tg.db.GetRandomMessageRef()
+	msgRef, err := tg.db.GetRandomMessageRef(ChatID(ctx.EffectiveMessage.Chat.Id))
 	if err != nil {
 		return WrapError("failed to get random message reference", err)
 	}
@@ -163,121 +381,329 @@ func (tg *Telegram) handlePiuRequest(b *gotgbot.Bot, ctx *ext.Context) error {
 		return WrapError("failed to forward telegram message", err)
 	}
 
+	audit := ExportAudit{
+		SourceChatID: msgRef.ChatID,
+		MessageID:    msgRef.MessageID,
+		DestChatID:   ChatID(ctx.EffectiveMessage.Chat.Id),
+		UserID:       UserID(ctx.EffectiveMessage.From.Id),
+		ExportedAt:   time.Now(),
+	}
+	err = tg.db.AddExportAudit(&audit)
+	if err != nil {
+		return WrapError("failed to record export audit", err)
+	}
+
 	return nil
 }
 
 // handleMrlRequest processes the /mrl command.
-func (tg *Telegram) handleMrlRequest(b *gotgbot.Bot, ctx *ext.Context) error {
+func (tg *Telegram) handleMrlRequest(ctx *ext.Context) error {
 	if ctx.EffectiveMessage == nil {
 		return WrapError("effective message is nil")
 	}
 	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL request")
+	return tg.answerMessage(ctx, commandText(ctx.EffectiveMessage.Text))
+}
+
+// admitMessage reports whether a message of the effective user may be answered: blocked users
+// are ignored, and floods and chats over their rate are throttled. The text is what flood checks
+// compare to tell repeated messages apart.
+func (tg *Telegram) admitMessage(ctx *ext.Context, text string) bool {
+	return !tg.ignoreBlockedUser(ctx) && !tg.checkFlood(ctx, text) && !tg.checkChatRate(ctx)
+}
 
-	_, err := tg.bot.SendChatAction(ctx.EffectiveChat.Id, "typing", nil)
+// answerMessage generates and sends a reply to a message of the effective user, given without
+// the command that triggered it.
+func (tg *Telegram) answerMessage(ctx *ext.Context, message string) error {
+	receivedAt := time.Now()
+	// Repeats go through the flood and rate checks first, so the short-circuit does not answer
+	// the spam the checks would absorb
+	if !tg.admitMessage(ctx, message) {
+		return nil
+	}
+	return tg.answerAdmittedMessage(ctx, message, receivedAt)
+}
+
+// answerAdmittedMessage generates and sends a reply to a message received at the given time that
+// admitMessage already let through.
+func (tg *Telegram) answerAdmittedMessage(ctx *ext.Context, message string, receivedAt time.Time) error {
+	key := inFlightKey{chatID: ChatID(ctx.EffectiveMessage.Chat.Id), userID: UserID(ctx.EffectiveMessage.From.Id)}
+	request, duplicate := tg.inFlight.begin(key, message, time.Duration(tg.config.TelegramDuplicateWindow*float64(time.Second)))
+	if duplicate != nil {
+		return tg.answerDuplicate(ctx, duplicate)
+	}
+	var reply string
+	defer func() {
+		tg.inFlight.finish(key, request, reply)
+	}()
+	handled, err := tg.applyRules(ctx, message)
+	if handled || err != nil {
+		return err
+	}
+
+	_, err = tg.bot.SendChatAction(ctx.EffectiveChat.Id, "typing", nil)
 	if err != nil {
 		return WrapError("failed to send chat action", err)
 	}
 
-	message := strings.TrimSpace(strings.TrimPrefix(ctx.EffectiveMessage.Text, "/mrl"))
+	boundary := tg.contextBoundary(ChatID(ctx.EffectiveMessage.Chat.Id))
+	var gptHistory []ChatHistory
+	if !tg.config.Stateless {
+		gptHistory, err = tg.db.GetRecentChatHistory(ChatID(ctx.EffectiveMessage.Chat.Id), boundary, tg.historyLimit(ChatID(ctx.EffectiveMessage.Chat.Id)))
+		if err != nil {
+			return WrapError("failed to get recent chat history", err)
+		}
+	}
 
-	gptHistory, err := tg.db.GetRecentChatHistory(30)
+	instruction, err := tg.systemInstruction(ChatID(ctx.EffectiveMessage.Chat.Id), ctx.EffectiveMessage.From.LanguageCode)
+	if err != nil {
+		return WrapError("failed to build system instruction", err)
+	}
+	instruction += tg.recalledContext(ChatID(ctx.EffectiveMessage.Chat.Id), message, gptHistory, boundary, receivedAt)
+	current := map[string]string{
+		"role": "user", "content": formatUserMessage(UserID(ctx.EffectiveMessage.From.Id), ctx.EffectiveMessage.From.Username, time.Now(), tg.replyContext(ctx.EffectiveMessage)+tg.promptInput(message)),
+	}
+	messages := tg.buildPrompt(instruction, gptHistory, func(seen map[uint]bool) []map[string]string {
+		return tg.replyChainMessages(ctx.EffectiveMessage, seen)
+	}, current)
+
+	opts := CallOptions{
+		Temperature: tg.responseTemperature(ChatID(ctx.EffectiveMessage.Chat.Id), message),
+		Model:       tg.chatModel(ChatID(ctx.EffectiveMessage.Chat.Id)),
+		MaxTokens:   tg.verbosityMaxTokens(ChatID(ctx.EffectiveMessage.Chat.Id)),
+	}
+	opts.Tools, opts.HandleTool = tg.chatTools(ctx.EffectiveMessage)
+	var content, responseID string
+	var stream *StreamingReply
+	if tg.canStream(ChatID(ctx.EffectiveMessage.Chat.Id), opts) {
+		stream = tg.newStreamingReply(ctx.EffectiveMessage)
+		content, err = tg.oai.CallStream(messages, opts, stream.update)
+		if err != nil {
+			stream.discard()
+		}
+	} else {
+		content, responseID, err = tg.generateResponse(ChatID(ctx.EffectiveMessage.Chat.Id), messages, opts, boundary)
+	}
+	var refusal *RefusalError
+	if errors.As(err, &refusal) {
+		tg.recordRefusal(ctx, messages, refusal)
+		err = tg.sendTelegramMessage(ctx, "Não consegui gerar uma resposta para isso.")
+		if err != nil {
+			return WrapError("failed to send refusal message", err)
+		}
+		return nil
+	}
 	if err != nil {
-		return WrapError("failed to get recent chat history", err)
+		return WrapError("failed to call OpenAI", err)
 	}
+	content = tg.critiqueReply(ChatID(ctx.EffectiveMessage.Chat.Id), messages[0]["content"], message, content)
 
-	messages := []map[string]string{{"role": "system", "content": tg.config.OpenAIInstruction}}
+	generatedAt := time.Now()
+	deliver := func(content, responseID string) error {
+		// Time spent waiting for approval does not count as reply latency
+		return tg.deliverAnswer(ctx, message, messages, opts.Model, content, responseID, receivedAt.Add(time.Since(generatedAt)), stream)
+	}
+	if tg.requiresApproval(ChatID(ctx.EffectiveMessage.Chat.Id)) {
+		return tg.requestApproval(ctx, content, responseID, deliver)
+	}
+	err = deliver(content, responseID)
+	if err != nil {
+		return err
+	}
+	reply = content
+	return nil
+}
 
-	sort.Slice(gptHistory, func(i, j int) bool {
-		return gptHistory[i].LastUsed.Before(gptHistory[j].LastUsed)
+// deliverAnswer sends a generated reply to the effective message, or completes it when it was
+// streamed, and records it in the analytics and the chat history.
+func (tg *Telegram) deliverAnswer(ctx *ext.Context, message string, messages []map[string]string, model, content, responseID string, receivedAt time.Time, stream *StreamingReply) error {
+	var sent *gotgbot.Message
+	var err error
+	if stream != nil {
+		sent, err = stream.finish(content)
+	} else {
+		sent, err = tg.replyTelegramMessage(ctx, content)
+	}
+	if err != nil {
+		return WrapError("failed to send OpenAI response", err)
+	}
+	chatID, userID := ChatID(ctx.EffectiveMessage.Chat.Id), UserID(ctx.EffectiveMessage.From.Id)
+	tg.analytics.Record(AnalyticsMentionAnswered, chatID, userID, map[string]interface{}{
+		"latency_ms": time.Since(receivedAt).Milliseconds(),
+		"threaded":   responseID != "",
 	})
+	tg.analytics.Record(AnalyticsTokensUsed, chatID, userID, map[string]interface{}{
+		"model":                 model,
+		"prompt_tokens_est":     messagesTokens(messages),
+		"completion_tokens_est": estimateTokens(content),
+	})
+	tg.recordServedModel(chatID, model)
+
+	if tg.config.Stateless || !tg.learnsFrom(ChatID(ctx.EffectiveMessage.Chat.Id)) {
+		return nil
+	}
 
-	for _, history := range gptHistory {
-		userName := history.UserName
-		if userName == "" {
-			userName = "Unknown User"
+	historyRecord := ChatHistory{
+		ChatID:       ChatID(ctx.EffectiveMessage.Chat.Id),
+		UserID:       UserID(ctx.EffectiveMessage.From.Id),
+		UserName:     ctx.EffectiveMessage.From.Username,
+		UserMsg:      message,
+		BotMsg:       content,
+		LastUsed:     time.Now(),
+		ResponseID:   responseID,
+		LanguageCode: ctx.EffectiveMessage.From.LanguageCode,
+		Latency:      time.Since(receivedAt),
+		MessageID:    MessageID(ctx.EffectiveMessage.MessageId),
+		ReplyContext: tg.replyContext(ctx.EffectiveMessage),
+	}
+	if ctx.EffectiveMessage.ReplyToMessage != nil {
+		historyRecord.ReplyToMessageID = MessageID(ctx.EffectiveMessage.ReplyToMessage.MessageId)
+	}
+	if sent != nil {
+		historyRecord.BotMessageID = MessageID(sent.MessageId)
+	}
+	err = tg.db.AddChatHistory(&historyRecord)
+	if err != nil {
+		return WrapError("failed to add chat history to database", err)
+	}
+	tg.checkReplySLO(historyRecord.ChatID)
+
+	return nil
+}
+
+// generateResponse gets a completion for the given messages of a chat, whose first entry is the
+// system instruction. With threading enabled the conversation continues on the provider side from
+// the last response of the chat since the given boundary and only the newest message is sent,
+// falling back to resending the full history when that fails. Requests offering tools always
+// resend the full history, since the Responses API call does not carry them.
+func (tg *Telegram) generateResponse(chatID ChatID, messages []map[string]string, opts CallOptions, boundary time.Time) (string, string, error) {
+	if tg.config.OpenAIThreading && !tg.config.Stateless && len(opts.Tools) == 0 {
+		previousResponseID, err := tg.db.GetLastResponseID(chatID, boundary)
+		if err != nil {
+			log.Warn().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to get last response ID, resending full history")
+		} else {
+			input := messages[1:]
+			if previousResponseID != "" {
+				input = messages[len(messages)-1:]
+			}
+			content, responseID, err := tg.oai.CallThreaded(messages[0]["content"], input, previousResponseID, opts)
+			var refusal *RefusalError
+			if err == nil || errors.As(err, &refusal) {
+				return content, responseID, err
+			}
+			log.Warn().Err(err).Str("previous_response_id", previousResponseID).Msg("Threaded call failed, resending full history")
 		}
-		messages = append(messages, map[string]string{
-			"role": "user", "content": fmt.Sprintf("[UID: %d] %s [%s]: %s", history.UserID, userName, history.LastUsed.Format(time.RFC3339), history.UserMsg),
-		})
-		messages = append(messages, map[string]string{
-			"role": "assistant", "content": history.BotMsg,
-		})
 	}
 
-	userName := ctx.EffectiveMessage.From.Username
-	if userName == "" {
-		userName = "Unknown User"
+	content, err := tg.oai.CallWithOptions(messages, opts)
+	if err != nil {
+		return "", "", WrapError("failed to call OpenAI", err)
 	}
-	messages = append(messages, map[string]string{
-		"role": "user", "content": fmt.Sprintf("[UID: %d] %s [%s]: %s", ctx.EffectiveMessage.From.Id, userName, time.Now().Format(time.RFC3339), message),
-	})
+	return content, "", nil
+}
+
+// handleMrlStatsRequest processes the /mrl_stats command.
+func (tg *Telegram) handleMrlStatsRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_STATS request")
 
-	content, err := tg.oai.Call(messages)
+	stats, err := tg.db.GetStats()
 	if err != nil {
-		return WrapError("failed to call OpenAI", err)
+		return WrapError("failed to get stats", err)
 	}
 
-	err = tg.sendTelegramMessage(ctx, content)
+	latency, err := tg.chatLatencySummary(ChatID(ctx.EffectiveMessage.Chat.Id))
 	if err != nil {
-		return WrapError("failed to send OpenAI response", err)
+		return WrapError("failed to get reply latency summary", err)
 	}
 
-	historyRecord := ChatHistory{UserID: ctx.EffectiveMessage.From.Id, UserName: ctx.EffectiveMessage.From.Username, UserMsg: message, BotMsg: content, LastUsed: time.Now()}
-	err = tg.db.AddChatHistory(&historyRecord)
+	apiErrors, err := tg.apiErrorSummary()
 	if err != nil {
-		return WrapError("failed to add chat history to database", err)
+		return WrapError("failed to get API error summary", err)
 	}
 
+	activeMembers, totalMembers, err := tg.membershipSummary(ChatID(ctx.EffectiveMessage.Chat.Id))
+	if err != nil {
+		return WrapError("failed to get membership summary", err)
+	}
+
+	text := fmt.Sprintf("Message references: %d\nChat history entries: %d\nBlocked chats: %d\nMigrated chats: %d\nModel refusals: %d\nForwarded messages: %d\nThrottled requests: %d\nReply latency in this chat (last %s, %d replies): p50 %s, p95 %s\nMembers in this chat: %d active in the last %d days, %s total\n%s",
+		stats.MessageRefs, stats.ChatHistory, stats.BlockedChats, stats.MigratedChats, stats.Refusals, stats.Exports, stats.Throttled,
+		tg.sloWindow(), latency.Count, latency.P50.Round(time.Millisecond), latency.P95.Round(time.Millisecond),
+		activeMembers, int(activeMemberWindow.Hours()/24), totalMembers, apiErrors)
+	err = tg.sendTelegramMessage(ctx, text)
+	if err != nil {
+		return WrapError("failed to send stats message", err)
+	}
 	return nil
 }
 
 // handleMrlResetRequest processes the /mrl_reset command.
-func (tg *Telegram) handleMrlResetRequest(b *gotgbot.Bot, ctx *ext.Context) error {
+func (tg *Telegram) handleMrlResetRequest(ctx *ext.Context) error {
 	if ctx.EffectiveMessage == nil {
 		return WrapError("effective message is nil")
 	}
 	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_RESET request")
 
-	if ctx.EffectiveMessage.From.Id != tg.config.TelegramAdminUID {
-		_, err := ctx.EffectiveMessage.Reply(b, "You are not authorized to use this command.", nil)
-		if err != nil {
-			return WrapError("failed to send unauthorized message", err)
-		}
-		return nil
-	}
-
 	err := tg.db.ClearChatHistory()
 	if err != nil {
 		return WrapError("failed to clear chat history", err)
 	}
+	tg.webhooks.Notify(EventHistoryReset, map[string]interface{}{"chat_id": ctx.EffectiveMessage.Chat.Id, "user_id": ctx.EffectiveMessage.From.Id})
 
-	_, err = ctx.EffectiveMessage.Reply(b, "History has been reset.", nil)
+	err = tg.sendTelegramMessage(ctx, "History has been reset.")
 	if err != nil {
 		return WrapError("failed to send reset confirmation message", err)
 	}
 	return nil
 }
 
+// notifyAdmin sends a message to the admin's bound chat, or their private chat when none is bound.
+func (tg *Telegram) notifyAdmin(text string) error {
+	_, err := tg.newMessage(tg.adminChatID(), text).Send()
+	if err != nil {
+		return WrapError("failed to send message to admin", err)
+	}
+	return nil
+}
+
 // sendTelegramMessage sends a message to a Telegram chat.
 func (tg *Telegram) sendTelegramMessage(ctx *ext.Context, text string) error {
+	_, err := tg.replyTelegramMessage(ctx, text)
+	return err
+}
+
+// listMessageLength is the maximum length of answers listing records, below Telegram's limit of
+// 4096 characters. Records past it are left out.
+const listMessageLength = 4000
+
+// replyTelegramMessage answers the effective message and returns the sent message, which is nil
+// when the chat blocked the bot.
+func (tg *Telegram) replyTelegramMessage(ctx *ext.Context, text string) (*gotgbot.Message, error) {
 	if ctx.EffectiveMessage == nil {
-		return WrapError("effective message is nil")
+		return nil, WrapError("effective message is nil")
 	}
-	_, err := ctx.EffectiveMessage.Reply(tg.bot, text, nil)
-	if err != nil {
-		return WrapError("failed to send telegram message", err)
-	}
-	return nil
+	return tg.newMessage(ChatID(ctx.EffectiveMessage.Chat.Id), text).ReplyTo(ctx.EffectiveMessage).Send()
 }
 
 // forwardTelegramMessage forwards a message to a Telegram chat.
-func (tg *Telegram) forwardTelegramMessage(ctx *ext.Context, forwardChatID int64, forwardMessageID int64) error {
+func (tg *Telegram) forwardTelegramMessage(ctx *ext.Context, forwardChatID ChatID, forwardMessageID MessageID) error {
 	if ctx.EffectiveMessage == nil {
 		return WrapError("effective message is nil")
 	}
-	_, err := tg.bot.ForwardMessage(ctx.EffectiveChat.Id, forwardChatID, forwardMessageID, nil)
+	_, err := tg.bot.ForwardMessage(ctx.EffectiveChat.Id, int64(forwardChatID), int64(forwardMessageID), nil)
 	if err != nil {
-		return WrapError("failed to forward telegram message", err)
+		// The destination chat just sent us an update, so a migration can only concern the source chat.
+		newChatID := tg.handleAPIError(forwardChatID, err)
+		if newChatID == 0 {
+			return WrapError("failed to forward telegram message", err)
+		}
+		_, err = tg.bot.ForwardMessage(ctx.EffectiveChat.Id, int64(newChatID), int64(forwardMessageID), nil)
+		if err != nil {
+			return WrapError("failed to forward telegram message from migrated chat", err)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// blockedDescriptions are the parts of the descriptions of forbidden errors meaning the bot can no
+// longer send to a chat at all. Other forbidden errors, such as missing rights to send media, only
+// fail the request.
+var blockedDescriptions = []string{
+	"bot was blocked",
+	"bot was kicked",
+	"not a member",
+	"user is deactivated",
+}
+
+// isBlockedError reports whether a Telegram error means the bot was blocked in or removed from
+// the chat.
+func isBlockedError(tgErr *gotgbot.TelegramError) bool {
+	if tgErr.Code != http.StatusForbidden {
+		return false
+	}
+	description := strings.ToLower(tgErr.Description)
+	for _, blocked := range blockedDescriptions {
+		if strings.Contains(description, blocked) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleAPIError inspects an error returned by the Telegram API and updates the stored state
+// of chats that blocked the bot or were migrated to a supergroup. It returns the chat ID a
+// failed request should be retried with, or zero when retrying makes no sense.
+func (tg *Telegram) handleAPIError(chatID ChatID, err error) ChatID {
+	var tgErr *gotgbot.TelegramError
+	if !errors.As(err, &tgErr) {
+		return 0
+	}
+	tg.metrics.recordError("telegram", apiErrorKind(tgErr.Code))
+	tg.apiErrors.record(APIErrorSample{
+		Kind:        apiErrorKind(tgErr.Code),
+		ChatID:      chatID,
+		Description: tgErr.Description,
+		CreatedAt:   time.Now(),
+	})
+
+	if tgErr.ResponseParams != nil && tgErr.ResponseParams.MigrateToChatId != 0 {
+		newChatID := ChatID(tgErr.ResponseParams.MigrateToChatId)
+		err := tg.migrateChat(chatID, newChatID)
+		if err != nil {
+			log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to migrate chat")
+			return 0
+		}
+		return newChatID
+	}
+
+	if isBlockedError(tgErr) {
+		log.Info().Int64("chat_id", int64(chatID)).Str("description", tgErr.Description).Msg("Bot blocked in chat, skipping further messages")
+		err := tg.db.MarkChatBlocked(chatID)
+		if err != nil {
+			log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to mark chat as blocked")
+		}
+		tg.webhooks.Notify(EventChatBlocked, map[string]interface{}{"chat_id": chatID, "description": tgErr.Description})
+	}
+	return 0
+}
+
+// migrateChat moves the stored state of a chat to the supergroup it was migrated to.
+func (tg *Telegram) migrateChat(oldChatID, newChatID ChatID) error {
+	migrated, err := tg.db.MigrateChat(oldChatID, newChatID)
+	if err != nil {
+		return WrapError("failed to migrate chat", err)
+	}
+	if !migrated {
+		return nil
+	}
+	log.Info().Int64("chat_id", int64(oldChatID)).Int64("new_chat_id", int64(newChatID)).Msg("Chat migrated to supergroup")
+	tg.webhooks.Notify(EventChatMigrated, map[string]interface{}{"chat_id": oldChatID, "new_chat_id": newChatID})
+	return nil
+}
+
+// isChatBlocked reports whether the bot was blocked in the given chat.
+func (tg *Telegram) isChatBlocked(chatID ChatID) bool {
+	blocked, err := tg.db.IsChatBlocked(chatID)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to check if chat is blocked")
+		return false
+	}
+	return blocked
+}
+
+// clearChatBlocked removes the blocked mark of a chat the bot has received an update from. Chats
+// that are not marked are left alone, so updates do not each write to the database.
+func (tg *Telegram) clearChatBlocked(chatID ChatID) {
+	if !tg.isChatBlocked(chatID) {
+		return
+	}
+	err := tg.db.UnmarkChatBlocked(chatID)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to clear blocked chat mark")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// chatTemperatureSetting is the chat settings key overriding the reply temperature.
+const chatTemperatureSetting = "temperature"
+
+// isFactualQuestion reports whether a message looks like a factual or technical question, based
+// on code markers and the configured keywords matched on word boundaries.
+func isFactualQuestion(text string, keywords []string) bool {
+	if strings.Contains(text, "```") {
+		return true
+	}
+	normalized := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, text)
+	normalized = " " + strings.Join(strings.Fields(normalized), " ") + " "
+	for _, keyword := range keywords {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword != "" && strings.Contains(normalized, " "+keyword+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// responseTemperature returns the temperature for a reply in a chat. A per-chat override wins;
+// otherwise, with adaptive temperature enabled, factual questions get the lower factual
+// temperature. It returns nil to use the configured default.
+func (tg *Telegram) responseTemperature(chatID ChatID, text string) *float32 {
+	value, ok, err := tg.db.GetChatSetting(chatID, chatTemperatureSetting)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to get chat temperature")
+	}
+	if ok {
+		temperature, err := strconv.ParseFloat(value, 32)
+		if err == nil {
+			t := float32(temperature)
+			return &t
+		}
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Str("value", value).Msg("Invalid chat temperature")
+	}
+
+	if tg.config.OpenAIAdaptiveTemperature && isFactualQuestion(text, tg.config.OpenAIFactualKeywords) {
+		t := tg.config.OpenAIFactualTemperature
+		return &t
+	}
+	return nil
+}
+
+// handleMrlTemperatureRequest processes the /mrl_temperature command, which sets the reply
+// temperature of the chat or, with "auto", restores the configured behavior.
+func (tg *Telegram) handleMrlTemperatureRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_TEMPERATURE request")
+
+	usage := "/mrl_temperature <0-2|auto>"
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 1, 1)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	arg := args.Arg(0)
+	if arg == "auto" {
+		err = tg.db.DeleteChatSetting(chatID, chatTemperatureSetting)
+		if err != nil {
+			return WrapError("failed to delete chat temperature", err)
+		}
+		return tg.sendTelegramMessage(ctx, "Temperature set to automatic.")
+	}
+
+	temperature, err := strconv.ParseFloat(arg, 32)
+	if err != nil || temperature < 0 || temperature > 2 {
+		return tg.sendTelegramMessage(ctx, "Temperature must be a number between 0 and 2, or auto.")
+	}
+	err = tg.db.SetChatSetting(chatID, chatTemperatureSetting, strconv.FormatFloat(temperature, 'f', -1, 32))
+	if err != nil {
+		return WrapError("failed to set chat temperature", err)
+	}
+	return tg.sendTelegramMessage(ctx, "Temperature set to "+arg+".")
+}
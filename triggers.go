@@ -0,0 +1,127 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// chatTriggersSetting is the chat settings key holding the trigger words of a chat.
+const chatTriggersSetting = "trigger_words"
+
+// ChatCooldown allows an action once per window in each chat.
+type ChatCooldown struct {
+	mu   sync.Mutex
+	last map[ChatID]time.Time // Time the action was last allowed per chat
+}
+
+// NewChatCooldown creates a new per-chat cooldown.
+func NewChatCooldown() *ChatCooldown {
+	return &ChatCooldown{last: make(map[ChatID]time.Time)}
+}
+
+// allow reports whether the action may run in a chat, starting a new window when so.
+func (cooldown *ChatCooldown) allow(chatID ChatID, window time.Duration) bool {
+	cooldown.mu.Lock()
+	defer cooldown.mu.Unlock()
+
+	if time.Since(cooldown.last[chatID]) < window {
+		return false
+	}
+	cooldown.last[chatID] = time.Now()
+	return true
+}
+
+// compileTriggers builds a case-insensitive pattern from comma-separated trigger entries. Plain
+// entries match whole words, and entries wrapped in slashes are regular expressions.
+func compileTriggers(value string) (*regexp.Regexp, error) {
+	var alternatives []string
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if len(entry) > 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") {
+			alternatives = append(alternatives, "(?:"+entry[1:len(entry)-1]+")")
+			continue
+		}
+		alternatives = append(alternatives, `\b`+regexp.QuoteMeta(entry)+`\b`)
+	}
+	if len(alternatives) == 0 {
+		return nil, WrapError("no trigger words given")
+	}
+	pattern, err := regexp.Compile("(?i)" + strings.Join(alternatives, "|"))
+	if err != nil {
+		return nil, WrapError("invalid trigger pattern", err)
+	}
+	return pattern, nil
+}
+
+// matchesTrigger reports whether the effective message contains one of the trigger words of the
+// chat and the trigger cooldown of the chat has passed. Groups only deliver plain messages to the
+// bot when its privacy mode is disabled.
+func (tg *Telegram) matchesTrigger(ctx *ext.Context) bool {
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	value, ok, err := tg.db.GetChatSetting(chatID, chatTriggersSetting)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to get trigger words")
+		return false
+	}
+	if !ok {
+		return false
+	}
+	pattern, err := compileTriggers(value)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Invalid trigger words")
+		return false
+	}
+	if !pattern.MatchString(ctx.EffectiveMessage.Text) {
+		return false
+	}
+	return tg.triggers.allow(chatID, time.Duration(tg.config.TelegramTriggerCooldown*float64(time.Second)))
+}
+
+// handleMrlTriggersRequest processes the /mrl_triggers command, which shows, sets, or with "off"
+// removes the trigger words of the chat.
+func (tg *Telegram) handleMrlTriggersRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_TRIGGERS request")
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	usage := "Usage: /mrl_triggers <word, word, /regex/|off>"
+	arg := commandText(ctx.EffectiveMessage.Text)
+	switch arg {
+	case "":
+		current, ok, err := tg.db.GetChatSetting(chatID, chatTriggersSetting)
+		if err != nil {
+			return WrapError("failed to get trigger words", err)
+		}
+		if !ok {
+			current = "none"
+		}
+		return tg.sendTelegramMessage(ctx, "Trigger words: "+current+"\n"+usage)
+	case "off":
+		err := tg.db.DeleteChatSetting(chatID, chatTriggersSetting)
+		if err != nil {
+			return WrapError("failed to delete trigger words", err)
+		}
+		return tg.sendTelegramMessage(ctx, "Trigger words removed.")
+	}
+
+	_, err := compileTriggers(arg)
+	if err != nil {
+		log.Info().Err(err).Str("triggers", arg).Msg("Rejected invalid trigger words")
+		return tg.sendTelegramMessage(ctx, "Invalid trigger words.\n"+usage)
+	}
+	err = tg.db.SetChatSetting(chatID, chatTriggersSetting, arg)
+	if err != nil {
+		return WrapError("failed to set trigger words", err)
+	}
+	return tg.sendTelegramMessage(ctx, "Trigger words set to: "+arg)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// chatVerbositySetting is the chat setting holding the verbosity of replies, normal when unset.
+const chatVerbositySetting = "verbosity"
+
+// Verbosity levels of the replies in a chat.
+const (
+	VerbosityBrief    = "brief"    // Short replies, capped at briefMaxTokens
+	VerbosityNormal   = "normal"   // Replies as the instruction asks
+	VerbosityDetailed = "detailed" // Thorough replies
+)
+
+// briefMaxTokens caps the length of replies in chats with brief verbosity, so replies that ignore
+// the instruction are still cut short.
+const briefMaxTokens = 400
+
+// verbosityInstructions holds the instruction added to the system instruction for each verbosity.
+var verbosityInstructions = map[string]string{
+	VerbosityBrief:    "Keep your replies brief: answer in one to three short sentences, without lists, headings, or introductions, unless asked for more.",
+	VerbosityDetailed: "Give thorough, detailed replies, explaining your reasoning and covering the relevant details and examples.",
+}
+
+// chatVerbosity returns the verbosity of the replies in a chat.
+func (tg *Telegram) chatVerbosity(chatID ChatID) string {
+	value, ok, err := tg.db.GetChatSetting(chatID, chatVerbositySetting)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", int64(chatID)).Msg("Failed to get chat verbosity")
+		return VerbosityNormal
+	}
+	if _, known := verbosityInstructions[value]; !ok || !known {
+		return VerbosityNormal
+	}
+	return value
+}
+
+// verbosityMaxTokens returns the maximum number of tokens of a reply in a chat, zero when
+// unlimited.
+func (tg *Telegram) verbosityMaxTokens(chatID ChatID) int {
+	if tg.chatVerbosity(chatID) == VerbosityBrief {
+		return briefMaxTokens
+	}
+	return 0
+}
+
+// handleMrlVerbosityRequest processes the /mrl_verbosity command, which shows the verbosity of the
+// replies in the chat or sets it.
+func (tg *Telegram) handleMrlVerbosityRequest(ctx *ext.Context) error {
+	if ctx.EffectiveMessage == nil {
+		return WrapError("effective message is nil")
+	}
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("update_id", ctx.Update.UpdateId).Msg("Received MRL_VERBOSITY request")
+
+	usage := "/mrl_verbosity [brief|normal|detailed]"
+	args, err := expectArgs(ctx.EffectiveMessage.Text, 0, 1)
+	if err != nil {
+		return tg.sendUsage(ctx, usage, err)
+	}
+
+	chatID := ChatID(ctx.EffectiveMessage.Chat.Id)
+	switch args.Arg(0) {
+	case "":
+		return tg.sendTelegramMessage(ctx, "Verbosity: "+tg.chatVerbosity(chatID))
+	case VerbosityNormal:
+		err = tg.db.DeleteChatSetting(chatID, chatVerbositySetting)
+		if err != nil {
+			return WrapError("failed to delete chat verbosity", err)
+		}
+	case VerbosityBrief, VerbosityDetailed:
+		err = tg.db.SetChatSetting(chatID, chatVerbositySetting, args.Arg(0))
+		if err != nil {
+			return WrapError("failed to set chat verbosity", err)
+		}
+	default:
+		return tg.sendUsage(ctx, usage, nil)
+	}
+	return tg.sendTelegramMessage(ctx, "Verbosity set to "+args.Arg(0)+".")
+}
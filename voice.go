@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/rs/zerolog/log"
+)
+
+// voiceMaxSize is the largest voice file downloaded, the limit of the Bot API for downloads.
+const voiceMaxSize = 20 << 20
+
+// isVoiceMessage reports whether a message is a voice message or an audio file.
+func isVoiceMessage(msg *gotgbot.Message) bool {
+	return msg.Voice != nil || msg.Audio != nil
+}
+
+// recording is the audio of a voice message or an audio file.
+type recording struct {
+	fileID       string // ID to download the file with
+	fileUniqueID string // ID that stays the same for the same file
+	fileName     string // Name of the file, whose extension tells transcription its format
+	duration     int64  // Duration in seconds
+	fileSize     int64  // Size in bytes, zero when unknown
+}
+
+// messageRecording returns the audio of a voice message or an audio file.
+func messageRecording(msg *gotgbot.Message) recording {
+	if msg.Voice != nil {
+		return recording{
+			fileID:       msg.Voice.FileId,
+			fileUniqueID: msg.Voice.FileUniqueId,
+			fileName:     fmt.Sprintf("voice-%d.ogg", msg.MessageId),
+			duration:     msg.Voice.Duration,
+			fileSize:     msg.Voice.FileSize,
+		}
+	}
+	name := msg.Audio.FileName
+	if name == "" {
+		name = fmt.Sprintf("audio-%d.mp3", msg.MessageId)
+	}
+	return recording{
+		fileID:       msg.Audio.FileId,
+		fileUniqueID: msg.Audio.FileUniqueId,
+		fileName:     name,
+		duration:     msg.Audio.Duration,
+		fileSize:     msg.Audio.FileSize,
+	}
+}
+
+// answersVoice reports whether a voice message is addressed to the bot: sent in a private chat,
+// as a reply to the bot, or while focus mode is on.
+func (tg *Telegram) answersVoice(ctx *ext.Context) bool {
+	msg := ctx.EffectiveMessage
+	if msg.From == nil || msg.From.IsBot {
+		return false
+	}
+	if msg.Chat.Type == "private" {
+		return true
+	}
+	if msg.ReplyToMessage != nil && msg.ReplyToMessage.From != nil && msg.ReplyToMessage.From.Id == tg.bot.Id {
+		return true
+	}
+	return tg.inFocus(ctx)
+}
+
+// handleVoiceMessage transcribes a voice message or audio file addressed to the bot and answers
+// the transcript like a text message, which also stores it in the chat history. Blocked and
+// throttled users are turned away before anything is downloaded or transcribed.
+func (tg *Telegram) handleVoiceMessage(b *gotgbot.Bot, ctx *ext.Context) error {
+	if !tg.config.VoiceMessages || tg.oai == nil {
+		return nil
+	}
+	tg.clearChatBlocked(ChatID(ctx.EffectiveMessage.Chat.Id))
+	tg.recordActivity(ctx)
+	if !tg.answersVoice(ctx) {
+		return nil
+	}
+	receivedAt := time.Now()
+	voice := messageRecording(ctx.EffectiveMessage)
+	log.Info().Int64("user_id", ctx.EffectiveMessage.From.Id).Str("username", ctx.EffectiveMessage.From.Username).Int64("duration", voice.duration).Int64("update_id", ctx.Update.UpdateId).Msg("Received voice message")
+
+	// The file ID stands in for the text, so resending the same recording counts as a repeat
+	if !tg.admitMessage(ctx, "voice:"+voice.fileUniqueID) {
+		return nil
+	}
+	if voice.duration > int64(tg.config.VoiceMaxDuration) || voice.fileSize > voiceMaxSize {
+		return tg.sendTelegramMessage(ctx, fmt.Sprintf("Áudio muito longo. Envie mensagens de até %d segundos.", tg.config.VoiceMaxDuration))
+	}
+
+	_, err := tg.bot.SendChatAction(ctx.EffectiveChat.Id, "typing", nil)
+	if err != nil {
+		return WrapError("failed to send chat action", err)
+	}
+	audio, err := tg.downloadFile(voice.fileID, voiceMaxSize)
+	if err != nil {
+		return WrapError("failed to download voice message", err)
+	}
+	transcript, err := tg.oai.Transcribe(voice.fileName, audio)
+	if err != nil {
+		return WrapError("failed to transcribe voice message", err)
+	}
+	if transcript == "" {
+		return tg.sendTelegramMessage(ctx, "Não consegui entender o áudio.")
+	}
+	log.Info().Int64("chat_id", ctx.EffectiveMessage.Chat.Id).Int64("message_id", ctx.EffectiveMessage.MessageId).Int("length", len(transcript)).Msg("Transcribed voice message")
+	return tg.answerAdmittedMessage(ctx, transcript, receivedAt)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Webhook event names.
+const (
+	EventHistoryReset = "history_reset" // Chat history was cleared by the admin
+	EventSLOBreached  = "slo_breached"  // Reply latency SLO was breached in a chat
+	EventChatBlocked  = "chat_blocked"  // The bot was blocked or removed from a chat
+	EventChatMigrated = "chat_migrated" // A group was migrated to a supergroup
+	EventModeration   = "moderation"    // A user or chat was throttled, or a user was blocked or unblocked
+	EventBudgetHit    = "budget_hit"    // A daily token budget ran out
+)
+
+// webhookAttempts is the number of delivery attempts per webhook.
+const webhookAttempts = 3
+
+// Webhooks posts JSON notifications of bot events to the configured URLs.
+type Webhooks struct {
+	URLs       []string        // URLs notified of events
+	Secret     string          // Secret used to sign payloads, unsigned if empty
+	Events     map[string]bool // Events sent to the URLs, all events if empty
+	httpClient *http.Client
+	mu         sync.Mutex
+	closed     bool           // Whether new events are dropped since shutdown started
+	pending    sync.WaitGroup // Deliveries in progress
+}
+
+// NewWebhooks creates a webhook notifier from the configuration.
+func NewWebhooks(config *Config) *Webhooks {
+	events := make(map[string]bool)
+	for _, event := range config.WebhookEvents {
+		events[event] = true
+	}
+	return &Webhooks{
+		URLs:       config.WebhookURLs,
+		Secret:     config.WebhookSecret,
+		Events:     events,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notify sends an event to the webhook URLs in the background.
+func (webhooks *Webhooks) Notify(event string, data map[string]interface{}) {
+	if len(webhooks.URLs) == 0 || (len(webhooks.Events) > 0 && !webhooks.Events[event]) {
+		return
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"event":     event,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"data":      data,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("event", event).Msg("Failed to marshal webhook payload")
+		return
+	}
+
+	webhooks.mu.Lock()
+	defer webhooks.mu.Unlock()
+	if webhooks.closed {
+		log.Warn().Str("event", event).Msg("Dropping webhook event during shutdown")
+		return
+	}
+	for _, url := range webhooks.URLs {
+		webhooks.pending.Add(1)
+		go func(url string) {
+			defer webhooks.pending.Done()
+			err := webhooks.deliver(url, body)
+			if err != nil {
+				log.Error().Err(err).Str("event", event).Str("url", url).Msg("Failed to deliver webhook")
+			}
+		}(url)
+	}
+}
+
+// Close drops the events notified from now on and waits for the deliveries in progress to
+// finish.
+func (webhooks *Webhooks) Close() {
+	webhooks.mu.Lock()
+	webhooks.closed = true
+	webhooks.mu.Unlock()
+	webhooks.pending.Wait()
+}
+
+// deliver posts a payload to a URL, retrying with exponential backoff.
+func (webhooks *Webhooks) deliver(url string, body []byte) error {
+	var lastErr error
+	backoff := time.Second
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		lastErr = webhooks.post(url, body)
+		if lastErr == nil {
+			return nil
+		}
+		if attempt < webhookAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return WrapError(fmt.Sprintf("giving up after %d attempts", webhookAttempts), lastErr)
+}
+
+// post sends a single webhook request, signing the payload when a secret is configured.
+func (webhooks *Webhooks) post(url string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return WrapError("failed to create request", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if webhooks.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(webhooks.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Murailobot-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhooks.httpClient.Do(req)
+	if err != nil {
+		return WrapError("failed to send request", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return WrapError(fmt.Sprintf("unexpected status code %d", resp.StatusCode))
+	}
+	return nil
+}